@@ -19,20 +19,34 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	GoKvs_Get_FullMethodName  = "/kvs.GoKvs/Get"
-	GoKvs_Set_FullMethodName  = "/kvs.GoKvs/Set"
-	GoKvs_Del_FullMethodName  = "/kvs.GoKvs/Del"
-	GoKvs_Keys_FullMethodName = "/kvs.GoKvs/Keys"
+	GoKvs_Get_FullMethodName           = "/kvs.GoKvs/Get"
+	GoKvs_Set_FullMethodName           = "/kvs.GoKvs/Set"
+	GoKvs_Del_FullMethodName           = "/kvs.GoKvs/Del"
+	GoKvs_Keys_FullMethodName          = "/kvs.GoKvs/Keys"
+	GoKvs_Scan_FullMethodName          = "/kvs.GoKvs/Scan"
+	GoKvs_Batch_FullMethodName         = "/kvs.GoKvs/Batch"
+	GoKvs_Watch_FullMethodName         = "/kvs.GoKvs/Watch"
+	GoKvs_WatchKey_FullMethodName      = "/kvs.GoKvs/WatchKey"
+	GoKvs_WaitForChange_FullMethodName = "/kvs.GoKvs/WaitForChange"
 )
 
 // GoKvsClient is the client API for GoKvs service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type GoKvsClient interface {
-	Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValResponse, error)
-	Set(ctx context.Context, in *KeyValRequest, opts ...grpc.CallOption) (*EmptyResponse, error)
-	Del(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*EmptyResponse, error)
-	Keys(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ValResponse, error)
+	Set(ctx context.Context, in *KeyValRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Del(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Keys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (GoKvs_ScanClient, error)
+	// Writes many keys in one call, in order (leader only)
+	Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// Streams every change applied on this node (change data capture)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (GoKvs_WatchClient, error)
+	// Streams each change to one key, or to every key with a prefix
+	WatchKey(ctx context.Context, in *WatchKeyRequest, opts ...grpc.CallOption) (GoKvs_WatchKeyClient, error)
+	// Blocks until a key changes after a sequence, or the timeout passes (long poll)
+	WaitForChange(ctx context.Context, in *WaitForChangeRequest, opts ...grpc.CallOption) (*WaitForChangeResponse, error)
 }
 
 type goKvsClient struct {
@@ -43,7 +57,7 @@ func NewGoKvsClient(cc grpc.ClientConnInterface) GoKvsClient {
 	return &goKvsClient{cc}
 }
 
-func (c *goKvsClient) Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValResponse, error) {
+func (c *goKvsClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ValResponse, error) {
 	out := new(ValResponse)
 	err := c.cc.Invoke(ctx, GoKvs_Get_FullMethodName, in, out, opts...)
 	if err != nil {
@@ -52,8 +66,8 @@ func (c *goKvsClient) Get(ctx context.Context, in *KeyRequest, opts ...grpc.Call
 	return out, nil
 }
 
-func (c *goKvsClient) Set(ctx context.Context, in *KeyValRequest, opts ...grpc.CallOption) (*EmptyResponse, error) {
-	out := new(EmptyResponse)
+func (c *goKvsClient) Set(ctx context.Context, in *KeyValRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
 	err := c.cc.Invoke(ctx, GoKvs_Set_FullMethodName, in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -61,8 +75,8 @@ func (c *goKvsClient) Set(ctx context.Context, in *KeyValRequest, opts ...grpc.C
 	return out, nil
 }
 
-func (c *goKvsClient) Del(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*EmptyResponse, error) {
-	out := new(EmptyResponse)
+func (c *goKvsClient) Del(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
 	err := c.cc.Invoke(ctx, GoKvs_Del_FullMethodName, in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -70,7 +84,7 @@ func (c *goKvsClient) Del(ctx context.Context, in *KeyRequest, opts ...grpc.Call
 	return out, nil
 }
 
-func (c *goKvsClient) Keys(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+func (c *goKvsClient) Keys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
 	out := new(KeysResponse)
 	err := c.cc.Invoke(ctx, GoKvs_Keys_FullMethodName, in, out, opts...)
 	if err != nil {
@@ -79,14 +93,137 @@ func (c *goKvsClient) Keys(ctx context.Context, in *EmptyRequest, opts ...grpc.C
 	return out, nil
 }
 
+func (c *goKvsClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (GoKvs_ScanClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GoKvs_ServiceDesc.Streams[0], GoKvs_Scan_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &goKvsScanClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GoKvs_ScanClient interface {
+	Recv() (*KeyValue, error)
+	grpc.ClientStream
+}
+
+type goKvsScanClient struct {
+	grpc.ClientStream
+}
+
+func (x *goKvsScanClient) Recv() (*KeyValue, error) {
+	m := new(KeyValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *goKvsClient) Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, GoKvs_Batch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goKvsClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (GoKvs_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GoKvs_ServiceDesc.Streams[1], GoKvs_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &goKvsWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GoKvs_WatchClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type goKvsWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *goKvsWatchClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *goKvsClient) WatchKey(ctx context.Context, in *WatchKeyRequest, opts ...grpc.CallOption) (GoKvs_WatchKeyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GoKvs_ServiceDesc.Streams[2], GoKvs_WatchKey_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &goKvsWatchKeyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GoKvs_WatchKeyClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type goKvsWatchKeyClient struct {
+	grpc.ClientStream
+}
+
+func (x *goKvsWatchKeyClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *goKvsClient) WaitForChange(ctx context.Context, in *WaitForChangeRequest, opts ...grpc.CallOption) (*WaitForChangeResponse, error) {
+	out := new(WaitForChangeResponse)
+	err := c.cc.Invoke(ctx, GoKvs_WaitForChange_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GoKvsServer is the server API for GoKvs service.
 // All implementations must embed UnimplementedGoKvsServer
 // for forward compatibility
 type GoKvsServer interface {
-	Get(context.Context, *KeyRequest) (*ValResponse, error)
-	Set(context.Context, *KeyValRequest) (*EmptyResponse, error)
-	Del(context.Context, *KeyRequest) (*EmptyResponse, error)
-	Keys(context.Context, *EmptyRequest) (*KeysResponse, error)
+	Get(context.Context, *GetRequest) (*ValResponse, error)
+	Set(context.Context, *KeyValRequest) (*WriteResponse, error)
+	Del(context.Context, *KeyRequest) (*WriteResponse, error)
+	Keys(context.Context, *KeysRequest) (*KeysResponse, error)
+	Scan(*ScanRequest, GoKvs_ScanServer) error
+	// Writes many keys in one call, in order (leader only)
+	Batch(context.Context, *BatchRequest) (*BatchResponse, error)
+	// Streams every change applied on this node (change data capture)
+	Watch(*WatchRequest, GoKvs_WatchServer) error
+	// Streams each change to one key, or to every key with a prefix
+	WatchKey(*WatchKeyRequest, GoKvs_WatchKeyServer) error
+	// Blocks until a key changes after a sequence, or the timeout passes (long poll)
+	WaitForChange(context.Context, *WaitForChangeRequest) (*WaitForChangeResponse, error)
 	mustEmbedUnimplementedGoKvsServer()
 }
 
@@ -94,18 +231,33 @@ type GoKvsServer interface {
 type UnimplementedGoKvsServer struct {
 }
 
-func (UnimplementedGoKvsServer) Get(context.Context, *KeyRequest) (*ValResponse, error) {
+func (UnimplementedGoKvsServer) Get(context.Context, *GetRequest) (*ValResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
-func (UnimplementedGoKvsServer) Set(context.Context, *KeyValRequest) (*EmptyResponse, error) {
+func (UnimplementedGoKvsServer) Set(context.Context, *KeyValRequest) (*WriteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
 }
-func (UnimplementedGoKvsServer) Del(context.Context, *KeyRequest) (*EmptyResponse, error) {
+func (UnimplementedGoKvsServer) Del(context.Context, *KeyRequest) (*WriteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Del not implemented")
 }
-func (UnimplementedGoKvsServer) Keys(context.Context, *EmptyRequest) (*KeysResponse, error) {
+func (UnimplementedGoKvsServer) Keys(context.Context, *KeysRequest) (*KeysResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Keys not implemented")
 }
+func (UnimplementedGoKvsServer) Scan(*ScanRequest, GoKvs_ScanServer) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedGoKvsServer) Batch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Batch not implemented")
+}
+func (UnimplementedGoKvsServer) Watch(*WatchRequest, GoKvs_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedGoKvsServer) WatchKey(*WatchKeyRequest, GoKvs_WatchKeyServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchKey not implemented")
+}
+func (UnimplementedGoKvsServer) WaitForChange(context.Context, *WaitForChangeRequest) (*WaitForChangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitForChange not implemented")
+}
 func (UnimplementedGoKvsServer) mustEmbedUnimplementedGoKvsServer() {}
 
 // UnsafeGoKvsServer may be embedded to opt out of forward compatibility for this service.
@@ -120,7 +272,7 @@ func RegisterGoKvsServer(s grpc.ServiceRegistrar, srv GoKvsServer) {
 }
 
 func _GoKvs_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(KeyRequest)
+	in := new(GetRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -132,7 +284,7 @@ func _GoKvs_Get_Handler(srv interface{}, ctx context.Context, dec func(interface
 		FullMethod: GoKvs_Get_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(GoKvsServer).Get(ctx, req.(*KeyRequest))
+		return srv.(GoKvsServer).Get(ctx, req.(*GetRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -174,7 +326,7 @@ func _GoKvs_Del_Handler(srv interface{}, ctx context.Context, dec func(interface
 }
 
 func _GoKvs_Keys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(EmptyRequest)
+	in := new(KeysRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -186,7 +338,106 @@ func _GoKvs_Keys_Handler(srv interface{}, ctx context.Context, dec func(interfac
 		FullMethod: GoKvs_Keys_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(GoKvsServer).Keys(ctx, req.(*EmptyRequest))
+		return srv.(GoKvsServer).Keys(ctx, req.(*KeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GoKvs_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoKvsServer).Scan(m, &goKvsScanServer{stream})
+}
+
+type GoKvs_ScanServer interface {
+	Send(*KeyValue) error
+	grpc.ServerStream
+}
+
+type goKvsScanServer struct {
+	grpc.ServerStream
+}
+
+func (x *goKvsScanServer) Send(m *KeyValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GoKvs_Batch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoKvsServer).Batch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GoKvs_Batch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoKvsServer).Batch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GoKvs_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoKvsServer).Watch(m, &goKvsWatchServer{stream})
+}
+
+type GoKvs_WatchServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type goKvsWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *goKvsWatchServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GoKvs_WatchKey_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchKeyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoKvsServer).WatchKey(m, &goKvsWatchKeyServer{stream})
+}
+
+type GoKvs_WatchKeyServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type goKvsWatchKeyServer struct {
+	grpc.ServerStream
+}
+
+func (x *goKvsWatchKeyServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GoKvs_WaitForChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitForChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoKvsServer).WaitForChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GoKvs_WaitForChange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoKvsServer).WaitForChange(ctx, req.(*WaitForChangeRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -214,7 +465,31 @@ var GoKvs_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Keys",
 			Handler:    _GoKvs_Keys_Handler,
 		},
+		{
+			MethodName: "Batch",
+			Handler:    _GoKvs_Batch_Handler,
+		},
+		{
+			MethodName: "WaitForChange",
+			Handler:    _GoKvs_WaitForChange_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _GoKvs_Scan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _GoKvs_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchKey",
+			Handler:       _GoKvs_WatchKey_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/proto/kvs.proto",
 }
@@ -19,7 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Replication_StreamReplication_FullMethodName = "/kvs.Replication/StreamReplication"
+	Replication_StreamReplication_FullMethodName        = "/kvs.Replication/StreamReplication"
+	Replication_StreamReplicationBatched_FullMethodName = "/kvs.Replication/StreamReplicationBatched"
+	Replication_Heartbeat_FullMethodName                = "/kvs.Replication/Heartbeat"
+	Replication_ReadIndex_FullMethodName                = "/kvs.Replication/ReadIndex"
+	Replication_MerkleTree_FullMethodName               = "/kvs.Replication/MerkleTree"
+	Replication_FetchRanges_FullMethodName              = "/kvs.Replication/FetchRanges"
 )
 
 // ReplicationClient is the client API for Replication service.
@@ -28,6 +33,16 @@ const (
 type ReplicationClient interface {
 	// Follower calls this to receive stream of commands from leader
 	StreamReplication(ctx context.Context, in *FollowerInfo, opts ...grpc.CallOption) (Replication_StreamReplicationClient, error)
+	// Same stream, several commands per message with negotiated compression
+	StreamReplicationBatched(ctx context.Context, in *FollowerInfo, opts ...grpc.CallOption) (Replication_StreamReplicationBatchedClient, error)
+	// Follower calls this periodically while streaming; keeps the leader's lease alive
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// Returns the leader's latest sequence once leadership is confirmed (linearizable reads)
+	ReadIndex(ctx context.Context, in *ReadIndexRequest, opts ...grpc.CallOption) (*ReadIndexResponse, error)
+	// Anti-entropy: Merkle tree over key hash ranges at the node's current sequence
+	MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error)
+	// Anti-entropy: key/value pairs in the given ranges, for comparison and repair
+	FetchRanges(ctx context.Context, in *FetchRangesRequest, opts ...grpc.CallOption) (*FetchRangesResponse, error)
 }
 
 type replicationClient struct {
@@ -70,12 +85,90 @@ func (x *replicationStreamReplicationClient) Recv() (*ReplicationCommand, error)
 	return m, nil
 }
 
+func (c *replicationClient) StreamReplicationBatched(ctx context.Context, in *FollowerInfo, opts ...grpc.CallOption) (Replication_StreamReplicationBatchedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[1], Replication_StreamReplicationBatched_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationStreamReplicationBatchedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_StreamReplicationBatchedClient interface {
+	Recv() (*ReplicationBatch, error)
+	grpc.ClientStream
+}
+
+type replicationStreamReplicationBatchedClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationStreamReplicationBatchedClient) Recv() (*ReplicationBatch, error) {
+	m := new(ReplicationBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *replicationClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, Replication_Heartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) ReadIndex(ctx context.Context, in *ReadIndexRequest, opts ...grpc.CallOption) (*ReadIndexResponse, error) {
+	out := new(ReadIndexResponse)
+	err := c.cc.Invoke(ctx, Replication_ReadIndex_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error) {
+	out := new(MerkleTreeResponse)
+	err := c.cc.Invoke(ctx, Replication_MerkleTree_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchRanges(ctx context.Context, in *FetchRangesRequest, opts ...grpc.CallOption) (*FetchRangesResponse, error) {
+	out := new(FetchRangesResponse)
+	err := c.cc.Invoke(ctx, Replication_FetchRanges_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ReplicationServer is the server API for Replication service.
 // All implementations must embed UnimplementedReplicationServer
 // for forward compatibility
 type ReplicationServer interface {
 	// Follower calls this to receive stream of commands from leader
 	StreamReplication(*FollowerInfo, Replication_StreamReplicationServer) error
+	// Same stream, several commands per message with negotiated compression
+	StreamReplicationBatched(*FollowerInfo, Replication_StreamReplicationBatchedServer) error
+	// Follower calls this periodically while streaming; keeps the leader's lease alive
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// Returns the leader's latest sequence once leadership is confirmed (linearizable reads)
+	ReadIndex(context.Context, *ReadIndexRequest) (*ReadIndexResponse, error)
+	// Anti-entropy: Merkle tree over key hash ranges at the node's current sequence
+	MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error)
+	// Anti-entropy: key/value pairs in the given ranges, for comparison and repair
+	FetchRanges(context.Context, *FetchRangesRequest) (*FetchRangesResponse, error)
 	mustEmbedUnimplementedReplicationServer()
 }
 
@@ -86,6 +179,21 @@ type UnimplementedReplicationServer struct {
 func (UnimplementedReplicationServer) StreamReplication(*FollowerInfo, Replication_StreamReplicationServer) error {
 	return status.Errorf(codes.Unimplemented, "method StreamReplication not implemented")
 }
+func (UnimplementedReplicationServer) StreamReplicationBatched(*FollowerInfo, Replication_StreamReplicationBatchedServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamReplicationBatched not implemented")
+}
+func (UnimplementedReplicationServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedReplicationServer) ReadIndex(context.Context, *ReadIndexRequest) (*ReadIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadIndex not implemented")
+}
+func (UnimplementedReplicationServer) MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleTree not implemented")
+}
+func (UnimplementedReplicationServer) FetchRanges(context.Context, *FetchRangesRequest) (*FetchRangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchRanges not implemented")
+}
 func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
 
 // UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
@@ -120,19 +228,134 @@ func (x *replicationStreamReplicationServer) Send(m *ReplicationCommand) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Replication_StreamReplicationBatched_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FollowerInfo)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).StreamReplicationBatched(m, &replicationStreamReplicationBatchedServer{stream})
+}
+
+type Replication_StreamReplicationBatchedServer interface {
+	Send(*ReplicationBatch) error
+	grpc.ServerStream
+}
+
+type replicationStreamReplicationBatchedServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationStreamReplicationBatchedServer) Send(m *ReplicationBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Replication_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_ReadIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).ReadIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_ReadIndex_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).ReadIndex(ctx, req.(*ReadIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_MerkleTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).MerkleTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_MerkleTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).MerkleTree(ctx, req.(*MerkleTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchRanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).FetchRanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_FetchRanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).FetchRanges(ctx, req.(*FetchRangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Replication_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "kvs.Replication",
 	HandlerType: (*ReplicationServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Replication_Heartbeat_Handler,
+		},
+		{
+			MethodName: "ReadIndex",
+			Handler:    _Replication_ReadIndex_Handler,
+		},
+		{
+			MethodName: "MerkleTree",
+			Handler:    _Replication_MerkleTree_Handler,
+		},
+		{
+			MethodName: "FetchRanges",
+			Handler:    _Replication_FetchRanges_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "StreamReplication",
 			Handler:       _Replication_StreamReplication_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StreamReplicationBatched",
+			Handler:       _Replication_StreamReplicationBatched_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "api/proto/replication.proto",
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	pb "go-kvs/api/proto/pb"
+	g "go-kvs/internal/client"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/keyring"
+	"go-kvs/internal/recovery"
+	"go-kvs/internal/server/wal"
+	"go-kvs/internal/snapshot"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/status"
+)
+
+// runCommand runs a subcommand given on the command line instead of the
+// interactive prompt, and returns the process exit code
+func runCommand(args []string, client *g.KvsClient, admin *g.AdminClient, consistency pb.Consistency, namespace string) int {
+	switch args[0] {
+	case "export":
+		return export(client, consistency, namespace, args[1:])
+	case "import":
+		return importFile(client, namespace, args[1:])
+	case "backup":
+		return backup(admin, args[1:])
+	case "restore":
+		return restore(args[1:])
+	case "recover":
+		return recoverData(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Commands: export, import, backup, restore, recover\n", args[0])
+		return 2
+	}
+}
+
+// backup downloads a snapshot of the server into a file. The file only
+// appears once it is complete and its checksum verified.
+func backup(admin *g.AdminClient, args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	keyfile := fs.String("keyfile", "", "Keyfile to verify an encrypted snapshot with")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: client [--addr host:port] backup [--keyfile file] {file}")
+		return 2
+	}
+	path := fs.Arg(0)
+	keys, err := loadKeys(*keyfile)
+	if err != nil {
+		return fail(err)
+	}
+
+	stream, err := admin.Snapshot(context.Background(), &pb.SnapshotRequest{})
+	if err != nil {
+		return fail(err)
+	}
+
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer os.Remove(tmpPath)
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return fail(err)
+		}
+		if _, err := out.Write(chunk.Data); err != nil {
+			out.Close()
+			return fail(err)
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		return fail(err)
+	}
+
+	// The node encrypts snapshots if it has a keyfile; without the same
+	// keys here, only the download itself can be checked
+	meta, err := snapshot.Verify(tmpPath, keys)
+	if err == snapshot.ErrNoKeyring {
+		if err := os.Rename(tmpPath, path); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Backed up an encrypted snapshot to %s (not verified, pass --keyfile to verify)\n", path)
+		return 0
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Backed up %d keys in %d namespaces at seq=%d to %s\n", meta.Count, len(meta.Namespaces)+1, meta.Sequence, path)
+	return 0
+}
+
+// restore seeds a new data directory from a snapshot file, offline
+func restore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("data-dir", "", "Data directory of the node to seed (must be empty)")
+	keyfile := fs.String("keyfile", "", "Keyfile of the node: decrypts the snapshot and encrypts the node's data")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: client restore --data-dir {dir} [--keyfile file] {file}")
+		return 2
+	}
+	keys, err := loadKeys(*keyfile)
+	if err != nil {
+		return fail(err)
+	}
+
+	// The storage engine logs every append
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	dataDir, err := datadir.Open(*dir)
+	if err != nil {
+		return fail(err)
+	}
+	defer dataDir.Close()
+
+	meta, err := snapshot.Restore(fs.Arg(0), dataDir, wal.DefaultSegmentSize, keys)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Restored %d keys at seq=%d (cluster %s) into %s\n", meta.Count, meta.Sequence, meta.ClusterID, *dir)
+	fmt.Println("Start the node with this --data-dir; it replicates from the snapshot's sequence on")
+	return 0
+}
+
+// recoverData builds a new data directory with the state as of a sequence or
+// time, from a base snapshot and the archived WAL, offline
+func recoverData(args []string) int {
+	fs := flag.NewFlagSet("recover", flag.ContinueOnError)
+	dir := fs.String("data-dir", "", "Data directory to build (must be empty)")
+	archiveDir := fs.String("archive", "", "Directory of archived WAL segments (the server's --wal-archive-dir)")
+	snapshotPath := fs.String("snapshot", "", "Base snapshot taken with backup (optional if the archive starts at the beginning of the log)")
+	toSeq := fs.Int64("to-seq", 0, "Recover up to and including this sequence")
+	toTime := fs.String("to-time", "", "Recover writes committed up to this time (RFC 3339, e.g. 2024-05-01T14:05:00Z)")
+	keyfile := fs.String("keyfile", "", "Keyfile: decrypts the snapshot and archive and encrypts the new data")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" || *archiveDir == "" || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: client recover --data-dir {dir} --archive {dir} [--snapshot {file}] [--to-seq N | --to-time T] [--keyfile file]")
+		return 2
+	}
+	keys, err := loadKeys(*keyfile)
+	if err != nil {
+		return fail(err)
+	}
+
+	target := recovery.Target{Sequence: *toSeq}
+	if *toTime != "" {
+		t, err := time.Parse(time.RFC3339, *toTime)
+		if err != nil {
+			return fail(fmt.Errorf("--to-time: %w", err))
+		}
+		target.Time = t
+	}
+
+	// The storage engine logs every append
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	dataDir, err := datadir.Open(*dir)
+	if err != nil {
+		return fail(err)
+	}
+	defer dataDir.Close()
+
+	result, err := recovery.Recover(dataDir, *snapshotPath, *archiveDir, target, wal.DefaultSegmentSize, keys)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Recovered %d keys at seq=%d into %s (snapshot seq=%d, %d writes replayed", result.Keys, result.Sequence, *dir, result.SnapshotSequence, result.Replayed)
+	if !result.Time.IsZero() {
+		fmt.Printf(", last committed %s", result.Time.Format(time.RFC3339Nano))
+	}
+	fmt.Println(")")
+	fmt.Println("Start a leader on this --data-dir with --bootstrap; it forms a new cluster")
+	return 0
+}
+
+// loadKeys loads the keyfile at path, or returns nil if no keyfile was given
+func loadKeys(path string) (*keyring.Keyring, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return keyring.Load(path)
+}
+
+// fail prints err and returns the exit code for a failed command
+func fail(err error) int {
+	if st, ok := status.FromError(err); ok {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", st.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return 1
+}
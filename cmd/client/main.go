@@ -3,25 +3,50 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	pb "go-kvs/api/proto/pb"
 	g "go-kvs/internal/client"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func main() {
-	conn, dialErr := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	addr := flag.String("addr", "localhost:50051", "Server address (writes sent to a follower are redirected to the leader)")
+	consistencyFlag := flag.String("consistency", "session", "Read consistency: stale, session or linearizable")
+	namespace := flag.String("namespace", "", "Namespace of the keys read and written (default: the default namespace)")
+	flag.Parse()
+
+	consistency, ok := pb.Consistency_value[strings.ToUpper(*consistencyFlag)]
+	if !ok {
+		log.Fatal().Msgf("Invalid --consistency %q, expected stale, session or linearizable", *consistencyFlag)
+	}
+	readConsistency := pb.Consistency(consistency)
+
+	conn, dialErr := grpc.Dial(*addr, grpc.WithInsecure())
 	if dialErr != nil {
 		log.Fatal().Msgf("Failed to dial: %v", dialErr)
 	}
 	defer conn.Close()
 
 	client := g.NewKvsClient(conn)
+	defer client.Close()
+	admin := g.NewAdminClient(conn)
+
+	// A command on the command line runs once instead of the prompt
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args(), client, admin, readConsistency, *namespace))
+	}
 
 	scanner := bufio.NewScanner(os.Stdin)
 
@@ -47,7 +72,7 @@ func main() {
 				continue
 			}
 			key := parts[1]
-			res, err := client.Get(context.Background(), &pb.KeyRequest{Key: key})
+			res, err := client.Get(context.Background(), &pb.GetRequest{Namespace: *namespace, Key: key, Consistency: readConsistency})
 			if err != nil {
 				if st, ok := status.FromError(err); ok {
 					fmt.Printf("Error: %s\n", st.Message())
@@ -63,7 +88,7 @@ func main() {
 			}
 			key := parts[1]
 			val := parts[2]
-			_, err := client.Set(context.Background(), &pb.KeyValRequest{Key: key, Val: val})
+			_, err := client.Set(context.Background(), &pb.KeyValRequest{Namespace: *namespace, Key: key, Val: val})
 			if err != nil {
 				if st, ok := status.FromError(err); ok {
 					fmt.Printf("Error: %s\n", st.Message())
@@ -77,7 +102,7 @@ func main() {
 				continue
 			}
 			key := parts[1]
-			_, err := client.Del(context.Background(), &pb.KeyRequest{Key: key})
+			_, err := client.Del(context.Background(), &pb.KeyRequest{Namespace: *namespace, Key: key})
 			if err != nil {
 				if st, ok := status.FromError(err); ok {
 					fmt.Printf("Error: %s\n", st.Message())
@@ -90,7 +115,7 @@ func main() {
 				fmt.Println("Invalid 'keys' command. Usage: keys")
 				continue
 			}
-			res, err := client.Keys(context.Background(), &pb.EmptyRequest{})
+			res, err := client.Keys(context.Background(), &pb.KeysRequest{Namespace: *namespace, Consistency: readConsistency})
 			if err != nil {
 				if st, ok := status.FromError(err); ok {
 					fmt.Printf("Error: %s\n", st.Message())
@@ -106,13 +131,400 @@ func main() {
 				}
 			}
 
+		case "scan":
+			if len(parts) > 2 {
+				fmt.Println("Invalid 'scan' command. Usage: scan [prefix]")
+				continue
+			}
+			prefix := ""
+			if len(parts) == 2 {
+				prefix = parts[1]
+			}
+			stream, err := client.Scan(context.Background(), &pb.ScanRequest{Namespace: *namespace, Prefix: prefix, Consistency: readConsistency})
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			for {
+				kv, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if st, ok := status.FromError(err); ok {
+						fmt.Printf("Error: %s\n", st.Message())
+					}
+					break
+				}
+				fmt.Printf("  %s = %s\n", kv.Key, kv.Value)
+			}
+
+		case "watch":
+			if len(parts) > 3 {
+				fmt.Println("Invalid 'watch' command. Usage: watch [prefix] [after_seq]")
+				continue
+			}
+			req := &pb.WatchRequest{Namespace: *namespace}
+			if len(parts) >= 2 {
+				req.Prefix = parts[1]
+			}
+			if len(parts) == 3 {
+				seq, err := strconv.ParseInt(parts[2], 10, 64)
+				if err != nil {
+					fmt.Printf("Invalid sequence %q\n", parts[2])
+					continue
+				}
+				req.AfterSequence = seq
+			}
+			watchChanges(client, req)
+
+		case "wait":
+			if len(parts) < 2 || len(parts) > 4 {
+				fmt.Println("Invalid 'wait' command. Usage: wait {key} [after_seq] [timeout]")
+				continue
+			}
+			req := &pb.WaitForChangeRequest{Namespace: *namespace, Key: parts[1]}
+			if len(parts) >= 3 {
+				seq, err := strconv.ParseInt(parts[2], 10, 64)
+				if err != nil {
+					fmt.Printf("Invalid sequence %q\n", parts[2])
+					continue
+				}
+				req.AfterSequence = seq
+			}
+			if len(parts) == 4 {
+				timeout, err := time.ParseDuration(parts[3])
+				if err != nil {
+					fmt.Printf("Invalid timeout %q\n", parts[3])
+					continue
+				}
+				req.Timeout = durationpb.New(timeout)
+			}
+			res, err := client.WaitForChange(context.Background(), req)
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			if !res.Changed {
+				fmt.Printf("No change, seq=%d\n", res.Sequence)
+				continue
+			}
+			printChange(res.Event)
+
+		case "cluster":
+			if len(parts) != 1 {
+				fmt.Println("Invalid 'cluster' command. Usage: cluster")
+				continue
+			}
+			res, err := admin.ClusterStatus(context.Background(), &pb.ClusterStatusRequest{})
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			printClusterStatus(res)
+
+		case "lag":
+			if len(parts) != 1 {
+				fmt.Println("Invalid 'lag' command. Usage: lag")
+				continue
+			}
+			res, err := admin.ReplicationStatus(context.Background(), &pb.ReplicationStatusRequest{})
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			if res.IsLeader {
+				fmt.Printf("%s is the leader, seq=%d\n", res.NodeId, res.AppliedSequence)
+				continue
+			}
+			fmt.Printf("%s applied seq=%d, leader %s at seq=%d\n", res.NodeId, res.AppliedSequence, res.LeaderAddr, res.LeaderSequence)
+			fmt.Printf("Lag: %d commands, %.1fs (lagging: %t)\n", res.LagSequences, res.LagSeconds, res.Lagging)
+
+		case "verify":
+			if len(parts) != 1 {
+				fmt.Println("Invalid 'verify' command. Usage: verify")
+				continue
+			}
+			res, err := admin.Verify(context.Background(), &pb.VerifyRequest{Namespace: *namespace})
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			printVerify(res)
+
+		case "pause", "resume":
+			if len(parts) != 1 {
+				fmt.Printf("Invalid '%s' command. Usage: %s\n", parts[0], parts[0])
+				continue
+			}
+			var res *pb.ApplyStatus
+			var err error
+			if parts[0] == "pause" {
+				res, err = admin.PauseReplication(context.Background(), &pb.PauseReplicationRequest{})
+			} else {
+				res, err = admin.ResumeReplication(context.Background(), &pb.ResumeReplicationRequest{})
+			}
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			printApplyStatus(res)
+
+		case "apply-up-to":
+			if len(parts) != 2 {
+				fmt.Println("Invalid 'apply-up-to' command. Usage: apply-up-to {seq}")
+				continue
+			}
+			seq, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				fmt.Printf("Invalid sequence %q\n", parts[1])
+				continue
+			}
+			res, err := admin.ApplyUpTo(context.Background(), &pb.ApplyUpToRequest{Sequence: seq})
+			if err != nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+				continue
+			}
+			printApplyStatus(res)
+
+		case "use":
+			if len(parts) > 2 {
+				fmt.Println("Invalid 'use' command. Usage: use [namespace]")
+				continue
+			}
+			*namespace = ""
+			if len(parts) == 2 {
+				*namespace = parts[1]
+			}
+
+		case "ns":
+			runNamespaceCommand(admin, parts[1:])
+
 		case "exit":
 			fmt.Println("Exiting...")
 			os.Exit(0)
 			return
 
 		default:
-			fmt.Println("Invalid command. Valid commands are: get, set, del, keys, exit")
+			fmt.Println("Invalid command. Valid commands are: get, set, del, keys, scan, watch, wait, use, ns, cluster, lag, verify, pause, resume, apply-up-to, exit")
+		}
+	}
+}
+
+// runNamespaceCommand runs "ns list", "ns create", "ns quota" or "ns drop"
+func runNamespaceCommand(admin *g.AdminClient, args []string) {
+	const usage = "Invalid 'ns' command. Usage: ns list | ns create {name} [max_keys] [max_bytes] | ns quota {name} {max_keys} {max_bytes} | ns drop {name}"
+	if len(args) == 0 {
+		fmt.Println(usage)
+		return
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		res, err := admin.ListNamespaces(context.Background(), &pb.ListNamespacesRequest{})
+		if err != nil {
+			printError(err)
+			return
+		}
+		printNamespaces(res.Namespaces)
+
+	case args[0] == "create" && len(args) >= 2 && len(args) <= 4:
+		quota, err := parseQuota(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		res, err := admin.CreateNamespace(context.Background(), &pb.CreateNamespaceRequest{Name: args[1], Quota: quota})
+		if err != nil {
+			printError(err)
+			return
+		}
+		printNamespaces([]*pb.NamespaceInfo{res})
+
+	case args[0] == "quota" && len(args) == 4:
+		quota, err := parseQuota(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		res, err := admin.SetNamespaceQuota(context.Background(), &pb.SetNamespaceQuotaRequest{Name: args[1], Quota: quota})
+		if err != nil {
+			printError(err)
+			return
+		}
+		printNamespaces([]*pb.NamespaceInfo{res})
+
+	case args[0] == "drop" && len(args) == 2:
+		res, err := admin.DropNamespace(context.Background(), &pb.DropNamespaceRequest{Name: args[1]})
+		if err != nil {
+			printError(err)
+			return
+		}
+		fmt.Printf("Dropped namespace %s with %d keys, seq=%d\n", args[1], res.Keys, res.Sequence)
+
+	default:
+		fmt.Println(usage)
+	}
+}
+
+// parseQuota reads [max_keys] [max_bytes]; missing values mean no limit
+func parseQuota(args []string) (*pb.NamespaceQuota, error) {
+	quota := &pb.NamespaceQuota{}
+	for i, limit := range []*int64{&quota.MaxKeys, &quota.MaxBytes} {
+		if i >= len(args) {
+			break
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid limit %q", args[i])
+		}
+		*limit = n
+	}
+	return quota, nil
+}
+
+func printNamespaces(namespaces []*pb.NamespaceInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKEYS\tBYTES\tWRITES\tMAX KEYS\tMAX BYTES\tCREATED")
+	for _, ns := range namespaces {
+		name, created := ns.Name, "-"
+		if name == "" {
+			name = "(default)"
+		}
+		if ns.Created != nil {
+			created = ns.Created.AsTime().Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", name, ns.Keys, ns.Bytes, ns.Writes, limit(ns.Quota.GetMaxKeys()), limit(ns.Quota.GetMaxBytes()), created)
+	}
+	w.Flush()
+}
+
+// limit formats a quota limit, 0 meaning none
+func limit(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func printError(err error) {
+	if st, ok := status.FromError(err); ok {
+		fmt.Printf("Error: %s\n", st.Message())
+	}
+}
+
+func printClusterStatus(res *pb.ClusterStatusResponse) {
+	if res.ClusterId != "" {
+		fmt.Printf("Cluster: %s\n", res.ClusterId)
+	}
+	fmt.Printf("Leader: %s (%s), seq=%d\n", res.LeaderId, res.LeaderAddr, res.LeaderSequence)
+	if len(res.Members) == 0 {
+		fmt.Println("No followers")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tADDR\tSTATE\tACK SEQ\tLAG\tLAG (s)\tCONNECTED SINCE")
+	for _, m := range res.Members {
+		since := "-"
+		if m.ConnectedSince != nil {
+			since = m.ConnectedSince.AsTime().Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.1f\t%s\n", m.NodeId, m.Addr, m.State, m.LastAckSequence, m.Lag, m.LagSeconds, since)
+	}
+	w.Flush()
+}
+
+func printVerify(res *pb.VerifyResponse) {
+	if len(res.Ranges) == 0 {
+		fmt.Printf("In sync with upstream at seq=%d (%d ranges checked)\n", res.Sequence, 1<<uint(res.Depth))
+		return
+	}
+
+	fmt.Printf("%d of %d ranges differ from upstream at seq=%d\n", len(res.Ranges), 1<<uint(res.Depth), res.Sequence)
+	for _, r := range res.Ranges {
+		fmt.Printf("Range %d:\n", r.Bucket)
+		for _, key := range r.Missing {
+			fmt.Printf("  missing    %s\n", key)
+		}
+		for _, key := range r.Extra {
+			fmt.Printf("  extra      %s\n", key)
+		}
+		for _, key := range r.Different {
+			fmt.Printf("  different  %s\n", key)
+		}
+	}
+}
+
+func printApplyStatus(res *pb.ApplyStatus) {
+	state := "applying"
+	if res.Paused {
+		state = "paused"
+	}
+	fmt.Printf("Replication %s: applied seq=%d, received seq=%d, %d queued\n", state, res.AppliedSequence, res.ReceivedSequence, res.Queued)
+	if res.ApplyDelaySeconds > 0 {
+		fmt.Printf("Apply delay: %s\n", time.Duration(res.ApplyDelaySeconds*float64(time.Second)))
+	}
+	if res.NextApplyTime != nil && !res.Paused {
+		fmt.Printf("Next command due at %s\n", res.NextApplyTime.AsTime().Local().Format("2006-01-02 15:04:05"))
+	}
+}
+
+// watchChanges prints changes until the stream ends or Ctrl+C is pressed
+func watchChanges(client *g.KvsClient, req *pb.WatchRequest) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stream, err := client.Watch(ctx, req)
+	if err != nil {
+		if st, ok := status.FromError(err); ok {
+			fmt.Printf("Error: %s\n", st.Message())
+		}
+		return
+	}
+
+	fmt.Println("Watching for changes, press Ctrl+C to stop")
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				if st, ok := status.FromError(err); ok {
+					fmt.Printf("Error: %s\n", st.Message())
+				}
+			}
+			return
+		}
+
+		printChange(ev)
+	}
+}
+
+func printChange(ev *pb.ChangeEvent) {
+	at := ev.Timestamp.AsTime().Local().Format("15:04:05.000")
+	switch ev.Operation {
+	case pb.Operation_SET:
+		if ev.HasOldValue {
+			fmt.Printf("  [%d %s] set %s = %s (was %s)\n", ev.Sequence, at, ev.Key, ev.NewValue, ev.OldValue)
+		} else {
+			fmt.Printf("  [%d %s] set %s = %s (new)\n", ev.Sequence, at, ev.Key, ev.NewValue)
 		}
+	case pb.Operation_DELETE:
+		fmt.Printf("  [%d %s] del %s (was %s)\n", ev.Sequence, at, ev.Key, ev.OldValue)
+	case pb.Operation_DROP_NAMESPACE:
+		fmt.Printf("  [%d %s] namespace %s dropped\n", ev.Sequence, at, ev.Namespace)
 	}
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "go-kvs/api/proto/pb"
+	g "go-kvs/internal/client"
+
+	"google.golang.org/grpc/status"
+)
+
+// defaultImportBatch is how many entries import sends per Batch call
+const defaultImportBatch = 500
+
+// record is one line of a JSON Lines export
+type record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// export writes every key of the namespace, or its keys with a prefix, to
+// a JSON Lines or CSV file ("-" for stdout)
+func export(client *g.KvsClient, consistency pb.Consistency, namespace string, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "Only export keys with this prefix")
+	format := fs.String("format", "", "jsonl or csv (default: from the file extension, jsonl for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: client export [--prefix p] [--format jsonl|csv] {file|-}")
+		return 2
+	}
+	path := fs.Arg(0)
+	kind, err := fileFormat(*format, path)
+	if err != nil {
+		return fail(err)
+	}
+
+	stream, err := client.Scan(context.Background(), &pb.ScanRequest{Namespace: namespace, Prefix: *prefix, Consistency: consistency})
+	if err != nil {
+		return fail(err)
+	}
+
+	// Write next to the final name, so a failed export leaves no partial file
+	var out *os.File
+	if path == "-" {
+		out = os.Stdout
+	} else {
+		if out, err = os.Create(path + ".tmp"); err != nil {
+			return fail(err)
+		}
+		defer os.Remove(path + ".tmp")
+	}
+
+	w := bufio.NewWriter(out)
+	var (
+		enc   = json.NewEncoder(w)
+		csvw  = csv.NewWriter(w)
+		count int
+	)
+	if kind == "csv" {
+		csvw.Write([]string{"key", "value"})
+	}
+	for {
+		kv, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if kind == "csv" {
+			err = csvw.Write([]string{kv.Key, kv.Value})
+		} else {
+			err = enc.Encode(record{Key: kv.Key, Value: kv.Value})
+		}
+		if err != nil {
+			return fail(err)
+		}
+		count++
+	}
+	csvw.Flush()
+	if err := csvw.Error(); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+
+	if path != "-" {
+		if err := out.Close(); err != nil {
+			return fail(err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return fail(err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d keys to %s\n", count, path)
+	return 0
+}
+
+// importFile reads a JSON Lines or CSV file ("-" for stdin) and writes it
+// to the namespace through the Batch API
+func importFile(client *g.KvsClient, namespace string, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "jsonl or csv (default: from the file extension, jsonl for stdin)")
+	skipExisting := fs.Bool("skip-existing", false, "Keep the value of keys that already exist")
+	overwrite := fs.Bool("overwrite", false, "Replace the value of keys that already exist")
+	batchSize := fs.Int("batch-size", defaultImportBatch, "Entries per Batch call")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (*skipExisting && *overwrite) || *batchSize <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: client import [--skip-existing | --overwrite] [--format jsonl|csv] [--batch-size n] {file|-}")
+		return 2
+	}
+	path := fs.Arg(0)
+	kind, err := fileFormat(*format, path)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Without either flag an existing key fails the import, before its batch is written
+	mode := pb.BatchMode_FAIL_EXISTING
+	if *skipExisting {
+		mode = pb.BatchMode_SKIP_EXISTING
+	} else if *overwrite {
+		mode = pb.BatchMode_OVERWRITE
+	}
+
+	in := os.Stdin
+	if path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return fail(err)
+		}
+		defer in.Close()
+	}
+
+	var (
+		batch            = make([]*pb.KeyValue, 0, *batchSize)
+		written, skipped int32
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		res, err := client.Batch(context.Background(), &pb.BatchRequest{Namespace: namespace, Entries: batch, Mode: mode})
+		if err != nil {
+			// A batch that failed part way reports what it wrote before
+			for _, detail := range status.Convert(err).Details() {
+				if partial, ok := detail.(*pb.BatchResponse); ok {
+					written += partial.Written
+					skipped += partial.Skipped
+				}
+			}
+			return err
+		}
+		written += res.Written
+		skipped += res.Skipped
+		batch = batch[:0]
+		return nil
+	}
+	add := func(key, value string) error {
+		batch = append(batch, &pb.KeyValue{Key: key, Value: value})
+		if len(batch) < *batchSize {
+			return nil
+		}
+		return flush()
+	}
+
+	if kind == "csv" {
+		err = readCSV(in, add)
+	} else {
+		err = readJSONLines(in, add)
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Imported %d keys (%d skipped) before the error\n", written, skipped)
+		return fail(err)
+	}
+
+	fmt.Printf("Imported %d keys from %s (%d existing skipped)\n", written, path, skipped)
+	return 0
+}
+
+// readJSONLines calls add for every {"key": ..., "value": ...} line in r
+func readJSONLines(r io.Reader, add func(key, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Key == "" {
+			return fmt.Errorf("line %d: missing key", line)
+		}
+		if err := add(rec.Key, rec.Value); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readCSV calls add for every key,value row in r. A first row of
+// exactly "key,value" is a header and skipped.
+func readCSV(r io.Reader, add func(key, value string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	for first := true; ; first = false {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first && row[0] == "key" && row[1] == "value" {
+			continue
+		}
+		if row[0] == "" {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: missing key", line)
+		}
+		if err := add(row[0], row[1]); err != nil {
+			return err
+		}
+	}
+}
+
+// fileFormat returns the format to use: the explicit one, or the one the
+// file extension names
+func fileFormat(format, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		default:
+			format = "jsonl"
+		}
+	}
+	switch format {
+	case "jsonl", "csv":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected jsonl or csv", format)
+}
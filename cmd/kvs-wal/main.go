@@ -0,0 +1,673 @@
+// kvs-wal inspects, verifies and repairs write-ahead logs offline.
+//
+// Every command takes segment files or WAL directories (<data-dir>/wal);
+// a directory stands for all of its segments in log order. The server
+// must not be running on a log that is being repaired.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"go-kvs/internal/keyring"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs"
+	"go-kvs/pkg/kvs/command"
+
+	"github.com/rs/zerolog"
+)
+
+const usage = `Usage: kvs-wal <command> [flags] {segment file | wal dir}...
+
+Commands:
+  dump     Print every record with its offset, sequence and decoded command
+  verify   Check checksums, framing, decoding and segment order
+  stats    Print record counts, live vs dead bytes and key and namespace counts
+  repair   Copy the valid records into a new WAL directory
+  compact  Copy only the live records into a new WAL directory, leaving out dropped
+           namespaces and re-encrypting and re-compressing the rest
+
+Every command takes --keyfile to read encrypted records.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Opening and rolling segments logs at info level, which isn't
+	// part of the tool's output
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "dump":
+		os.Exit(dump(args))
+	case "verify":
+		os.Exit(verify(args))
+	case "stats":
+		os.Exit(stats(args))
+	case "repair":
+		os.Exit(repair(args))
+	case "compact":
+		os.Exit(compact(args))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// record is one record, or one damaged stretch, found in a segment file
+type record struct {
+	offset  int64
+	next    int64 // where the scan continues: the next record, or the end of the file
+	payload []byte
+	info    wal.RecordInfo // how the record is stored
+	err     error          // read error for a damaged record, nil otherwise
+	torn    bool           // no valid record follows the damage, as after a crash mid-write
+}
+
+// size returns the bytes the record takes in the file
+func (r record) size() int64 {
+	return r.next - r.offset
+}
+
+// visit calls fn for every record in f. After a damaged record the scan
+// resumes at the next valid one, if any. A record that is intact but can't
+// be decrypted stops the scan: the keyfile is wrong, not the record.
+func visit(f *wal.SegmentFile, fn func(r record) error) error {
+	offset := f.First()
+	for {
+		payload, info, next, err := f.Read(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF && !errors.Is(err, wal.ErrCorrupt) {
+			return fmt.Errorf("%s: offset %d: %w", f.Path(), offset, err)
+		}
+
+		r := record{offset: offset, next: next, payload: payload, info: info, err: err}
+		if err != nil {
+			resume, ok := f.Resync(offset)
+			if !ok {
+				resume = f.End()
+				// A record with intact framing but bad contents isn't a
+				// torn write, and the server doesn't drop it on startup
+				r.torn = info.Stored == 0
+			}
+			r.next = resume
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+		if r.next >= f.End() {
+			return nil
+		}
+		offset = r.next
+	}
+}
+
+// eachSegment opens the segment files named by args, expanding
+// directories, and calls fn for each in order
+func eachSegment(args []string, keys *keyring.Keyring, fn func(f *wal.SegmentFile) error) error {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		segments, err := wal.SegmentPaths(arg)
+		if err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			return fmt.Errorf("%s: no WAL segments", arg)
+		}
+		paths = append(paths, segments...)
+	}
+
+	for _, path := range paths {
+		f, err := wal.OpenSegmentFile(path, keys)
+		if err != nil {
+			return err
+		}
+		err = fn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseArgs parses a command's flags, adding --keyfile, checks that paths
+// were given and loads the keyfile. keys is nil without a keyfile.
+func parseArgs(fs *flag.FlagSet, args []string, synopsis string) (keys *keyring.Keyring, ok bool) {
+	keyfile := fs.String("keyfile", "", "Keyfile to decrypt encrypted records (and, for repair and compact, encrypt the new log)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kvs-wal %s\n", synopsis)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, false
+	}
+	if *keyfile != "" {
+		var err error
+		if keys, err = keyring.Load(*keyfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return nil, false
+		}
+	}
+	return keys, true
+}
+
+func dump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	maxValue := fs.Int("max-value", 64, "Print at most this many bytes of each value (0 = all)")
+	keys, ok := parseArgs(fs, args, "dump [--max-value n] [--keyfile file] {segment file | wal dir}...")
+	if !ok {
+		return 2
+	}
+
+	err := eachSegment(fs.Args(), keys, func(f *wal.SegmentFile) error {
+		fmt.Printf("# %s (base offset %d, %d bytes)\n", f.Path(), f.Base(), f.End()-f.Base())
+		return visit(f, func(r record) error {
+			if r.err != nil {
+				fmt.Printf("%12d  DAMAGED %d bytes: %v\n", r.offset, r.size(), r.err)
+				return nil
+			}
+			cmd, err := command.Deserialize(r.payload)
+			if err != nil {
+				fmt.Printf("%12d  len=%-6d UNDECODABLE: %v\n", r.offset, len(r.payload), err)
+				return nil
+			}
+			fmt.Printf("%12d  len=%-6d seq=%-8d %s  %s%s\n", r.offset, len(r.payload), cmd.Seq, formatTime(cmd.Time), formatStorage(r.info), formatCmd(cmd, *maxValue))
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func formatCmd(cmd command.Cmd, maxValue int) string {
+	var ns string
+	if cmd.Namespace != kvs.DefaultNamespace {
+		ns = fmt.Sprintf("[ns %s] ", cmd.Namespace)
+	}
+
+	switch cmd.Cmd {
+	case "set":
+		val := cmd.Val
+		if maxValue > 0 && len(val) > maxValue {
+			val = val[:maxValue] + "..."
+		}
+		return fmt.Sprintf("%sset %q = %q", ns, cmd.Key, val)
+	case "del":
+		return fmt.Sprintf("%sdel %q", ns, cmd.Key)
+	case "ns-create", "ns-quota":
+		quota, err := kvs.ParseQuota(cmd.Val)
+		if err != nil {
+			return fmt.Sprintf("%s %q (%v)", cmd.Cmd, cmd.Namespace, err)
+		}
+		return fmt.Sprintf("%s %q max-keys=%d max-bytes=%d", cmd.Cmd, cmd.Namespace, quota.MaxKeys, quota.MaxBytes)
+	case "ns-drop":
+		return fmt.Sprintf("ns-drop %q", cmd.Namespace)
+	case "seq":
+		return "seq (sequence marker)"
+	default:
+		return fmt.Sprintf("%s %q", cmd.Cmd, cmd.Key)
+	}
+}
+
+func formatStorage(info wal.RecordInfo) string {
+	var s string
+	if info.KeyID != 0 {
+		s += fmt.Sprintf("[key %d] ", info.KeyID)
+	}
+	if info.Codec != wal.CodecNone {
+		s += fmt.Sprintf("[%s %d bytes] ", info.Codec, info.Stored)
+	}
+	return s
+}
+
+func formatTime(nanos int64) string {
+	if nanos == 0 {
+		return "-                             "
+	}
+	return time.Unix(0, nanos).UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	keys, ok := parseArgs(fs, args, "verify [--keyfile file] {segment file | wal dir}...")
+	if !ok {
+		return 2
+	}
+
+	var (
+		records  int
+		problems int
+		prev     *wal.SegmentFile
+		prevEnd  int64
+	)
+	err := eachSegment(fs.Args(), keys, func(f *wal.SegmentFile) error {
+		if prev != nil && f.Base() != prevEnd {
+			fmt.Printf("%s: starts at offset %d, the previous segment ends at %d (missing or extra segment)\n", f.Path(), f.Base(), prevEnd)
+			problems++
+		}
+		prev, prevEnd = f, f.End()
+
+		return visit(f, func(r record) error {
+			records++
+			switch {
+			case r.torn:
+				fmt.Printf("%s: offset %d: torn record at the tail (%d bytes): %v\n", f.Path(), r.offset, r.size(), r.err)
+				problems++
+			case r.err != nil:
+				fmt.Printf("%s: offset %d: damaged record, next valid record at %d: %v\n", f.Path(), r.offset, r.next, r.err)
+				problems++
+			default:
+				if _, err := command.Deserialize(r.payload); err != nil {
+					fmt.Printf("%s: offset %d: checksum ok but the command doesn't decode: %v\n", f.Path(), r.offset, err)
+					problems++
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if problems > 0 {
+		fmt.Printf("%d records, %d problems\n", records, problems)
+		fmt.Println("A torn record at the end of the last segment is dropped when the server starts; use repair for anything else")
+		return 1
+	}
+	fmt.Printf("%d records, OK\n", records)
+	return 0
+}
+
+func stats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	keys, ok := parseArgs(fs, args, "stats [--keyfile file] {segment file | wal dir}...")
+	if !ok {
+		return 2
+	}
+
+	var (
+		segments, damaged       int
+		fileBytes, damagedBytes int64
+		counts                  = map[string]int{}
+		live                    = map[string]map[string]int64{kvs.DefaultNamespace: {}} // namespace -> key -> size of the record holding its value
+		minSeq, maxSeq          int64
+		minTime, maxTime        int64
+		byKey                   = map[uint32]int{} // records per encryption key, 0 = plaintext
+		byCodec                 = map[wal.Codec]int{}
+		rawBytes, storedBytes   = map[wal.Codec]int64{}, map[wal.Codec]int64{} // commands per codec, before and after compression
+	)
+	err := eachSegment(fs.Args(), keys, func(f *wal.SegmentFile) error {
+		segments++
+		fileBytes += f.End() - f.Base()
+		return visit(f, func(r record) error {
+			if r.err != nil {
+				damaged++
+				damagedBytes += r.size()
+				return nil
+			}
+			cmd, err := command.Deserialize(r.payload)
+			if err != nil {
+				damaged++
+				damagedBytes += r.size()
+				return nil
+			}
+
+			counts[cmd.Cmd]++
+			byKey[r.info.KeyID]++
+			byCodec[r.info.Codec]++
+			rawBytes[r.info.Codec] += int64(len(r.payload))
+			storedBytes[r.info.Codec] += int64(r.info.Stored)
+			switch cmd.Cmd {
+			case "set":
+				if live[cmd.Namespace] != nil {
+					live[cmd.Namespace][cmd.Key] = r.size()
+				}
+			case "del":
+				delete(live[cmd.Namespace], cmd.Key)
+			case "ns-create":
+				live[cmd.Namespace] = map[string]int64{}
+			case "ns-drop":
+				delete(live, cmd.Namespace)
+			}
+			if cmd.Seq > 0 {
+				if minSeq == 0 || cmd.Seq < minSeq {
+					minSeq = cmd.Seq
+				}
+				if cmd.Seq > maxSeq {
+					maxSeq = cmd.Seq
+				}
+			}
+			if cmd.Time > 0 {
+				if minTime == 0 || cmd.Time < minTime {
+					minTime = cmd.Time
+				}
+				if cmd.Time > maxTime {
+					maxTime = cmd.Time
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var liveBytes int64
+	liveKeys := 0
+	for _, sizes := range live {
+		for _, size := range sizes {
+			liveBytes += size
+		}
+		liveKeys += len(sizes)
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+
+	fmt.Printf("Segments:       %d\n", segments)
+	fmt.Printf("Bytes:          %d\n", fileBytes)
+	fmt.Printf("  live:         %d (%.1f%%, the current value of each key)\n", liveBytes, percent(liveBytes, fileBytes))
+	fmt.Printf("  dead:         %d (overwritten and deleted values, deletes, markers, headers)\n", fileBytes-liveBytes-damagedBytes)
+	if damaged > 0 {
+		fmt.Printf("  damaged:      %d (%d records)\n", damagedBytes, damaged)
+	}
+	fmt.Printf("Records:        %d\n", total)
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-13s %d\n", name+":", counts[name])
+	}
+	fmt.Printf("Live keys:      %d\n", liveKeys)
+	fmt.Printf("Namespaces:     %d\n", len(live))
+	ids := make([]uint32, 0, len(byKey))
+	for id := range byKey {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	fmt.Printf("Encryption:\n")
+	for _, id := range ids {
+		if id == 0 {
+			fmt.Printf("  %-13s %d records\n", "plaintext:", byKey[id])
+		} else {
+			fmt.Printf("  %-13s %d records\n", fmt.Sprintf("key %d:", id), byKey[id])
+		}
+	}
+	fmt.Printf("Compression:\n")
+	for _, codec := range []wal.Codec{wal.CodecNone, wal.CodecSnappy, wal.CodecZstd} {
+		if byCodec[codec] == 0 {
+			continue
+		}
+		if codec == wal.CodecNone {
+			fmt.Printf("  %-13s %d records\n", "none:", byCodec[codec])
+			continue
+		}
+		fmt.Printf("  %-13s %d records, %d bytes stored for %d (%.1fx)\n", codec.String()+":", byCodec[codec], storedBytes[codec], rawBytes[codec],
+			float64(rawBytes[codec])/float64(storedBytes[codec]))
+	}
+	if maxSeq > 0 {
+		fmt.Printf("Sequences:      %d - %d\n", minSeq, maxSeq)
+	}
+	if maxTime > 0 {
+		fmt.Printf("Written:        %s - %s\n", time.Unix(0, minTime).UTC().Format(time.RFC3339), time.Unix(0, maxTime).UTC().Format(time.RFC3339))
+	}
+	return 0
+}
+
+func percent(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+func repair(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	out := fs.String("out", "", "New WAL directory to write the repaired log to (must be empty)")
+	mode := fs.String("mode", "truncate", "truncate: keep the records before the first damaged one; skip: drop damaged records and keep the rest")
+	segmentSize := fs.Int64("segment-size", wal.DefaultSegmentSize, "Segment size of the repaired log")
+	keys, ok := parseArgs(fs, args, "repair --out {dir} [--mode truncate|skip] [--keyfile file] {segment file | wal dir}...")
+	if !ok {
+		return 2
+	}
+	if *out == "" || (*mode != "truncate" && *mode != "skip") {
+		fs.Usage()
+		return 2
+	}
+
+	if existing, err := wal.SegmentPaths(*out); err == nil && len(existing) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s already has a write-ahead log\n", *out)
+		return 1
+	}
+	repaired, err := wal.New(*out, map[string]int64{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	repaired.SetSegmentSize(*segmentSize)
+	repaired.SetKeyring(keys)
+
+	// errStop ends the scan at the first damaged record in truncate mode
+	errStop := errors.New("stop")
+
+	var kept, dropped int
+	var droppedBytes int64
+	err = eachSegment(fs.Args(), keys, func(f *wal.SegmentFile) error {
+		return visit(f, func(r record) error {
+			bad := r.err
+			if bad == nil {
+				if _, err := command.Deserialize(r.payload); err != nil {
+					bad = err
+				}
+			}
+			if bad != nil {
+				fmt.Printf("%s: offset %d: dropping %d bytes: %v\n", f.Path(), r.offset, r.size(), bad)
+				dropped++
+				droppedBytes += r.size()
+				if *mode == "truncate" {
+					return errStop
+				}
+				return nil
+			}
+
+			if _, err := repaired.Append(r.payload); err != nil {
+				return err
+			}
+			kept++
+			return nil
+		})
+	})
+	cerr := repaired.Close()
+	truncated := err == errStop
+	if truncated {
+		err = nil
+	}
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if truncated {
+		fmt.Println("Truncated the log at the first damaged record")
+	}
+
+	fmt.Printf("Wrote %d records to %s, dropped %d damaged (%d bytes)\n", kept, *out, dropped, droppedBytes)
+	fmt.Println("With the server stopped, replace <data-dir>/wal with this directory")
+	return 0
+}
+
+func compact(args []string) int {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	out := fs.String("out", "", "New WAL directory to write the compacted log to (must be empty)")
+	segmentSize := fs.Int64("segment-size", wal.DefaultSegmentSize, "Segment size of the compacted log")
+	compression := fs.String("compression", "none", "Compress the records of the compacted log: none, snappy or zstd")
+	minSize := fs.Int("compression-min-size", wal.DefaultCompressMinSize, "Compress only records of at least this many bytes")
+	keys, ok := parseArgs(fs, args, "compact --out {dir} [--compression none|snappy|zstd] [--keyfile file] {wal dir}")
+	if !ok {
+		return 2
+	}
+	codec, err := wal.ParseCodec(*compression)
+	if *out == "" || err != nil {
+		fs.Usage()
+		return 2
+	}
+
+	// Keep the last set of every key that wasn't deleted afterwards and the
+	// records of namespaces that weren't dropped, in log order, and the
+	// highest sequence so replication resumes after it
+	var (
+		live       = map[string]map[string]int64{kvs.DefaultNamespace: {}} // namespace -> key -> offset of its last set
+		namespaces = map[string][]int64{}                                  // namespace -> offsets of its create and quota records
+		sets       = map[int64][]byte{}                                    // offset -> command
+		seq        int64
+		when       int64
+		end        int64
+		dropped    int
+	)
+	err = eachSegment(fs.Args(), keys, func(f *wal.SegmentFile) error {
+		end = f.End()
+		return visit(f, func(r record) error {
+			if r.torn {
+				return nil // dropped by the server on start as well
+			}
+			if r.err != nil {
+				return fmt.Errorf("%s: offset %d: %w (repair the log first)", f.Path(), r.offset, r.err)
+			}
+			cmd, err := command.Deserialize(r.payload)
+			if err != nil {
+				return fmt.Errorf("%s: offset %d: %w (repair the log first)", f.Path(), r.offset, err)
+			}
+
+			nsKeys := live[cmd.Namespace]
+			switch cmd.Cmd {
+			case "set":
+				if nsKeys == nil {
+					return nil // written before its namespace was dropped
+				}
+				if prev, ok := nsKeys[cmd.Key]; ok {
+					delete(sets, prev)
+					dropped++
+				}
+				nsKeys[cmd.Key] = r.offset
+				sets[r.offset] = r.payload
+			case "del":
+				if prev, ok := nsKeys[cmd.Key]; ok {
+					delete(sets, prev)
+					delete(nsKeys, cmd.Key)
+					dropped++
+				}
+			case "ns-create":
+				live[cmd.Namespace] = map[string]int64{}
+				namespaces[cmd.Namespace] = []int64{r.offset}
+				sets[r.offset] = r.payload
+			case "ns-quota":
+				namespaces[cmd.Namespace] = append(namespaces[cmd.Namespace], r.offset)
+				sets[r.offset] = r.payload
+			case "ns-drop":
+				for _, offset := range nsKeys {
+					delete(sets, offset)
+					dropped++
+				}
+				for _, offset := range namespaces[cmd.Namespace] {
+					delete(sets, offset)
+				}
+				delete(live, cmd.Namespace)
+				delete(namespaces, cmd.Namespace)
+			}
+			if cmd.Seq > seq {
+				seq, when = cmd.Seq, cmd.Time
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Start where the old log ended, so the new segments' names don't
+	// collide with archived ones
+	compacted, err := wal.Create(*out, end, map[string]int64{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	compacted.SetSegmentSize(*segmentSize)
+	compacted.SetKeyring(keys)
+	compacted.SetCompression(codec, *minSize)
+
+	offsets := make([]int64, 0, len(sets))
+	for offset := range sets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for _, offset := range offsets {
+		if _, err = compacted.Append(sets[offset]); err != nil {
+			break
+		}
+	}
+	if err == nil && seq > 0 {
+		marker := command.New("seq", "", "")
+		marker.Seq, marker.Time = seq, when
+		var payload []byte
+		if payload, err = marker.Serialize(); err == nil {
+			_, err = compacted.Append(payload)
+		}
+	}
+	if cerr := compacted.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	encryption := "plaintext"
+	if keys != nil {
+		encryption = fmt.Sprintf("encrypted with key %d", keys.Active())
+	}
+	if codec != wal.CodecNone {
+		encryption += ", " + codec.String() + " compressed"
+	}
+	liveKeys := 0
+	for _, nsKeys := range live {
+		liveKeys += len(nsKeys)
+	}
+	fmt.Printf("Wrote %d live keys in %d namespaces at seq=%d to %s (%s), dropped %d overwritten, deleted or dropped values\n", liveKeys, len(live), seq, *out, encryption, dropped)
+	fmt.Println("With the server stopped, replace <data-dir>/wal with this directory; take a new base snapshot for point-in-time recovery")
+	return 0
+}
@@ -4,13 +4,24 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	pb "go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
 	"go-kvs/internal/config"
+	"go-kvs/internal/datadir"
 	"go-kvs/internal/follower"
+	"go-kvs/internal/keyring"
+	"go-kvs/internal/metrics"
 	"go-kvs/internal/replication"
 	g "go-kvs/internal/server"
 	"go-kvs/internal/server/middleware"
+	"go-kvs/internal/server/wal"
 	"go-kvs/pkg/kvs"
 
 	"github.com/rs/zerolog"
@@ -24,12 +35,89 @@ func main() {
 	// Parse command-line flags
 	cfg := parseFlags()
 
-	// Initialize KVS with node-specific WAL file
-	walFile := fmt.Sprintf("wal-%s.log", cfg.NodeID)
-	kvsInstance, err := kvs.New(walFile)
+	// Lock the data directory so a second server can't use the same files
+	dataDir, err := datadir.Open(cfg.DataDir)
+	if err != nil {
+		log.Fatal().Msgf("Failed to open data directory: %v", err)
+	}
+	log.Info().Msgf("Data directory: %s", dataDir.Path())
+
+	// Node and cluster IDs, checked when followers connect
+	identity, err := dataDir.Identity()
+	if err != nil {
+		log.Fatal().Msgf("Failed to load node identity: %v", err)
+	}
+	if cfg.IsLeader {
+		if identity.ClusterID() == "" {
+			if !cfg.Bootstrap {
+				log.Fatal().Msgf("%s doesn't belong to a cluster yet: start the leader with --bootstrap to create a new one", dataDir.Path())
+			}
+			if err := identity.SetClusterID(datadir.NewID()); err != nil {
+				log.Fatal().Msgf("Failed to save cluster ID: %v", err)
+			}
+			log.Info().Msgf("Bootstrapped new cluster %s", identity.ClusterID())
+		} else if cfg.Bootstrap {
+			log.Warn().Msgf("Ignoring --bootstrap: already in cluster %s", identity.ClusterID())
+		}
+	}
+	log.Info().Msgf("Node %s, cluster %s", identity.NodeID(), identity.ClusterID())
+
+	// Older versions kept the WAL in the working directory
+	if err := wal.Adopt(fmt.Sprintf("wal-%s.log", cfg.NodeID), dataDir.WALDir()); err != nil {
+		log.Fatal().Msgf("Failed to move WAL into data directory: %v", err)
+	}
+
+	// Keys for encryption at rest, nil keeps data in plaintext
+	var keys *keyring.Keyring
+	if cfg.KeyFile != "" {
+		keys, err = keyring.Load(cfg.KeyFile)
+		if err != nil {
+			log.Fatal().Msgf("Failed to load keyfile: %v", err)
+		}
+		log.Info().Msgf("Encrypting WAL records and snapshots with key %d from %s", keys.Active(), cfg.KeyFile)
+	}
+
+	// Initialize KVS from the WAL segments in the data directory
+	kvsInstance, err := kvs.New(dataDir.WALDir(), cfg.WALSegmentSize, keys)
 	if err != nil {
 		log.Fatal().Msgf("Failed to init KVS: %v", err)
 	}
+	codec, err := wal.ParseCodec(cfg.WALCompression)
+	if err != nil {
+		log.Fatal().Msgf("Invalid --wal-compression: %v", err)
+	}
+	if codec != wal.CodecNone {
+		kvsInstance.SetCompression(codec, cfg.WALCompressMin)
+		log.Info().Msgf("Compressing WAL records of at least %d bytes with %s", cfg.WALCompressMin, codec)
+	}
+	// A follower must apply every replicated command in order: refusing
+	// one would leave it diverged from the leader for good
+	if cfg.MaxDataDirSize > 0 && !cfg.IsLeader {
+		log.Warn().Msg("Ignoring --max-data-dir-size: followers store every replicated write")
+	}
+	if cfg.MaxDataDirSize > 0 && cfg.IsLeader {
+		usage, err := dataDir.Usage()
+		if err != nil {
+			log.Fatal().Msgf("Failed to measure data directory: %v", err)
+		}
+		// Only the WAL grows while the server runs; the rest of the
+		// directory is counted at its current size
+		walMax := cfg.MaxDataDirSize - (usage - kvsInstance.Size())
+		if usage >= cfg.MaxDataDirSize {
+			log.Warn().Msgf("Data directory uses %d bytes, at or over --max-data-dir-size=%d: writes will be refused", usage, cfg.MaxDataDirSize)
+		}
+		if walMax < 1 {
+			walMax = 1 // 0 would mean no limit
+		}
+		kvsInstance.SetMaxSize(walMax)
+		log.Info().Msgf("Data directory uses %d of %d bytes", usage, cfg.MaxDataDirSize)
+	}
+	if cfg.WALArchiveDir != "" {
+		if err := kvsInstance.SetArchiveDir(cfg.WALArchiveDir); err != nil {
+			log.Fatal().Msgf("Failed to set up WAL archive: %v", err)
+		}
+		log.Info().Msgf("Archiving WAL segments to %s", cfg.WALArchiveDir)
+	}
 
 	// Start gRPC server
 	lis, err := net.Listen("tcp", cfg.Address)
@@ -37,54 +125,275 @@ func main() {
 		log.Fatal().Msgf("Failed to listen on %s: %v", cfg.Address, err)
 	}
 
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(middleware.UnaryServerLoggingInterceptor))
+	// Tracks in-flight RPCs so shutdown can drain them. Replication and
+	// watch streams, and WaitForChange long polls, don't finish on their own
+	// and are closed instead.
+	drainer := middleware.NewDrainer(
+		pb.Replication_StreamReplication_FullMethodName,
+		pb.Replication_StreamReplicationBatched_FullMethodName,
+		pb.GoKvs_Watch_FullMethodName,
+		pb.GoKvs_WatchKey_FullMethodName,
+		pb.GoKvs_WaitForChange_FullMethodName,
+	)
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(drainer.UnaryServerInterceptor, middleware.UnaryServerLoggingInterceptor),
+		grpc.StreamInterceptor(drainer.StreamServerInterceptor),
+	)
+
+	// Change stream served by Watch, fed by local writes or applied commands
+	changes := cdc.NewHub(cfg.ChangeBufferSize)
+
+	// Set below depending on the role, used on shutdown
+	var streamMgr *replication.StreamManager
+	var streamClient *follower.StreamClient
 
 	if cfg.IsLeader {
 		// Leader setup
 		log.Info().Msgf("Starting as LEADER on %s", cfg.Address)
 
 		// Create stream manager for followers
-		streamMgr := replication.NewStreamManager()
+		streamMgr = replication.NewStreamManager(cfg.LeaseDuration, cfg.FollowerAddrs)
+		streamMgr.SetLagThreshold(lagThreshold(cfg))
+		streamMgr.SetMemberTimeout(cfg.MemberTimeout)
+
+		// Continue numbering after the last write in the WAL, so followers
+		// that are ahead of an empty log aren't confused after a restart
+		lastSeq := kvsInstance.LastSequence()
+		streamMgr.Resume(lastSeq)
+		changes.Reset(lastSeq)
+		log.Info().Msgf("Resuming at seq=%d", lastSeq)
 
 		// Register client-facing KVS service
-		kvsServer := g.NewKvsServer(kvsInstance, streamMgr, true)
+		kvsServer := g.NewKvsServer(kvsInstance, streamMgr, streamMgr, cfg)
+		kvsServer.SetChangeHub(changes)
 		pb.RegisterGoKvsServer(grpcServer, kvsServer)
 
 		// Register replication service for follower connections
-		leaderStreamServer := g.NewLeaderStreamServer(streamMgr)
+		leaderStreamServer := g.NewLeaderStreamServer(streamMgr, kvsServer)
+		leaderStreamServer.SetBatching(cfg.BatchSize, cfg.BatchLinger)
+		leaderStreamServer.SetIdentity(identity)
 		pb.RegisterReplicationServer(grpcServer, leaderStreamServer)
+
+		// Register admin service (cluster status, replication lag)
+		adminServer := g.NewAdminServer(streamMgr, nil, cfg)
+		adminServer.SetIdentity(identity)
+		adminServer.SetSnapshotSource(kvsServer)
+		adminServer.SetKeyring(keys)
+		adminServer.SetNamespaces(kvsServer)
+		pb.RegisterAdminServer(grpcServer, adminServer)
+		metrics.Publish("replication", adminServer.Metrics)
 	} else {
 		// Follower setup
 		log.Info().Msgf("Starting as FOLLOWER on %s", cfg.Address)
 		log.Info().Msgf("Leader: %s", cfg.LeaderAddr)
 
-		// Register client-facing KVS service (read-only, will reject writes)
-		kvsServer := g.NewKvsServer(kvsInstance, nil, false)
+		streamClient = follower.NewStreamClient(cfg.NodeID, cfg.AdvertiseAddr, cfg.LeaderAddr, kvsInstance)
+		streamClient.SetLagThreshold(lagThreshold(cfg))
+
+		compression, err := replication.ParseCompression(cfg.ReplicationCompression)
+		if err != nil {
+			log.Fatal().Msgf("Invalid --replication-compression: %v", err)
+		}
+		streamClient.SetCompression(compression)
+		streamClient.SetFilter(replication.KeyFilter{Include: cfg.IncludePrefixes, Exclude: cfg.ExcludePrefixes})
+		streamClient.SetApplyDelay(cfg.ApplyDelay)
+		if err := streamClient.SetQueueDir(dataDir.MetaDir()); err != nil {
+			log.Fatal().Msgf("Failed to load the apply queue: %v", err)
+		}
+		streamClient.SetChangeHub(changes)
+		streamClient.SetIdentity(identity)
+		streamClient.SetJoinCluster(cfg.JoinCluster)
+		if cfg.ApplyDelay > 0 {
+			log.Info().Msgf("Delayed replica: applying commands %s after commit", cfg.ApplyDelay)
+		}
+
+		if cfg.ServeReplication {
+			// Re-stream applied commands so other followers can replicate from this node
+			streamMgr = replication.NewStreamManager(cfg.LeaseDuration, nil)
+			streamClient.SetRelay(streamMgr)
+			relayStreamServer := g.NewRelayStreamServer(streamMgr, streamClient, streamClient)
+			relayStreamServer.SetBatching(cfg.BatchSize, cfg.BatchLinger)
+			relayStreamServer.SetIdentity(identity)
+			pb.RegisterReplicationServer(grpcServer, relayStreamServer)
+			log.Info().Msg("Serving replication stream to downstream followers")
+		}
+
+		// Register client-facing KVS service (writes are rejected, or forwarded with --proxy-writes)
+		kvsServer := g.NewKvsServer(kvsInstance, nil, streamClient, cfg)
+		kvsServer.SetChangeHub(changes)
 		pb.RegisterGoKvsServer(grpcServer, kvsServer)
 
+		// Register admin service (forwards cluster status to the leader, reports own lag)
+		adminServer := g.NewAdminServer(nil, streamClient, cfg)
+		adminServer.SetIdentity(identity)
+		adminServer.SetSnapshotSource(streamClient)
+		adminServer.SetKeyring(keys)
+		adminServer.SetNamespaces(kvsServer)
+		pb.RegisterAdminServer(grpcServer, adminServer)
+		metrics.Publish("replication", adminServer.Metrics)
+
 		// Start stream client to connect to leader (runs in background)
-		streamClient := follower.NewStreamClient(cfg.NodeID, cfg.LeaderAddr, kvsInstance)
 		go streamClient.ConnectToLeader()
+
+		if cfg.AntiEntropyInterval > 0 {
+			// Periodically compare data with upstream and repair drifted ranges
+			streamClient.RunAntiEntropy(cfg.AntiEntropyInterval)
+		}
+	}
+
+	if cfg.MetricsAddr != "" {
+		go metrics.Serve(cfg.MetricsAddr)
 	}
 
-	log.Info().Msgf("Server listening on %s", cfg.Address)
-	if err := grpcServer.Serve(lis); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info().Msgf("Server listening on %s", cfg.Address)
+		serveErr <- grpcServer.Serve(lis)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
 		log.Fatal().Msgf("Failed to serve: %v", err)
+	case sig := <-signals:
+		log.Info().Msgf("Received %s, shutting down", sig)
+	}
+
+	shutdown(cfg, grpcServer, drainer, changes, streamMgr, streamClient, kvsInstance, dataDir)
+}
+
+// shutdown stops the server in order: reject new RPCs and drain in-flight
+// ones, close watch and replication streams, stop the gRPC server, stop
+// replicating and finally flush the WAL.
+func shutdown(cfg *config.ServerConfig, grpcServer *grpc.Server, drainer *middleware.Drainer, changes *cdc.Hub,
+	streamMgr *replication.StreamManager, streamClient *follower.StreamClient, kvsInstance *kvs.Kvs, dataDir *datadir.Dir) {
+	deadline := time.Now().Add(cfg.ShutdownTimeout)
+
+	// Step 1: Stop admitting RPCs and wait for in-flight ones
+	if !drainer.Drain(cfg.ShutdownTimeout) {
+		log.Warn().Msgf("In-flight RPCs still running after %s, cutting them off", cfg.ShutdownTimeout)
 	}
+
+	// Step 2: End long-lived streams so GracefulStop doesn't wait on them
+	changes.Close()
+	if streamMgr != nil {
+		streamMgr.Close()
+	}
+
+	// Step 3: Stop the gRPC server, forcibly if streams are still flushing at the deadline
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		log.Warn().Msg("Graceful stop timed out, closing remaining connections")
+		grpcServer.Stop()
+	}
+
+	// Step 4: Stop receiving and applying replicated commands
+	if streamClient != nil {
+		streamClient.Stop()
+	}
+
+	// Step 5: Flush and close the WAL (it also holds the applied sequence)
+	if err := kvsInstance.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close WAL")
+	}
+	dataDir.Close()
+
+	log.Info().Msg("Shutdown complete")
 }
 
 func parseFlags() *config.ServerConfig {
 	nodeID := flag.String("node-id", "node1", "Unique node identifier")
 	isLeader := flag.Bool("leader", false, "Run as leader")
 	port := flag.String("port", "50051", "Port to listen on")
+	advertiseAddr := flag.String("advertise-addr", "", "Client-facing address reported to the leader (default: localhost:<port>)")
+	followerAddrs := flag.String("follower-addrs", "", "Comma-separated addresses of followers expected to join (leader only)")
 	leaderAddr := flag.String("leader-addr", "", "Leader address (follower only)")
+	serveReplication := flag.Bool("serve-replication", false, "Serve a replication stream to other followers (follower only)")
+	proxyWrites := flag.Bool("proxy-writes", false, "Forward writes to the leader instead of rejecting them (follower only)")
+	leaseDuration := flag.Duration("lease-duration", replication.DefaultLeaseDuration, "How long a quorum heartbeat keeps the leader lease valid (leader only)")
+	memberTimeout := flag.Duration("member-timeout", replication.DefaultMemberTimeout, "How long a disconnected follower counts toward the lease quorum before it is removed (leader only)")
+	lagThresholdSeqs := flag.Int64("lag-threshold-seqs", 0, "Mark followers further behind than this many commands as lagging (0 = off)")
+	lagThresholdTime := flag.Duration("lag-threshold-time", 0, "Mark followers further behind than this as lagging (0 = off)")
+	rejectLaggingReads := flag.Bool("reject-lagging-reads", false, "Refuse session reads while this follower is lagging (follower only)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve expvar metrics on this address, e.g. localhost:9090")
+	antiEntropyInterval := flag.Duration("anti-entropy-interval", 0, "Compare and repair data against upstream this often, e.g. 5m (follower only, 0 = off)")
+	batchSize := flag.Int("batch-size", replication.DefaultBatchSize, "Most commands per replication batch sent to followers")
+	batchLinger := flag.Duration("batch-linger", replication.DefaultBatchLinger, "How long a partial replication batch waits for more commands")
+	replicationCompression := flag.String("replication-compression", "snappy", "Compression requested for the replication stream: none, gzip, snappy or zstd (follower only)")
+	includePrefixes := flag.String("include-prefixes", "", "Comma-separated key prefixes to replicate, others are skipped (follower only)")
+	excludePrefixes := flag.String("exclude-prefixes", "", "Comma-separated key prefixes not to replicate (follower only)")
+	applyDelay := flag.Duration("apply-delay", 0, "Apply commands this long after the leader committed them, e.g. 30m (follower only, 0 = off)")
+	changeBufferSize := flag.Int("change-buffer-size", cdc.DefaultCapacity, "Recent changes kept so watchers can resume from a sequence")
+	readWaitTimeout := flag.Duration("read-wait-timeout", g.DefaultReadWaitTimeout, "Max time a Get waits for its min_sequence to be applied")
+	bootstrap := flag.Bool("bootstrap", false, "Create a new cluster if the data directory doesn't belong to one (leader only)")
+	joinCluster := flag.Bool("join-cluster", false, "Join upstream's cluster even though the data directory has data but no cluster ID (follower only)")
+	dataDirPath := flag.String("data-dir", "", "Directory for the WAL, snapshots and node metadata (default: data/<node-id>)")
+	walSegmentSize := flag.Int64("wal-segment-size", wal.DefaultSegmentSize, "Size in bytes at which a new WAL segment is started")
+	walArchiveDir := flag.String("wal-archive-dir", "", "Copy sealed WAL segments into this directory for point-in-time recovery (empty = off)")
+	walCompression := flag.String("wal-compression", "none", "Compression of new WAL records: none, snappy or zstd (existing records are read with any codec)")
+	walCompressMin := flag.Int("wal-compression-min-size", wal.DefaultCompressMinSize, "Smallest WAL record in bytes that is compressed")
+	keyFile := flag.String("keyfile", "", "Keyfile for AES-GCM encryption of WAL records and snapshots (empty = plaintext)")
+	maxKeySize := flag.Int("max-key-size", 0, "Largest key in bytes clients may write (0 = no limit)")
+	maxValueSize := flag.Int("max-value-size", 0, "Largest value in bytes clients may write (0 = no limit)")
+	maxKeys := flag.Int("max-keys", 0, "Most keys the node holds; writes adding more are refused (0 = no limit)")
+	maxDataDirSize := flag.Int64("max-data-dir-size", 0, "Largest size in bytes of the data directory; writes that would exceed it are refused (leader only, 0 = no limit)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "How long shutdown waits for in-flight RPCs on SIGTERM")
 
 	flag.Parse()
 
 	cfg := &config.ServerConfig{
-		NodeID:   *nodeID,
-		IsLeader: *isLeader,
-		Address:  fmt.Sprintf("localhost:%s", *port),
+		NodeID:          *nodeID,
+		IsLeader:        *isLeader,
+		Address:         fmt.Sprintf("localhost:%s", *port),
+		ReadWaitTimeout: *readWaitTimeout,
+		LeaseDuration:   *leaseDuration,
+		MemberTimeout:   *memberTimeout,
+
+		LagThresholdSeqs:   *lagThresholdSeqs,
+		LagThresholdTime:   *lagThresholdTime,
+		RejectLaggingReads: *rejectLaggingReads,
+		MetricsAddr:        *metricsAddr,
+
+		BatchSize:   *batchSize,
+		BatchLinger: *batchLinger,
+
+		ChangeBufferSize: *changeBufferSize,
+		ShutdownTimeout:  *shutdownTimeout,
+
+		DataDir:        *dataDirPath,
+		WALSegmentSize: *walSegmentSize,
+		WALArchiveDir:  *walArchiveDir,
+		WALCompression: *walCompression,
+		WALCompressMin: *walCompressMin,
+		KeyFile:        *keyFile,
+		Bootstrap:      *bootstrap,
+
+		MaxKeySize:     *maxKeySize,
+		MaxValueSize:   *maxValueSize,
+		MaxKeys:        *maxKeys,
+		MaxDataDirSize: *maxDataDirSize,
+	}
+
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join("data", cfg.NodeID)
+	}
+
+	cfg.AdvertiseAddr = cfg.Address
+	if *advertiseAddr != "" {
+		cfg.AdvertiseAddr = *advertiseAddr
+	}
+
+	if *followerAddrs != "" {
+		cfg.FollowerAddrs = strings.Split(*followerAddrs, ",")
 	}
 
 	if !*isLeader {
@@ -92,7 +401,32 @@ func parseFlags() *config.ServerConfig {
 			log.Fatal().Msg("Follower must specify --leader-addr")
 		}
 		cfg.LeaderAddr = *leaderAddr
+		if *bootstrap {
+			log.Fatal().Msg("--bootstrap is for the leader; followers join their leader's cluster")
+		}
+		cfg.JoinCluster = *joinCluster
+		cfg.ProxyWrites = *proxyWrites
+		cfg.ServeReplication = *serveReplication
+		cfg.AntiEntropyInterval = *antiEntropyInterval
+		cfg.ReplicationCompression = *replicationCompression
+		cfg.ApplyDelay = *applyDelay
+		if cfg.ApplyDelay > 0 && cfg.AntiEntropyInterval > 0 {
+			log.Fatal().Msg("--anti-entropy-interval can't be used with --apply-delay")
+		}
+		if *includePrefixes != "" {
+			cfg.IncludePrefixes = strings.Split(*includePrefixes, ",")
+		}
+		if *excludePrefixes != "" {
+			cfg.ExcludePrefixes = strings.Split(*excludePrefixes, ",")
+		}
 	}
 
 	return cfg
 }
+
+func lagThreshold(cfg *config.ServerConfig) replication.LagThreshold {
+	return replication.LagThreshold{
+		Sequences: cfg.LagThresholdSeqs,
+		Time:      cfg.LagThresholdTime,
+	}
+}
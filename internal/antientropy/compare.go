@@ -0,0 +1,103 @@
+package antientropy
+
+import (
+	"sort"
+
+	"go-kvs/pkg/kvs"
+)
+
+// RangeDiff lists the keys that differ within one key hash range
+type RangeDiff struct {
+	Bucket    int
+	Missing   []string // On the upstream node, not here
+	Extra     []string // Here, not on the upstream node
+	Different []string // On both, with different values
+}
+
+// Report is the outcome of one anti-entropy check
+type Report struct {
+	Namespace string // Namespace compared
+	Sequence  int64  // Sequence both sides were compared at
+	Depth     int
+	Ranges    []RangeDiff
+	Repaired  int // Keys set or deleted to repair the ranges
+}
+
+// BuildTree hashes every key/value pair of snap into a tree of the given depth
+func BuildTree(snap *kvs.Snapshot, depth int) (*Tree, error) {
+	builder, err := NewBuilder(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	err = snap.Each(func(key, val string) error {
+		builder.Add(key, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.Tree(), nil
+}
+
+// RangeEntries returns the key/value pairs of snap that fall into buckets
+func RangeEntries(snap *kvs.Snapshot, depth int, buckets []int) (map[string]string, error) {
+	wanted := make(map[int]bool, len(buckets))
+	for _, bucket := range buckets {
+		wanted[bucket] = true
+	}
+
+	entries := make(map[string]string)
+	for _, key := range snap.Keys() {
+		if !wanted[Bucket(key, depth)] {
+			continue
+		}
+		val, _, err := snap.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = val
+	}
+	return entries, nil
+}
+
+// CompareRanges diffs the entries of the upstream node against the local
+// ones, grouped by range. Ranges without differences are left out.
+func CompareRanges(depth int, upstream, local map[string]string) []RangeDiff {
+	byBucket := make(map[int]*RangeDiff)
+	rangeOf := func(key string) *RangeDiff {
+		bucket := Bucket(key, depth)
+		if byBucket[bucket] == nil {
+			byBucket[bucket] = &RangeDiff{Bucket: bucket}
+		}
+		return byBucket[bucket]
+	}
+
+	for key, val := range upstream {
+		localVal, exists := local[key]
+		if !exists {
+			diff := rangeOf(key)
+			diff.Missing = append(diff.Missing, key)
+		} else if localVal != val {
+			diff := rangeOf(key)
+			diff.Different = append(diff.Different, key)
+		}
+	}
+	for key := range local {
+		if _, exists := upstream[key]; !exists {
+			diff := rangeOf(key)
+			diff.Extra = append(diff.Extra, key)
+		}
+	}
+
+	diffs := make([]RangeDiff, 0, len(byBucket))
+	for _, diff := range byBucket {
+		sort.Strings(diff.Missing)
+		sort.Strings(diff.Extra)
+		sort.Strings(diff.Different)
+		diffs = append(diffs, *diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Bucket < diffs[j].Bucket })
+	return diffs
+}
@@ -0,0 +1,63 @@
+package antientropy
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompareRanges(t *testing.T) {
+	const depth = 1 // two ranges, so keys share them
+	tests := []struct {
+		name     string
+		upstream map[string]string
+		local    map[string]string
+		want     map[string][]string // "missing", "extra" and "different" keys
+	}{
+		{name: "equal", upstream: map[string]string{"a": "1"}, local: map[string]string{"a": "1"}, want: map[string][]string{}},
+		{name: "both empty", want: map[string][]string{}},
+		{
+			name:     "every kind of difference",
+			upstream: map[string]string{"a": "1", "b": "2", "c": "3"},
+			local:    map[string]string{"a": "1", "b": "x", "d": "4"},
+			want:     map[string][]string{"different": {"b"}, "missing": {"c"}, "extra": {"d"}},
+		},
+		{
+			name:     "local empty",
+			upstream: map[string]string{"a": "1", "b": "2"},
+			want:     map[string][]string{"missing": {"a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := CompareRanges(depth, tt.upstream, tt.local)
+
+			got := map[string][]string{}
+			for i, diff := range diffs {
+				if i > 0 && diffs[i-1].Bucket >= diff.Bucket {
+					t.Errorf("ranges out of order: %d after %d", diff.Bucket, diffs[i-1].Bucket)
+				}
+				kinds := map[string][]string{"missing": diff.Missing, "extra": diff.Extra, "different": diff.Different}
+				total := 0
+				for kind, keys := range kinds {
+					for _, key := range keys {
+						if bucket := Bucket(key, depth); bucket != diff.Bucket {
+							t.Errorf("%s key %s in range %d, want %d", kind, key, diff.Bucket, bucket)
+						}
+						got[kind] = append(got[kind], key)
+					}
+					total += len(keys)
+				}
+				if total == 0 {
+					t.Errorf("range %d listed without differences", diff.Bucket)
+				}
+			}
+			for kind := range got {
+				sort.Strings(got[kind])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package antientropy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+)
+
+// DefaultDepth gives 2^10 = 1024 key hash ranges (buckets)
+const DefaultDepth = 10
+
+// MaxDepth keeps the leaf list within a reasonable RPC size
+const MaxDepth = 16
+
+// Bucket returns the key hash range a key falls into at the given depth.
+// Ranges split the 64-bit FNV-1a hash space into 2^depth equal parts.
+func Bucket(key string, depth int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() >> (64 - uint(depth)))
+}
+
+// Tree is a Merkle tree over key hash ranges. Each leaf hashes the sorted
+// key/value pairs of one range; empty ranges have a nil hash, and so do
+// inner nodes whose children are all empty.
+type Tree struct {
+	depth  int
+	levels [][][]byte // levels[0] is the root, levels[depth] the leaves
+}
+
+// Builder accumulates key/value pairs into leaf hashes.
+// Pairs must be added in key order.
+type Builder struct {
+	depth  int
+	leaves []hash.Hash
+}
+
+func NewBuilder(depth int) (*Builder, error) {
+	if depth <= 0 || depth > MaxDepth {
+		return nil, fmt.Errorf("merkle depth must be between 1 and %d, got %d", MaxDepth, depth)
+	}
+	return &Builder{
+		depth:  depth,
+		leaves: make([]hash.Hash, 1<<uint(depth)),
+	}, nil
+}
+
+// Add hashes one key/value pair into its range
+func (b *Builder) Add(key, val string) {
+	bucket := Bucket(key, b.depth)
+	if b.leaves[bucket] == nil {
+		b.leaves[bucket] = sha256.New()
+	}
+
+	h := b.leaves[bucket]
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(key)))
+	h.Write(length[:])
+	h.Write([]byte(key))
+	binary.BigEndian.PutUint64(length[:], uint64(len(val)))
+	h.Write(length[:])
+	h.Write([]byte(val))
+}
+
+// Tree finishes the leaves and computes the inner nodes
+func (b *Builder) Tree() *Tree {
+	leaves := make([][]byte, len(b.leaves))
+	for i, h := range b.leaves {
+		if h != nil {
+			leaves[i] = h.Sum(nil)
+		}
+	}
+	tree, _ := FromLeaves(b.depth, leaves)
+	return tree
+}
+
+// FromLeaves rebuilds a tree from its leaf hashes, e.g. as received over RPC
+func FromLeaves(depth int, leaves [][]byte) (*Tree, error) {
+	if depth <= 0 || depth > MaxDepth {
+		return nil, fmt.Errorf("merkle depth must be between 1 and %d, got %d", MaxDepth, depth)
+	}
+	if len(leaves) != 1<<uint(depth) {
+		return nil, fmt.Errorf("merkle tree of depth %d needs %d leaves, got %d", depth, 1<<uint(depth), len(leaves))
+	}
+
+	// Empty ranges may arrive as empty rather than nil slices over RPC
+	normalized := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if len(leaf) > 0 {
+			normalized[i] = leaf
+		}
+	}
+
+	levels := make([][][]byte, depth+1)
+	levels[depth] = normalized
+	for level := depth - 1; level >= 0; level-- {
+		children := levels[level+1]
+		nodes := make([][]byte, len(children)/2)
+		for i := range nodes {
+			nodes[i] = hashPair(children[2*i], children[2*i+1])
+		}
+		levels[level] = nodes
+	}
+
+	return &Tree{depth: depth, levels: levels}, nil
+}
+
+func hashPair(left, right []byte) []byte {
+	if len(left) == 0 && len(right) == 0 {
+		return nil
+	}
+	h := sha256.New()
+	h.Write([]byte{byte(len(left))})
+	h.Write(left)
+	h.Write([]byte{byte(len(right))})
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Depth returns the number of levels below the root
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// Root returns the root hash (nil for an empty key space)
+func (t *Tree) Root() []byte {
+	return t.levels[0][0]
+}
+
+// Leaves returns the hash of every key range
+func (t *Tree) Leaves() [][]byte {
+	return t.levels[t.depth]
+}
+
+// Diff returns the ranges whose hashes differ, descending only into
+// subtrees whose hashes differ. Both trees must have the same depth.
+func Diff(a, b *Tree) []int {
+	var diffs []int
+	var walk func(level, i int)
+	walk = func(level, i int) {
+		if bytes.Equal(a.levels[level][i], b.levels[level][i]) {
+			return
+		}
+		if level == a.depth {
+			diffs = append(diffs, i)
+			return
+		}
+		walk(level+1, 2*i)
+		walk(level+1, 2*i+1)
+	}
+	walk(0, 0)
+	return diffs
+}
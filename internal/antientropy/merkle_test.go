@@ -0,0 +1,96 @@
+package antientropy
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// tree builds a tree of depth from entries, adding them in key order
+func tree(t *testing.T, depth int, entries map[string]string) *Tree {
+	t.Helper()
+	builder, err := NewBuilder(depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.Add(key, entries[key])
+	}
+	return builder.Tree()
+}
+
+func TestDiff(t *testing.T) {
+	const depth = 4
+	base := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}
+
+	tests := []struct {
+		name    string
+		change  func(entries map[string]string)
+		changed []string // keys whose ranges must differ
+	}{
+		{name: "equal", change: func(map[string]string) {}},
+		{name: "different value", change: func(m map[string]string) { m["b"] = "x" }, changed: []string{"b"}},
+		{name: "missing key", change: func(m map[string]string) { delete(m, "c") }, changed: []string{"c"}},
+		{name: "extra key", change: func(m map[string]string) { m["f"] = "6" }, changed: []string{"f"}},
+		{name: "several ranges", change: func(m map[string]string) { m["a"] = "x"; delete(m, "e") }, changed: []string{"a", "e"}},
+		{name: "value moved to another key", change: func(m map[string]string) { delete(m, "a"); m["aa"] = "1" }, changed: []string{"a", "aa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := make(map[string]string)
+			for key, val := range base {
+				local[key] = val
+			}
+			tt.change(local)
+			a, b := tree(t, depth, base), tree(t, depth, local)
+
+			want := []int{}
+			seen := map[int]bool{}
+			for _, key := range tt.changed {
+				if bucket := Bucket(key, depth); !seen[bucket] {
+					seen[bucket] = true
+					want = append(want, bucket)
+				}
+			}
+			sort.Ints(want)
+			got := append([]int{}, Diff(a, b)...)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Diff = %v, want %v", got, want)
+			}
+			if equal := len(want) == 0; reflect.DeepEqual(a.Root(), b.Root()) != equal {
+				t.Errorf("roots equal = %v, want %v", !equal, equal)
+			}
+		})
+	}
+}
+
+func TestFromLeaves(t *testing.T) {
+	built := tree(t, 3, map[string]string{"a": "1", "b": "2"})
+
+	// Empty ranges arrive as empty slices over RPC
+	leaves := make([][]byte, len(built.Leaves()))
+	for i, leaf := range built.Leaves() {
+		leaves[i] = append([]byte{}, leaf...)
+	}
+	rebuilt, err := FromLeaves(3, leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := Diff(built, rebuilt); len(diffs) != 0 {
+		t.Errorf("rebuilt tree differs in %v", diffs)
+	}
+
+	for _, tt := range []struct {
+		depth  int
+		leaves int
+	}{{0, 1}, {MaxDepth + 1, 1}, {3, 4}} {
+		if _, err := FromLeaves(tt.depth, make([][]byte, tt.leaves)); err == nil {
+			t.Errorf("FromLeaves(%d, %d leaves) succeeded", tt.depth, tt.leaves)
+		}
+	}
+}
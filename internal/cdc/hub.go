@@ -0,0 +1,277 @@
+package cdc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultCapacity is how many recent changes are kept for resuming watchers
+const DefaultCapacity = 10000
+
+// subscriberBuffer is how many changes a watcher may fall behind before
+// its subscription is closed
+const subscriberBuffer = 256
+
+type Op int
+
+const (
+	OpSet Op = iota + 1
+	OpDelete
+	OpDropNamespace // the whole namespace was dropped; Key is empty
+)
+
+// Event is one change applied to the store
+type Event struct {
+	Namespace   string
+	Key         string
+	OldValue    string
+	NewValue    string
+	HasOldValue bool
+	Op          Op
+	Sequence    int64
+	Time        time.Time
+}
+
+// Subscription delivers the changes in one namespace matching a prefix.
+// Backlog holds the buffered changes to send first; C delivers later ones
+// and is closed if the subscriber falls too far behind. A drop of the
+// namespace is delivered as the last event, and C is closed after it.
+type Subscription struct {
+	Backlog []Event
+	C       <-chan Event
+	ch      chan Event
+	ns      string
+	match   func(ns, key string) bool
+}
+
+// Hub fans out changes to watchers and keeps the most recent ones in a
+// ring buffer, so watchers can resume from a sequence
+type Hub struct {
+	events   []Event // ring buffer
+	start    int
+	count    int
+	floorSeq int64 // changes after this sequence are buffered (if still in the ring)
+	lastSeq  int64
+	subs     map[*Subscription]struct{}
+	history  History // reads back changes no longer buffered, nil = none
+	closed   bool
+	mu       sync.Mutex
+}
+
+// History calls fn for every change with a sequence after afterSeq, up to
+// and including upTo, whose key matches, and for every namespace drop. The
+// store's WAL serves as the history of changes older than the ring buffer.
+type History func(afterSeq, upTo int64, match func(ns, key string) bool, fn func(Event) error) error
+
+func NewHub(capacity int) *Hub {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Hub{
+		events: make([]Event, capacity),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// SetHistory makes subscriptions that resume before the buffered changes
+// read the older ones from history
+func (h *Hub) SetHistory(history History) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = history
+}
+
+// Reset drops buffered changes and starts after seq, e.g. when a node
+// restarts with state up to seq
+func (h *Hub) Reset(seq int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.start, h.count = 0, 0
+	h.floorSeq = seq
+	h.lastSeq = seq
+}
+
+// Publish buffers ev and sends it to every matching subscriber. Changes
+// must be published in sequence order.
+func (h *Hub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Step 1: buffer, evicting the oldest change when full
+	if h.count == len(h.events) {
+		h.floorSeq = h.events[h.start].Sequence
+		h.start = (h.start + 1) % len(h.events)
+		h.count--
+	}
+	h.events[(h.start+h.count)%len(h.events)] = ev
+	h.count++
+	h.lastSeq = ev.Sequence
+
+	// Step 2: fan out without blocking the write path
+	for sub := range h.subs {
+		if ev.Op == OpDropNamespace {
+			if sub.ns == ev.Namespace {
+				h.end(sub, ev)
+			}
+			continue
+		}
+		if !sub.match(ev.Namespace, ev.Key) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			log.Warn().Msgf("Watcher fell %d changes behind at seq=%d, closing it", subscriberBuffer, ev.Sequence)
+			close(sub.ch)
+			delete(h.subs, sub)
+		}
+	}
+}
+
+// end sends the namespace drop ev to sub and closes it. Caller must hold mu.
+func (h *Hub) end(sub *Subscription, ev Event) {
+	select {
+	case sub.ch <- ev:
+	default:
+		log.Warn().Msgf("Watcher fell %d changes behind at seq=%d, closing it", subscriberBuffer, ev.Sequence)
+	}
+	close(sub.ch)
+	delete(h.subs, sub)
+}
+
+// Subscribe returns a subscription to changes of keys with prefix in the
+// namespace ns. afterSeq > 0 first replays the changes after it, read from
+// the history once they are no longer buffered; it fails if the history
+// doesn't hold them either. afterSeq = 0 subscribes to new changes only.
+func (h *Hub) Subscribe(afterSeq int64, ns, prefix string) (*Subscription, error) {
+	return h.subscribe(afterSeq, ns, func(evNs, key string) bool {
+		return evNs == ns && strings.HasPrefix(key, prefix)
+	})
+}
+
+// SubscribeKey is Subscribe for changes of a single key
+func (h *Hub) SubscribeKey(afterSeq int64, ns, key string) (*Subscription, error) {
+	return h.subscribe(afterSeq, ns, func(evNs, other string) bool {
+		return evNs == ns && other == key
+	})
+}
+
+func (h *Hub) subscribe(afterSeq int64, ns string, match func(ns, key string) bool) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, fmt.Errorf("change stream is closed")
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, ns: ns, match: match}
+
+	if afterSeq > 0 && afterSeq < h.lastSeq {
+		// Step 1: changes evicted from the ring, or from before a restart
+		from := afterSeq
+		for from < h.floorSeq {
+			if h.history == nil {
+				return nil, fmt.Errorf("changes after seq=%d are no longer buffered (oldest buffered after seq=%d)", afterSeq, h.floorSeq)
+			}
+			upTo := h.floorSeq
+			backlog, dropped, err := h.readHistory(from, upTo, ns, match)
+			if err != nil {
+				return nil, fmt.Errorf("changes after seq=%d are no longer available: %w", afterSeq, err)
+			}
+			if h.closed {
+				return nil, fmt.Errorf("change stream is closed")
+			}
+			sub.Backlog = append(sub.Backlog, backlog...)
+			if dropped {
+				close(ch)
+				return sub, nil
+			}
+			from = upTo // the ring may have moved on meanwhile
+		}
+
+		// Step 2: changes still in the ring
+		for i := 0; i < h.count; i++ {
+			ev := h.events[(h.start+i)%len(h.events)]
+			if ev.Sequence <= from {
+				continue
+			}
+			// Nothing after a drop belongs to this subscription
+			if ev.Op == OpDropNamespace && ev.Namespace == ns {
+				sub.Backlog = append(sub.Backlog, ev)
+				close(ch)
+				return sub, nil
+			}
+			if match(ev.Namespace, ev.Key) {
+				sub.Backlog = append(sub.Backlog, ev)
+			}
+		}
+	}
+
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// readHistory collects the changes after afterSeq up to upTo for a
+// subscription to ns, and reports whether ns was dropped among them. It
+// releases mu while reading, so writers aren't held up. Caller must hold mu.
+func (h *Hub) readHistory(afterSeq, upTo int64, ns string, match func(ns, key string) bool) (backlog []Event, dropped bool, err error) {
+	history := h.history
+	h.mu.Unlock()
+	defer h.mu.Lock()
+
+	errDropped := fmt.Errorf("namespace dropped")
+	err = history(afterSeq, upTo, match, func(ev Event) error {
+		switch {
+		case ev.Op == OpDropNamespace && ev.Namespace == ns:
+			backlog = append(backlog, ev)
+			return errDropped
+		case ev.Op != OpDropNamespace:
+			backlog = append(backlog, ev)
+		}
+		return nil
+	})
+	if err == errDropped {
+		return backlog, true, nil
+	}
+	return backlog, false, err
+}
+
+// Unsubscribe stops delivering changes to sub
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		close(sub.ch)
+		delete(h.subs, sub)
+	}
+}
+
+// Close ends every subscription, e.g. on shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.ch)
+		delete(h.subs, sub)
+	}
+}
+
+// Closed reports whether the hub has been closed
+func (h *Hub) Closed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+// LastSequence returns the sequence of the most recent change
+func (h *Hub) LastSequence() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSeq
+}
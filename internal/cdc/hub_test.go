@@ -0,0 +1,120 @@
+package cdc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	published := []Event{
+		{Namespace: "", Key: "a", Op: OpSet, Sequence: 1},
+		{Namespace: "t1", Key: "a", Op: OpSet, Sequence: 2},
+		{Namespace: "", Key: "b", Op: OpSet, Sequence: 3},
+		{Namespace: "", Key: "a", Op: OpDelete, Sequence: 4},
+		{Namespace: "t1", Op: OpDropNamespace, Sequence: 5},
+		{Namespace: "", Key: "ab", Op: OpSet, Sequence: 6},
+	}
+
+	tests := []struct {
+		name        string
+		capacity    int
+		history     bool // serve evicted changes from published
+		ns, prefix  string
+		after       int64
+		wantBacklog []int64
+		wantClosed  bool // subscription already ended by a drop
+		wantErr     bool
+	}{
+		{name: "new changes only", capacity: 10},
+		{name: "resumed", capacity: 10, after: 1, wantBacklog: []int64{3, 4, 6}},
+		{name: "by prefix", capacity: 10, prefix: "a", after: 1, wantBacklog: []int64{4, 6}},
+		{name: "caught up", capacity: 10, after: 6},
+		{name: "dropped namespace", capacity: 10, ns: "t1", after: 1, wantBacklog: []int64{2, 5}, wantClosed: true},
+		{name: "evicted", capacity: 3, after: 1, wantErr: true},
+		{name: "still buffered", capacity: 3, after: 3, wantBacklog: []int64{4, 6}},
+		{name: "evicted, from history", capacity: 3, history: true, after: 1, wantBacklog: []int64{3, 4, 6}},
+		{name: "dropped, from history", capacity: 2, history: true, ns: "t1", after: 1, wantBacklog: []int64{2, 5}, wantClosed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(tt.capacity)
+			if tt.history {
+				h.SetHistory(func(afterSeq, upTo int64, match func(ns, key string) bool, fn func(Event) error) error {
+					for _, ev := range published {
+						if ev.Sequence > afterSeq && ev.Sequence <= upTo && (ev.Op == OpDropNamespace || match(ev.Namespace, ev.Key)) {
+							if err := fn(ev); err != nil {
+								return err
+							}
+						}
+					}
+					return nil
+				})
+			}
+			for _, ev := range published {
+				h.Publish(ev)
+			}
+
+			sub, err := h.Subscribe(tt.after, tt.ns, tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var backlog []int64
+			for _, ev := range sub.Backlog {
+				backlog = append(backlog, ev.Sequence)
+			}
+			if !reflect.DeepEqual(backlog, tt.wantBacklog) {
+				t.Errorf("backlog = %v, want %v", backlog, tt.wantBacklog)
+			}
+
+			// Live changes follow the backlog, unless a drop ended the subscription
+			h.Publish(Event{Namespace: tt.ns, Key: tt.prefix + "z", Op: OpSet, Sequence: 7})
+			h.Publish(Event{Namespace: "other", Key: tt.prefix + "z", Op: OpSet, Sequence: 8})
+			h.Close()
+			var live []int64
+			for ev := range sub.C {
+				live = append(live, ev.Sequence)
+			}
+			if tt.wantClosed && len(live) != 0 {
+				t.Errorf("delivered %v after the drop", live)
+			}
+			if !tt.wantClosed && !reflect.DeepEqual(live, []int64{7}) {
+				t.Errorf("delivered %v, want [7]", live)
+			}
+		})
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	h := NewHub(0)
+	slow, err := h.Subscribe(0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.Subscribe(0, "", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seq := int64(1); seq <= subscriberBuffer+1; seq++ {
+		h.Publish(Event{Key: "a", Op: OpSet, Sequence: seq})
+	}
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("received %d changes before the subscription was closed, want %d", received, subscriberBuffer)
+	}
+
+	// Subscribers that keep up are unaffected
+	h.Publish(Event{Key: "b", Op: OpSet, Sequence: subscriberBuffer + 2})
+	if ev := <-other.C; ev.Key != "b" {
+		t.Errorf("other subscriber got %+v", ev)
+	}
+	if h.Closed() {
+		t.Error("hub closed")
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"go-kvs/api/proto/pb"
+
+	"google.golang.org/grpc"
+)
+
+type AdminClient struct {
+	client go_kvs.AdminClient
+}
+
+func NewAdminClient(conn *grpc.ClientConn) *AdminClient {
+	return &AdminClient{client: go_kvs.NewAdminClient(conn)}
+}
+
+func (a *AdminClient) ClusterStatus(ctx context.Context, in *go_kvs.ClusterStatusRequest, opts ...grpc.CallOption) (*go_kvs.ClusterStatusResponse, error) {
+	return a.client.ClusterStatus(ctx, in, opts...)
+}
+
+func (a *AdminClient) ReplicationStatus(ctx context.Context, in *go_kvs.ReplicationStatusRequest, opts ...grpc.CallOption) (*go_kvs.ReplicationStatusResponse, error) {
+	return a.client.ReplicationStatus(ctx, in, opts...)
+}
+
+func (a *AdminClient) Verify(ctx context.Context, in *go_kvs.VerifyRequest, opts ...grpc.CallOption) (*go_kvs.VerifyResponse, error) {
+	return a.client.Verify(ctx, in, opts...)
+}
+
+func (a *AdminClient) PauseReplication(ctx context.Context, in *go_kvs.PauseReplicationRequest, opts ...grpc.CallOption) (*go_kvs.ApplyStatus, error) {
+	return a.client.PauseReplication(ctx, in, opts...)
+}
+
+func (a *AdminClient) ResumeReplication(ctx context.Context, in *go_kvs.ResumeReplicationRequest, opts ...grpc.CallOption) (*go_kvs.ApplyStatus, error) {
+	return a.client.ResumeReplication(ctx, in, opts...)
+}
+
+func (a *AdminClient) ApplyUpTo(ctx context.Context, in *go_kvs.ApplyUpToRequest, opts ...grpc.CallOption) (*go_kvs.ApplyStatus, error) {
+	return a.client.ApplyUpTo(ctx, in, opts...)
+}
+
+func (a *AdminClient) Snapshot(ctx context.Context, in *go_kvs.SnapshotRequest, opts ...grpc.CallOption) (go_kvs.Admin_SnapshotClient, error) {
+	return a.client.Snapshot(ctx, in, opts...)
+}
+
+// CreateNamespace creates a namespace. Creating one that exists fails with ErrNamespaceExists.
+func (a *AdminClient) CreateNamespace(ctx context.Context, in *go_kvs.CreateNamespaceRequest, opts ...grpc.CallOption) (*go_kvs.NamespaceInfo, error) {
+	res, err := a.client.CreateNamespace(ctx, in, opts...)
+	err = redirectAdmin(err, func(leader go_kvs.AdminClient) (err error) {
+		res, err = leader.CreateNamespace(ctx, in, opts...)
+		return err
+	})
+	return res, storeErr(err)
+}
+
+// DropNamespace drops a namespace with every key in it
+func (a *AdminClient) DropNamespace(ctx context.Context, in *go_kvs.DropNamespaceRequest, opts ...grpc.CallOption) (*go_kvs.DropNamespaceResponse, error) {
+	res, err := a.client.DropNamespace(ctx, in, opts...)
+	err = redirectAdmin(err, func(leader go_kvs.AdminClient) (err error) {
+		res, err = leader.DropNamespace(ctx, in, opts...)
+		return err
+	})
+	return res, storeErr(err)
+}
+
+func (a *AdminClient) SetNamespaceQuota(ctx context.Context, in *go_kvs.SetNamespaceQuotaRequest, opts ...grpc.CallOption) (*go_kvs.NamespaceInfo, error) {
+	res, err := a.client.SetNamespaceQuota(ctx, in, opts...)
+	err = redirectAdmin(err, func(leader go_kvs.AdminClient) (err error) {
+		res, err = leader.SetNamespaceQuota(ctx, in, opts...)
+		return err
+	})
+	return res, storeErr(err)
+}
+
+func (a *AdminClient) ListNamespaces(ctx context.Context, in *go_kvs.ListNamespacesRequest, opts ...grpc.CallOption) (*go_kvs.ListNamespacesResponse, error) {
+	return a.client.ListNamespaces(ctx, in, opts...)
+}
+
+// redirectAdmin repeats a namespace write on the leader named in a "not
+// leader" error from a follower. Other errors are returned unchanged.
+func redirectAdmin(err error, call func(go_kvs.AdminClient) error) error {
+	addr, ok := LeaderAddr(err)
+	if !ok {
+		return err
+	}
+	conn, dialErr := grpc.Dial(addr, grpc.WithInsecure())
+	if dialErr != nil {
+		return err
+	}
+	defer conn.Close()
+	return call(go_kvs.NewAdminClient(conn))
+}
@@ -3,30 +3,236 @@ package client
 import (
 	"context"
 	"go-kvs/api/proto/pb"
+	"sync"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// maxRedirects bounds how many "not leader" hints a write follows; with
+// cascading replication a follower may point at another follower.
+const maxRedirects = 3
+
 type KvsClient struct {
-	client go_kvs.GoKvsClient
+	client     go_kvs.GoKvsClient
+	leader     go_kvs.GoKvsClient // set once a follower has redirected us to the leader
+	leaderAddr string
+	leaderConn *grpc.ClientConn // dialed for redirects, closed when the leader changes
+	sessionSeq int64            // highest sequence returned by our own writes
+	mu         sync.Mutex
 }
 
 func NewKvsClient(conn *grpc.ClientConn) *KvsClient {
 	return &KvsClient{client: go_kvs.NewGoKvsClient(conn)}
 }
 
-func (k *KvsClient) Get(ctx context.Context, in *go_kvs.KeyRequest, opts ...grpc.CallOption) (*go_kvs.ValResponse, error) {
-	return k.client.Get(ctx, in, opts...)
+// Close closes the connection to the leader dialed for redirects. The
+// connection passed to NewKvsClient is left to the caller.
+func (k *KvsClient) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.leaderConn == nil {
+		return nil
+	}
+	err := k.leaderConn.Close()
+	k.leader, k.leaderAddr, k.leaderConn = nil, "", nil
+	return err
+}
+
+// Get reads a key. Unless the request sets MinSequence itself, it waits for
+// the last write made through this client, giving read-your-writes on followers.
+func (k *KvsClient) Get(ctx context.Context, in *go_kvs.GetRequest, opts ...grpc.CallOption) (*go_kvs.ValResponse, error) {
+	if seq := k.SessionSequence(); in.MinSequence == 0 && seq > 0 {
+		in = proto.Clone(in).(*go_kvs.GetRequest)
+		in.MinSequence = seq
+	}
+	res, err := k.client.Get(ctx, in, opts...)
+	return res, storeErr(err)
+}
+
+func (k *KvsClient) Set(ctx context.Context, in *go_kvs.KeyValRequest, opts ...grpc.CallOption) (*go_kvs.WriteResponse, error) {
+	var res *go_kvs.WriteResponse
+	err := k.write(func(c go_kvs.GoKvsClient) (err error) {
+		res, err = c.Set(ctx, in, opts...)
+		return err
+	})
+	k.trackWrite(res)
+	return res, storeErr(err)
+}
+
+func (k *KvsClient) Del(ctx context.Context, in *go_kvs.KeyRequest, opts ...grpc.CallOption) (*go_kvs.WriteResponse, error) {
+	var res *go_kvs.WriteResponse
+	err := k.write(func(c go_kvs.GoKvsClient) (err error) {
+		res, err = c.Del(ctx, in, opts...)
+		return err
+	})
+	k.trackWrite(res)
+	return res, storeErr(err)
+}
+
+// Batch writes many keys in one call, following "not leader" redirects like Set
+func (k *KvsClient) Batch(ctx context.Context, in *go_kvs.BatchRequest, opts ...grpc.CallOption) (*go_kvs.BatchResponse, error) {
+	var res *go_kvs.BatchResponse
+	err := k.write(func(c go_kvs.GoKvsClient) (err error) {
+		res, err = c.Batch(ctx, in, opts...)
+		return err
+	})
+	if res != nil {
+		k.trackWrite(&go_kvs.WriteResponse{Sequence: res.Sequence})
+	}
+	return res, storeErr(err)
+}
+
+func (k *KvsClient) Keys(ctx context.Context, in *go_kvs.KeysRequest, opts ...grpc.CallOption) (*go_kvs.KeysResponse, error) {
+	if seq := k.SessionSequence(); in.MinSequence == 0 && seq > 0 {
+		in = proto.Clone(in).(*go_kvs.KeysRequest)
+		in.MinSequence = seq
+	}
+	res, err := k.client.Keys(ctx, in, opts...)
+	return res, storeErr(err)
+}
+
+func (k *KvsClient) Scan(ctx context.Context, in *go_kvs.ScanRequest, opts ...grpc.CallOption) (go_kvs.GoKvs_ScanClient, error) {
+	if seq := k.SessionSequence(); in.MinSequence == 0 && seq > 0 {
+		in = proto.Clone(in).(*go_kvs.ScanRequest)
+		in.MinSequence = seq
+	}
+	return k.client.Scan(ctx, in, opts...)
+}
+
+// Watch streams changes from the connected node
+func (k *KvsClient) Watch(ctx context.Context, in *go_kvs.WatchRequest, opts ...grpc.CallOption) (go_kvs.GoKvs_WatchClient, error) {
+	return k.client.Watch(ctx, in, opts...)
+}
+
+// WatchKey streams changes to one key or prefix from the connected node
+func (k *KvsClient) WatchKey(ctx context.Context, in *go_kvs.WatchKeyRequest, opts ...grpc.CallOption) (go_kvs.GoKvs_WatchKeyClient, error) {
+	return k.client.WatchKey(ctx, in, opts...)
+}
+
+// WaitForChange blocks until a key changes after a sequence or the timeout passes
+func (k *KvsClient) WaitForChange(ctx context.Context, in *go_kvs.WaitForChangeRequest, opts ...grpc.CallOption) (*go_kvs.WaitForChangeResponse, error) {
+	return k.client.WaitForChange(ctx, in, opts...)
+}
+
+// LeaderAddr extracts the leader address from a "not leader" error returned
+// by a follower. ok is false if err carries no leader hint.
+func LeaderAddr(err error) (addr string, ok bool) {
+	st, isStatus := status.FromError(err)
+	if !isStatus || st.Code() != codes.FailedPrecondition {
+		return "", false
+	}
+	for _, detail := range st.Details() {
+		if hint, isHint := detail.(*go_kvs.NotLeader); isHint && hint.LeaderAddr != "" {
+			return hint.LeaderAddr, true
+		}
+	}
+	return "", false
+}
+
+// SessionSequence returns the highest sequence assigned to a write made
+// through this client. It is the consistency token sent with Get.
+func (k *KvsClient) SessionSequence() int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.sessionSeq
+}
+
+func (k *KvsClient) trackWrite(res *go_kvs.WriteResponse) {
+	if res == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if res.Sequence > k.sessionSeq {
+		k.sessionSeq = res.Sequence
+	}
+}
+
+// write sends a write to the leader we were redirected to before, or to
+// the configured address, and follows "not leader" redirects. A remembered
+// leader that can't be reached is forgotten and the write is sent to the
+// configured address again, which points at whichever node leads now.
+func (k *KvsClient) write(call func(go_kvs.GoKvsClient) error) error {
+	client, redirected := k.writeClient()
+	err := call(client)
+	if redirected && unreachable(err) {
+		k.forgetLeader(client)
+		err = call(k.client)
+	}
+	for i := 0; i < maxRedirects; i++ {
+		leader, ok := k.redirect(err)
+		if !ok {
+			break
+		}
+		if err = call(leader); unreachable(err) {
+			k.forgetLeader(leader)
+		}
+	}
+	return err
+}
+
+// writeClient returns the leader client if we were redirected before,
+// otherwise the client for the configured address.
+func (k *KvsClient) writeClient() (go_kvs.GoKvsClient, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.leader != nil {
+		return k.leader, true
+	}
+	return k.client, false
 }
 
-func (k *KvsClient) Set(ctx context.Context, in *go_kvs.KeyValRequest, opts ...grpc.CallOption) (*go_kvs.EmptyResponse, error) {
-	return k.client.Set(ctx, in, opts...)
+// forgetLeader closes the connection to leader if it is still the one
+// remembered, so the next write starts at the configured address
+func (k *KvsClient) forgetLeader(leader go_kvs.GoKvsClient) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.leader != leader {
+		return
+	}
+	k.leaderConn.Close()
+	k.leader, k.leaderAddr, k.leaderConn = nil, "", nil
 }
 
-func (k *KvsClient) Del(ctx context.Context, in *go_kvs.KeyRequest, opts ...grpc.CallOption) (*go_kvs.EmptyResponse, error) {
-	return k.client.Del(ctx, in, opts...)
+// unreachable reports whether err means the node could not be reached
+func unreachable(err error) bool {
+	return status.Code(err) == codes.Unavailable
 }
 
-func (k *KvsClient) Keys(ctx context.Context, in *go_kvs.EmptyRequest, opts ...grpc.CallOption) (*go_kvs.KeysResponse, error) {
-	return k.client.Keys(ctx, in, opts...)
+// redirect returns a client for the leader named in a "not leader" error
+// and remembers it for subsequent writes. The connection is reused while
+// the leader stays the same; one to a previous leader is closed.
+func (k *KvsClient) redirect(err error) (go_kvs.GoKvsClient, bool) {
+	addr, ok := LeaderAddr(err)
+	if !ok {
+		return nil, false
+	}
+
+	k.mu.Lock()
+	if k.leaderConn != nil && k.leaderAddr == addr {
+		leader := k.leader
+		k.mu.Unlock()
+		return leader, true
+	}
+	k.mu.Unlock()
+
+	conn, dialErr := grpc.Dial(addr, grpc.WithInsecure())
+	if dialErr != nil {
+		return nil, false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.leaderConn != nil && k.leaderAddr == addr {
+		conn.Close() // another call dialed it first
+		return k.leader, true
+	}
+	if k.leaderConn != nil {
+		k.leaderConn.Close()
+	}
+	k.leader, k.leaderAddr, k.leaderConn = go_kvs.NewGoKvsClient(conn), addr, conn
+	return k.leader, true
 }
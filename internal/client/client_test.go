@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// node is a fake server that applies Set or answers "not leader"
+type node struct {
+	go_kvs.UnimplementedGoKvsServer
+	addr string
+	srv  *grpc.Server
+
+	mu       sync.Mutex
+	leader   *node // nil: this node is the leader
+	applied  int
+	attempts int
+}
+
+func startNode(t *testing.T) *node {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &node{addr: lis.Addr().String(), srv: grpc.NewServer()}
+	go_kvs.RegisterGoKvsServer(n.srv, n)
+	go n.srv.Serve(lis)
+	t.Cleanup(n.srv.Stop)
+	return n
+}
+
+func (n *node) follow(leader *node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leader = leader
+}
+
+func (n *node) counts() (attempts, applied int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.attempts, n.applied
+}
+
+func (n *node) Set(ctx context.Context, req *go_kvs.KeyValRequest) (*go_kvs.WriteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.attempts++
+	if n.leader != nil {
+		st, err := status.New(codes.FailedPrecondition, "not leader").WithDetails(&go_kvs.NotLeader{LeaderAddr: n.leader.addr})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	n.applied++
+	return &go_kvs.WriteResponse{Sequence: int64(n.applied)}, nil
+}
+
+func TestWriteRedirects(t *testing.T) {
+	tests := []struct {
+		name string
+		// follows[i] is the node that node i points writes at, or -1 if
+		// it is the leader. The client is configured with node 0.
+		follows []int
+		// before each write, change which node leads
+		failover []func(nodes []*node)
+		wantErr  codes.Code
+		// writes each node applied and calls the configured node got
+		applied  []int
+		attempts int
+	}{
+		{
+			name:     "configured leader",
+			follows:  []int{-1},
+			failover: []func([]*node){nil, nil},
+			applied:  []int{2},
+			attempts: 2,
+		},
+		{
+			name:     "redirect is remembered",
+			follows:  []int{1, -1},
+			failover: []func([]*node){nil, nil, nil},
+			applied:  []int{0, 3},
+			attempts: 1,
+		},
+		{
+			name:     "redirect through a relay",
+			follows:  []int{1, 2, -1},
+			failover: []func([]*node){nil, nil},
+			applied:  []int{0, 0, 2},
+			attempts: 1,
+		},
+		{
+			name:     "too many hops",
+			follows:  []int{1, 2, 3, 4, -1},
+			failover: []func([]*node){nil},
+			wantErr:  codes.FailedPrecondition,
+			applied:  []int{0, 0, 0, 0, 0},
+			attempts: 1,
+		},
+		{
+			name:    "remembered leader gone, configured node took over",
+			follows: []int{1, -1},
+			failover: []func([]*node){nil, func(nodes []*node) {
+				nodes[1].srv.Stop()
+				nodes[0].follow(nil)
+			}},
+			applied:  []int{1, 1},
+			attempts: 2,
+		},
+		{
+			name:    "remembered leader gone, another node took over",
+			follows: []int{1, -1, 1},
+			failover: []func([]*node){nil, func(nodes []*node) {
+				nodes[1].srv.Stop()
+				nodes[0].follow(nodes[2])
+				nodes[2].follow(nil)
+			}, nil},
+			applied:  []int{0, 1, 2},
+			attempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := make([]*node, len(tt.follows))
+			for i := range nodes {
+				nodes[i] = startNode(t)
+			}
+			for i, leader := range tt.follows {
+				if leader >= 0 {
+					nodes[i].follow(nodes[leader])
+				}
+			}
+
+			conn, err := grpc.Dial(nodes[0].addr, grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			client := NewKvsClient(conn)
+			defer client.Close()
+
+			for i, failover := range tt.failover {
+				if failover != nil {
+					failover(nodes)
+				}
+				_, err := client.Set(context.Background(), &go_kvs.KeyValRequest{Key: "k", Val: "v"})
+				if code := status.Code(err); code != tt.wantErr {
+					t.Fatalf("write %d: code = %v, want %v (%v)", i, code, tt.wantErr, err)
+				}
+			}
+
+			for i, n := range nodes {
+				if _, applied := n.counts(); applied != tt.applied[i] {
+					t.Errorf("node %d applied %d writes, want %d", i, applied, tt.applied[i])
+				}
+			}
+			if attempts, _ := nodes[0].counts(); attempts != tt.attempts {
+				t.Errorf("configured node got %d writes, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestLeaderAddr(t *testing.T) {
+	hinted, err := status.New(codes.FailedPrecondition, "not leader").WithDetails(&go_kvs.NotLeader{LeaderAddr: "leader:50051"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		want   string
+		wantOk bool
+	}{
+		{"hint", hinted.Err(), "leader:50051", true},
+		{"no hint", status.Error(codes.FailedPrecondition, "not leader"), "", false},
+		{"other code", status.Error(codes.Unavailable, "down"), "", false},
+		{"nil", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LeaderAddr(tt.err)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("LeaderAddr = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
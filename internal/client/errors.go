@@ -0,0 +1,74 @@
+package client
+
+import (
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors the server reports from its store, the same values as pkg/kvs's.
+// Errors returned by KvsClient's methods match them with errors.Is.
+var (
+	ErrNotFound = kvs.ErrNotFound
+	ErrCorrupt  = kvs.ErrCorrupt
+	ErrReadOnly = kvs.ErrReadOnly
+	ErrFull     = kvs.ErrFull
+	ErrClosed   = kvs.ErrClosed
+
+	ErrKeyUnavailable = kvs.ErrKeyUnavailable
+
+	ErrNamespaceNotFound = kvs.ErrNamespaceNotFound
+	ErrNamespaceExists   = kvs.ErrNamespaceExists
+)
+
+// Error is a store error returned by the server. It keeps the gRPC status,
+// so status.FromError and LeaderAddr still work on it.
+type Error struct {
+	status *status.Status
+	err    error // the store error it stands for
+}
+
+func (e *Error) Error() string {
+	return e.status.Err().Error()
+}
+
+// GRPCStatus returns the status the server sent
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// Unwrap returns the store error, e.g. ErrNotFound
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// storeErr turns a status carrying a store error's reason into an *Error.
+// Servers that don't attach reasons are matched by code where the code is
+// unambiguous. Other errors are returned as they are.
+func storeErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	for _, detail := range st.Details() {
+		if info, isInfo := detail.(*errdetails.ErrorInfo); isInfo && info.Domain == kvs.ErrorDomain {
+			if storeErr := kvs.FromReason(info.Reason); storeErr != nil {
+				return &Error{status: st, err: storeErr}
+			}
+		}
+	}
+
+	switch st.Code() {
+	case codes.NotFound:
+		return &Error{status: st, err: ErrNotFound}
+	case codes.DataLoss:
+		return &Error{status: st, err: ErrCorrupt}
+	}
+	return err
+}
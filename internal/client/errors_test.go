@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStoreErr(t *testing.T) {
+	// withReason is a status carrying a store error's reason, as the server sends it
+	withReason := func(code codes.Code, reason string) error {
+		st, err := status.New(code, "from server").WithDetails(&errdetails.ErrorInfo{Domain: kvs.ErrorDomain, Reason: reason})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.Err()
+	}
+	notLeader, err := status.New(codes.FailedPrecondition, "not leader").WithDetails(&go_kvs.NotLeader{LeaderAddr: "leader:50051"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error // nil: not a store error
+	}{
+		{"not found", withReason(codes.NotFound, "NOT_FOUND"), ErrNotFound},
+		{"namespace not found", withReason(codes.NotFound, "NAMESPACE_NOT_FOUND"), ErrNamespaceNotFound},
+		{"read-only", withReason(codes.FailedPrecondition, "READ_ONLY"), ErrReadOnly},
+		{"key unavailable", withReason(codes.FailedPrecondition, "KEY_UNAVAILABLE"), ErrKeyUnavailable},
+		{"full", withReason(codes.ResourceExhausted, "FULL"), ErrFull},
+		{"closed", withReason(codes.Unavailable, "CLOSED"), ErrClosed},
+		{"old server, not found", status.Error(codes.NotFound, "key doesn't exist"), ErrNotFound},
+		{"old server, data loss", status.Error(codes.DataLoss, "corrupt"), ErrCorrupt},
+		{"unknown reason", withReason(codes.Unavailable, "SOMETHING_NEW"), nil},
+		{"not leader", notLeader.Err(), nil},
+		{"not a status", errors.New("dial failed"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := storeErr(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("storeErr = %v, want the error unchanged", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("storeErr = %v, want %v", got, tt.want)
+			}
+			// The status is kept for callers that look at the code
+			if status.Code(got) != status.Code(tt.err) || got.Error() != tt.err.Error() {
+				t.Errorf("storeErr = %v (%s), want the status of %v", got, status.Code(got), tt.err)
+			}
+		})
+	}
+	if storeErr(nil) != nil {
+		t.Error("storeErr(nil) != nil")
+	}
+}
@@ -1,9 +1,53 @@
 package config
 
+import "time"
+
 type ServerConfig struct {
 	NodeID        string   // "node-1", "node-2", etc.
 	IsLeader      bool     // true for leader, false for followers
 	Address       string   // "localhost:50051"
-	FollowerAddrs []string // For leader: list of follower addresses
+	AdvertiseAddr string   // Client-facing address reported to the leader (defaults to Address)
+	FollowerAddrs []string // For leader: followers expected to join, seeds the membership table
 	LeaderAddr    string   // For followers: leader address
+	ProxyWrites   bool     // For followers: forward Set/Del to the leader instead of rejecting them
+
+	ServeReplication bool // For followers: re-stream applied commands to downstream followers
+
+	ReadWaitTimeout time.Duration // Max time a Get waits for its min_sequence to be applied
+	LeaseDuration   time.Duration // For leader: how long a quorum heartbeat keeps the lease valid
+	MemberTimeout   time.Duration // For leader: how long a disconnected follower counts toward the lease quorum
+
+	LagThresholdSeqs   int64         // Followers further behind than this many commands are lagging (0 = off)
+	LagThresholdTime   time.Duration // Followers further behind than this are lagging (0 = off)
+	RejectLaggingReads bool          // For followers: refuse session reads while lagging
+	MetricsAddr        string        // Serve expvar metrics on this address (empty = off)
+
+	AntiEntropyInterval time.Duration // For followers: compare and repair data against upstream this often (0 = off)
+
+	BatchSize              int           // Most commands per replication batch sent to followers
+	BatchLinger            time.Duration // How long a partial replication batch waits for more commands
+	ReplicationCompression string        // For followers: codec requested for the replication stream (none, gzip, snappy)
+
+	IncludePrefixes []string // For followers: replicate only keys with one of these prefixes (empty = all)
+	ExcludePrefixes []string // For followers: don't replicate keys with these prefixes
+
+	ApplyDelay time.Duration // For followers: apply commands this long after the leader committed them (0 = off)
+
+	ChangeBufferSize int // Recent changes kept so watchers can resume from a sequence
+
+	ShutdownTimeout time.Duration // How long shutdown waits for in-flight RPCs before cutting them off
+
+	DataDir        string // Directory for the WAL, snapshots and node metadata
+	WALSegmentSize int64  // Size at which a new WAL segment is started
+	WALArchiveDir  string // Sealed WAL segments are copied here for point-in-time recovery (empty = off)
+	WALCompression string // Codec for new WAL records (none, snappy, zstd)
+	WALCompressMin int    // Smallest record that is compressed
+	KeyFile        string // Keys for encrypting WAL records and snapshots at rest (empty = plaintext)
+	Bootstrap      bool   // For leader: create a new cluster if the data directory has no cluster ID
+	JoinCluster    bool   // For follower: join upstream's cluster even if the data directory already holds data
+
+	MaxKeySize     int   // Largest key in bytes clients may write (0 = no limit)
+	MaxValueSize   int   // Largest value in bytes clients may write (0 = no limit)
+	MaxKeys        int   // Most keys the node holds; writes adding more are refused (0 = no limit)
+	MaxDataDirSize int64 // Largest size in bytes of the data directory (0 = no limit)
 }
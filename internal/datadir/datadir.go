@@ -0,0 +1,109 @@
+package datadir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Layout of a data directory:
+//
+//	<dir>/
+//	├── LOCK        # held by the server using the directory
+//	├── identity    # node and cluster IDs
+//	├── wal/        # write-ahead log segments, named by base offset
+//	├── snapshots/  # point-in-time snapshot files
+//	└── meta/       # other node metadata
+const (
+	lockFile     = "LOCK"
+	identityFile = "identity"
+	walDir       = "wal"
+	snapshotDir  = "snapshots"
+	metaDir      = "meta"
+)
+
+// ErrLocked is returned when another process holds the directory's lock
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// Dir is an open data directory. The lock is held until Close.
+type Dir struct {
+	path string
+	lock *os.File
+}
+
+// Open creates the directory layout if needed and takes its exclusive lock
+func Open(path string) (*Dir, error) {
+	for _, sub := range []string{walDir, snapshotDir, metaDir} {
+		if err := os.MkdirAll(filepath.Join(path, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	lock, err := os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(lock); err != nil {
+		lock.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		return nil, err
+	}
+
+	// Record the owner for whoever finds the directory locked
+	lock.Truncate(0)
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+
+	return &Dir{path: path, lock: lock}, nil
+}
+
+// Path returns the directory's path
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// WALDir returns the directory holding the write-ahead log segments
+func (d *Dir) WALDir() string {
+	return filepath.Join(d.path, walDir)
+}
+
+// SnapshotDir returns the directory holding snapshot files
+func (d *Dir) SnapshotDir() string {
+	return filepath.Join(d.path, snapshotDir)
+}
+
+// MetaDir returns the directory holding other node metadata
+func (d *Dir) MetaDir() string {
+	return filepath.Join(d.path, metaDir)
+}
+
+// IdentityFile returns the path of the file holding the node and cluster IDs
+func (d *Dir) IdentityFile() string {
+	return filepath.Join(d.path, identityFile)
+}
+
+// Usage returns the total size of the files in the directory
+func (d *Dir) Usage() (int64, error) {
+	var size int64
+	err := filepath.WalkDir(d.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// Close releases the lock
+func (d *Dir) Close() error {
+	funlock(d.lock)
+	return d.lock.Close()
+}
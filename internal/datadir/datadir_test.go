@@ -0,0 +1,66 @@
+package datadir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{d.WALDir(), d.SnapshotDir(), d.MetaDir()} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("%s not created: %v", dir, err)
+		}
+	}
+	owner, err := os.ReadFile(filepath.Join(path, lockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := strconv.Atoi(strings.TrimSpace(string(owner))); pid != os.Getpid() {
+		t.Errorf("lock file names pid %q, want %d", owner, os.Getpid())
+	}
+
+	// A second server can't use the directory until the first is done with it
+	if _, err := Open(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Open = %v, want ErrLocked", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open after Close: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestUsage(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	before, err := d.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d.WALDir(), "segment"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d.SnapshotDir(), "snapshot"), make([]byte, 500), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := d.Usage(); err != nil || after-before != 1500 {
+		t.Errorf("usage grew by %d (%v), want 1500", after-before, err)
+	}
+}
@@ -0,0 +1,116 @@
+package datadir
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Identity holds the IDs persisted in a data directory: the node's own ID,
+// generated on first start, and the ID of the cluster it belongs to, set
+// by --bootstrap on a new leader or learned from upstream by a follower.
+type Identity struct {
+	path      string
+	nodeID    string
+	clusterID string
+	mu        sync.Mutex
+}
+
+type identityFileFormat struct {
+	NodeID    string `json:"node_id"`
+	ClusterID string `json:"cluster_id,omitempty"`
+}
+
+// Identity loads the directory's identity, generating a node ID if there is none yet
+func (d *Dir) Identity() (*Identity, error) {
+	id := &Identity{path: d.IdentityFile()}
+
+	data, err := os.ReadFile(id.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var stored identityFileFormat
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("%s: %w", id.path, err)
+		}
+		id.nodeID, id.clusterID = stored.NodeID, stored.ClusterID
+	}
+
+	if id.nodeID == "" {
+		id.nodeID = NewID()
+		if err := id.save(); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// NodeID returns the node's generated ID
+func (id *Identity) NodeID() string {
+	return id.nodeID
+}
+
+// ClusterID returns the ID of the node's cluster, "" if it hasn't joined one yet
+func (id *Identity) ClusterID() string {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	return id.clusterID
+}
+
+// SetClusterID records that the node belongs to clusterID
+func (id *Identity) SetClusterID(clusterID string) error {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	prev := id.clusterID
+	id.clusterID = clusterID
+	if err := id.save(); err != nil {
+		id.clusterID = prev
+		return err
+	}
+	return nil
+}
+
+// save writes the identity file atomically
+func (id *Identity) save() error {
+	data, err := json.MarshalIndent(identityFileFormat{NodeID: id.nodeID, ClusterID: id.clusterID}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(id.path, append(data, '\n'))
+}
+
+// WriteFileAtomic replaces path with data, so readers see the old or the new content
+func WriteFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// NewID returns a random (version 4) UUID
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,61 @@
+package datadir
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	path := t.TempDir()
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := d.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.NodeID() == "" || id.ClusterID() != "" {
+		t.Fatalf("new identity: node %q, cluster %q, want a node ID only", id.NodeID(), id.ClusterID())
+	}
+	if err := id.SetClusterID("cluster-1"); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	// Both IDs survive a restart
+	d, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	reloaded, err := d.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.NodeID() != id.NodeID() || reloaded.ClusterID() != "cluster-1" {
+		t.Errorf("reloaded node %q, cluster %q, want %q, cluster-1", reloaded.NodeID(), reloaded.ClusterID(), id.NodeID())
+	}
+
+	// A damaged file is reported rather than replaced with new IDs
+	if err := os.WriteFile(d.IdentityFile(), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Identity(); err == nil {
+		t.Error("loaded a damaged identity file")
+	}
+}
+
+func TestNewID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewID()
+		if len(id) != 36 || id[14] != '4' {
+			t.Fatalf("%q isn't a version 4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("%q generated twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package datadir
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// flock takes an exclusive lock on f without waiting
+func flock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
+
+// funlock releases the lock on f
+func funlock(f *os.File) {
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package datadir
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// flock takes an exclusive lock on f without waiting
+func flock(f *os.File) error {
+	ol := new(windows.Overlapped)
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+	return err
+}
+
+// funlock releases the lock on f
+func funlock(f *os.File) {
+	ol := new(windows.Overlapped)
+	windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
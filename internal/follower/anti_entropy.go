@@ -0,0 +1,334 @@
+package follower
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/antientropy"
+	"go-kvs/pkg/kvs"
+	"go-kvs/pkg/kvs/command"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errSequencePassed means the follower applied past the sequence the
+// leader's data was captured at, so the two can't be compared this round
+var errSequencePassed = status.Error(codes.Aborted, "follower already applied past the compared sequence, retry")
+
+// pendingCheck is anti-entropy work that must run when the local state
+// reflects exactly seq
+type pendingCheck struct {
+	seq  int64
+	fn   func() error
+	done chan error
+}
+
+// runAt runs fn while the local state reflects exactly seq: right away if
+// seq is the last applied sequence, otherwise from the apply loop once the
+// stream reaches it. No commands are applied while fn runs.
+func (f *StreamClient) runAt(ctx context.Context, seq int64, fn func() error) error {
+	f.applyMu.Lock()
+	if f.lastSequence == seq {
+		defer f.applyMu.Unlock()
+		return fn()
+	}
+	if f.lastSequence > seq {
+		f.applyMu.Unlock()
+		return errSequencePassed
+	}
+
+	check := &pendingCheck{seq: seq, fn: fn, done: make(chan error, 1)}
+	f.pending = append(f.pending, check)
+	f.applyMu.Unlock()
+
+	select {
+	case err := <-check.done:
+		return err
+	case <-ctx.Done():
+		f.applyMu.Lock()
+		defer f.applyMu.Unlock()
+		for i, other := range f.pending {
+			if other == check {
+				f.pending = append(f.pending[:i], f.pending[i+1:]...)
+				break
+			}
+		}
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// runPending runs the checks whose sequence has been reached. nextSeq is
+// the sequence about to be applied (0 after applying): a check for a
+// sequence the stream skips over runs before the next command.
+// Caller must hold applyMu.
+func (f *StreamClient) runPending(nextSeq int64) {
+	if len(f.pending) == 0 {
+		return
+	}
+
+	kept := f.pending[:0]
+	for _, check := range f.pending {
+		switch {
+		case f.lastSequence == check.seq, nextSeq > check.seq:
+			check.done <- check.fn()
+		case f.lastSequence > check.seq:
+			check.done <- errSequencePassed
+		default:
+			kept = append(kept, check)
+		}
+	}
+	f.pending = kept
+}
+
+// localSnapshot captures the keys this replica holds in the namespace ns.
+// Caller must hold applyMu.
+func (f *StreamClient) localSnapshot(ns string) (*kvs.Snapshot, error) {
+	snap, err := f.kvs.SnapshotIn(ns, "")
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if !f.filter.Empty() {
+		snap = snap.Filter(f.filter.Match)
+	}
+	return snap, nil
+}
+
+// ConsistentSnapshot captures the namespace ns of the local data together
+// with the sequence it reflects. Used when this follower serves
+// anti-entropy to downstream nodes.
+func (f *StreamClient) ConsistentSnapshot(ns string) (*kvs.Snapshot, int64, error) {
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	snap, err := f.kvs.SnapshotIn(ns, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	if !f.filter.Empty() {
+		snap = snap.Filter(f.filter.Match)
+	}
+	return snap, f.lastSequence, nil
+}
+
+// ConsistentSnapshotAll captures every namespace of the local data at the
+// same sequence, for snapshots served to backups
+func (f *StreamClient) ConsistentSnapshotAll() ([]*kvs.Snapshot, int64) {
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	snaps := f.kvs.SnapshotAll()
+	if !f.filter.Empty() {
+		for i, snap := range snaps {
+			snaps[i] = snap.Filter(f.filter.Match)
+		}
+	}
+	return snaps, f.lastSequence
+}
+
+// Verify compares the local data in the namespace ns with the upstream node:
+//  1. fetch the upstream Merkle tree and build the local one at the same sequence
+//  2. fetch the entries of the ranges whose hashes differ
+//  3. diff them against the local entries at the sequence they were read at,
+//     and with repair, overwrite the local entries with the upstream ones
+func (f *StreamClient) Verify(ctx context.Context, ns string, depth int, repair bool) (*antientropy.Report, error) {
+	if depth == 0 {
+		depth = antientropy.DefaultDepth
+	}
+	if f.applyHeld() {
+		return nil, status.Error(codes.FailedPrecondition, "anti-entropy is not available while commands are delayed or paused")
+	}
+
+	f.mu.Lock()
+	leader := f.leader
+	f.mu.Unlock()
+	if leader == nil {
+		return nil, status.Error(codes.Unavailable, "not connected to leader")
+	}
+
+	// Step 1: compare trees at the upstream node's current sequence
+	treeRes, err := leader.MerkleTree(ctx, &gokvs.MerkleTreeRequest{Depth: int32(depth), Filter: f.filter.Proto(), Namespace: ns})
+	if err != nil {
+		return nil, err
+	}
+	upstreamTree, err := antientropy.FromLeaves(int(treeRes.Depth), treeRes.Leaves)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var snap *kvs.Snapshot
+	err = f.runAt(ctx, treeRes.Sequence, func() error {
+		snap, err = f.localSnapshot(ns)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	localTree, err := antientropy.BuildTree(snap, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &antientropy.Report{Namespace: ns, Sequence: treeRes.Sequence, Depth: depth}
+	buckets := antientropy.Diff(upstreamTree, localTree)
+	if len(buckets) == 0 {
+		return report, nil
+	}
+	log.Info().Msgf("Anti-entropy: %d of %d ranges of namespace %q differ at seq=%d", len(buckets), 1<<uint(depth), ns, treeRes.Sequence)
+
+	// Step 2: fetch upstream entries of the differing ranges
+	req := &gokvs.FetchRangesRequest{Depth: int32(depth), Filter: f.filter.Proto(), Namespace: ns}
+	for _, bucket := range buckets {
+		req.Buckets = append(req.Buckets, int32(bucket))
+	}
+	rangesRes, err := leader.FetchRanges(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	upstream := make(map[string]string, len(rangesRes.Entries))
+	for _, entry := range rangesRes.Entries {
+		upstream[entry.Key] = entry.Value
+	}
+
+	// Step 3: diff (and repair) at the sequence the entries were read at
+	report.Sequence = rangesRes.Sequence
+	err = f.runAt(ctx, rangesRes.Sequence, func() error {
+		snap, err := f.localSnapshot(ns)
+		if err != nil {
+			return err
+		}
+		local, err := antientropy.RangeEntries(snap, depth, buckets)
+		if err != nil {
+			return err
+		}
+
+		report.Ranges = antientropy.CompareRanges(depth, upstream, local)
+		if repair {
+			report.Repaired, err = f.repairRanges(ns, report.Ranges, upstream)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// repairRanges makes the local entries match the upstream ones. Each
+// repair is applied like a replicated command at the compared sequence, so
+// it is published to watchers and relayed to downstream nodes, which would
+// otherwise keep the divergence. Caller must hold applyMu.
+func (f *StreamClient) repairRanges(ns string, ranges []antientropy.RangeDiff, upstream map[string]string) (int, error) {
+	repaired := 0
+	repair := func(op, key string) error {
+		cmd, err := f.repairCommand(ns, op, key, upstream[key])
+		if err != nil {
+			return err
+		}
+		if err := f.applyCommand(cmd); err != nil {
+			return err
+		}
+		if f.relay != nil {
+			f.relay.Relay(cmd)
+		}
+		repaired++
+		return nil
+	}
+
+	for _, diff := range ranges {
+		for _, key := range append(diff.Missing, diff.Different...) {
+			if err := repair("set", key); err != nil {
+				return repaired, err
+			}
+		}
+		for _, key := range diff.Extra {
+			if err := repair("del", key); err != nil {
+				return repaired, err
+			}
+		}
+	}
+	return repaired, nil
+}
+
+// repairCommand builds the replicated form of a repair at the last applied
+// sequence. Caller must hold applyMu.
+func (f *StreamClient) repairCommand(ns, op, key, val string) (*gokvs.ReplicationCommand, error) {
+	now := time.Now()
+	c := command.New(op, key, val)
+	c.Namespace = ns
+	c.Seq = f.lastSequence
+	c.Time = now.UnixNano()
+	data, err := c.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return &gokvs.ReplicationCommand{Command: data, Sequence: f.lastSequence, CommitTime: timestamppb.New(now), Repair: true}, nil
+}
+
+// RunAntiEntropy compares and repairs each local namespace against the
+// upstream node every interval, in the background until Stop
+func (f *StreamClient) RunAntiEntropy(interval time.Duration) {
+	f.running.Add(1)
+	go f.antiEntropyLoop(interval)
+}
+
+func (f *StreamClient) antiEntropyLoop(interval time.Duration) {
+	defer f.running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.ctx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(f.ctx, interval)
+		for _, ns := range f.kvs.Namespaces() {
+			if !f.checkNamespace(ctx, ns.Name) {
+				break
+			}
+		}
+		cancel()
+
+		if f.ctx.Err() != nil {
+			return // stopped mid-check
+		}
+	}
+}
+
+// checkNamespace runs one anti-entropy check of the namespace ns and logs
+// the outcome. It returns false if the remaining namespaces should wait
+// for the next round.
+func (f *StreamClient) checkNamespace(ctx context.Context, ns string) bool {
+	report, err := f.Verify(ctx, ns, antientropy.DefaultDepth, true)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		switch {
+		case errors.Is(err, errSequencePassed), status.Code(err) == codes.NotFound:
+			log.Debug().Err(err).Msgf("Anti-entropy check of namespace %q skipped", ns)
+			return true
+		case status.Code(err) == codes.Unavailable, status.Code(err) == codes.FailedPrecondition:
+			log.Debug().Err(err).Msg("Anti-entropy check skipped")
+		default:
+			log.Error().Err(err).Msgf("Anti-entropy check of namespace %q failed", ns)
+		}
+		return false
+	}
+
+	if len(report.Ranges) > 0 {
+		log.Warn().Msgf("Anti-entropy repaired %d keys in %d ranges of namespace %q at seq=%d", report.Repaired, len(report.Ranges), ns, report.Sequence)
+	} else {
+		log.Debug().Msgf("Anti-entropy: namespace %q in sync at seq=%d", ns, report.Sequence)
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package follower
+
+import (
+	"reflect"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/antientropy"
+	"go-kvs/internal/cdc"
+	"go-kvs/internal/replication"
+	"go-kvs/pkg/kvs"
+)
+
+// newTestClient is a stream client over a store in a temp dir that has
+// applied cmds
+func newTestClient(t *testing.T, id string, cmds ...*gokvs.ReplicationCommand) *StreamClient {
+	t.Helper()
+	store, err := kvs.New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	f := NewStreamClient(id, "", "", store)
+	for _, cmd := range cmds {
+		if err := f.handleCommand(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestRepairRanges(t *testing.T) {
+	upstream := map[string]string{"a": "upstream", "b": "upstream"}
+
+	tests := []struct {
+		name   string
+		diff   antientropy.RangeDiff
+		want   map[string]string // values after the repair, "" if deleted
+		wantOp cdc.Op
+	}{
+		{"missing key set", antientropy.RangeDiff{Missing: []string{"b"}}, map[string]string{"b": "upstream"}, cdc.OpSet},
+		{"different key overwritten", antientropy.RangeDiff{Different: []string{"a"}}, map[string]string{"a": "upstream"}, cdc.OpSet},
+		{"extra key deleted", antientropy.RangeDiff{Extra: []string{"c"}}, map[string]string{"c": ""}, cdc.OpDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestClient(t, "relaying")
+			relay := replication.NewStreamManager(0, nil)
+			t.Cleanup(relay.Close)
+			f.SetRelay(relay)
+			hub := cdc.NewHub(0)
+			f.SetChangeHub(hub)
+			for _, cmd := range []*gokvs.ReplicationCommand{replicated(t, 1, "set", "a"), replicated(t, 2, "set", "c")} {
+				if err := f.handleCommand(cmd); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sub, err := hub.Subscribe(2, kvs.DefaultNamespace, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.applyMu.Lock()
+			repaired, err := f.repairRanges(kvs.DefaultNamespace, []antientropy.RangeDiff{tt.diff}, upstream)
+			f.applyMu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repaired != 1 {
+				t.Errorf("repaired %d keys, want 1", repaired)
+			}
+			if got := f.LastSequence(); got != 2 {
+				t.Errorf("applied seq = %d, want 2", got)
+			}
+
+			// Published to watchers at the compared sequence
+			select {
+			case ev := <-sub.C:
+				if ev.Op != tt.wantOp || ev.Sequence != 2 {
+					t.Errorf("event op=%v seq=%d, want op=%v seq=2", ev.Op, ev.Sequence, tt.wantOp)
+				}
+			default:
+				t.Error("repair wasn't published")
+			}
+
+			// Relayed after the commands it follows, and applied downstream
+			relayed, ok := relay.GetMissedCommands(0)
+			if !ok || len(relayed) != 3 || !relayed[2].Repair || relayed[2].Sequence != 2 {
+				t.Fatalf("relayed %v, want the history and a repair at seq=2", relayed)
+			}
+			downstream := newTestClient(t, "downstream", relayed...)
+
+			for _, node := range []*StreamClient{f, downstream} {
+				for key, want := range tt.want {
+					got, _, err := node.kvs.LookupIn(kvs.DefaultNamespace, key)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != want {
+						t.Errorf("%s: %s = %q, want %q", node.nodeID, key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEnqueueRepairs(t *testing.T) {
+	repair := func(seq int64) *gokvs.ReplicationCommand {
+		cmd := replicated(t, seq, "set", "a")
+		cmd.Repair = true
+		return cmd
+	}
+
+	tests := []struct {
+		name string
+		cmds []*gokvs.ReplicationCommand
+		want []int64 // queued sequences
+	}{
+		{"repair at the last sequence", []*gokvs.ReplicationCommand{replicated(t, 1, "set", "a"), repair(1)}, []int64{1, 1}},
+		{"repair followed by a command", []*gokvs.ReplicationCommand{replicated(t, 1, "set", "a"), repair(1), replicated(t, 2, "del", "a")}, []int64{1, 1, 2}},
+		{"repair after a later command", []*gokvs.ReplicationCommand{replicated(t, 1, "set", "a"), replicated(t, 2, "del", "a"), repair(1)}, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestClient(t, "queued")
+			for _, cmd := range tt.cmds {
+				f.enqueue([]*gokvs.ReplicationCommand{cmd})
+			}
+			var got []int64
+			for _, cmd := range f.queue {
+				got = append(got, cmd.Sequence)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package follower
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/datadir"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Files kept in the queue directory (see SetQueueDir):
+//
+//	apply-queue   # received commands, each as [crc:4][length:4][ReplicationCommand]
+//	apply-paused  # the apply-up-to limit; present only while replication is paused
+const (
+	queueFileName  = "apply-queue"
+	pausedFileName = "apply-paused"
+)
+
+// queueCompactMin is how many applied commands the queue file holds before
+// it is rewritten with only the queued ones
+const queueCompactMin = 1024
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// queueFile persists the apply queue. Commands are appended in the order
+// they were received, so any prefix of the file is a valid queue: a torn
+// tail only means those commands are fetched from upstream again.
+type queueFile struct {
+	dir     string
+	f       *os.File
+	records int // commands in the file, applied or not
+}
+
+// openQueueFile opens the queue file in dir and returns the commands in it
+// after applied. A damaged tail is truncated.
+func openQueueFile(dir string, applied int64) (*queueFile, []*gokvs.ReplicationCommand, error) {
+	path := filepath.Join(dir, queueFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q := &queueFile{dir: dir, f: f}
+	var cmds []*gokvs.ReplicationCommand
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		cmd, n, err := readQueued(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Truncating %s at offset %d", path, valid)
+			break
+		}
+		valid += n
+		q.records++
+		if cmd.Sequence > applied {
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return q, cmds, nil
+}
+
+// readQueued reads one command and returns it with its size in the file
+func readQueued(r io.Reader) (*gokvs.ReplicationCommand, int64, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("torn record")
+		}
+		return nil, 0, err
+	}
+
+	// A damaged length can't make this allocate more than the file holds
+	length := binary.BigEndian.Uint32(head[4:8])
+	data, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < int(length) {
+		return nil, 0, errors.New("torn record")
+	}
+	if crc32.Checksum(append(head[4:8:8], data...), crcTable) != binary.BigEndian.Uint32(head[0:4]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+
+	cmd := &gokvs.ReplicationCommand{}
+	if err := proto.Unmarshal(data, cmd); err != nil {
+		return nil, 0, fmt.Errorf("bad command: %w", err)
+	}
+	return cmd, int64(len(head)) + int64(length), nil
+}
+
+// encodeQueued frames cmds as queue file records
+func encodeQueued(cmds []*gokvs.ReplicationCommand) ([]byte, error) {
+	var out []byte
+	for _, cmd := range cmds {
+		data, err := proto.Marshal(cmd)
+		if err != nil {
+			return nil, err
+		}
+		record := make([]byte, 8+len(data))
+		binary.BigEndian.PutUint32(record[4:8], uint32(len(data)))
+		copy(record[8:], data)
+		binary.BigEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crcTable))
+		out = append(out, record...)
+	}
+	return out, nil
+}
+
+// append adds cmds to the file and syncs it
+func (q *queueFile) append(cmds []*gokvs.ReplicationCommand) error {
+	data, err := encodeQueued(cmds)
+	if err != nil {
+		return err
+	}
+	if _, err := q.f.Write(data); err != nil {
+		return err
+	}
+	q.records += len(cmds)
+	return q.f.Sync()
+}
+
+// rewrite replaces the file with cmds
+func (q *queueFile) rewrite(cmds []*gokvs.ReplicationCommand) error {
+	data, err := encodeQueued(cmds)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.dir, queueFileName)
+	if err := datadir.WriteFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	q.f.Close()
+	q.f = f
+	q.records = len(cmds)
+	return nil
+}
+
+func (q *queueFile) close() error {
+	return q.f.Close()
+}
+
+// savePaused records whether replication is paused, and up to where commands
+// are still applied, so a restart doesn't resume it
+func (q *queueFile) savePaused(paused bool, limit int64) error {
+	path := filepath.Join(q.dir, pausedFileName)
+	if !paused {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return datadir.WriteFileAtomic(path, []byte(strconv.FormatInt(limit, 10)+"\n"))
+}
+
+// loadPaused returns the pause state saved by savePaused
+func (q *queueFile) loadPaused() (paused bool, limit int64, err error) {
+	data, err := os.ReadFile(filepath.Join(q.dir, pausedFileName))
+	if os.IsNotExist(err) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	limit, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("%s: %w", pausedFileName, err)
+	}
+	return true, limit, nil
+}
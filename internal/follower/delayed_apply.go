@@ -0,0 +1,322 @@
+package follower
+
+import (
+	"context"
+	"time"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/replication"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetApplyDelay makes the follower apply each command only once delay has
+// passed since the leader committed it. Must be called before ConnectToLeader.
+func (f *StreamClient) SetApplyDelay(delay time.Duration) {
+	f.applyDelay = delay
+}
+
+// SetQueueDir keeps the apply queue and the pause state in files in dir.
+// A delayed or paused follower then restarts with the commands it received
+// but hadn't applied yet, even if upstream no longer holds them for
+// catch-up, and stays paused. Must be called before ConnectToLeader.
+func (f *StreamClient) SetQueueDir(dir string) error {
+	q, cmds, err := openQueueFile(dir, f.lastSequence)
+	if err != nil {
+		return err
+	}
+	paused, limit, err := q.loadPaused()
+	if err != nil {
+		q.close()
+		return err
+	}
+
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	f.queueFile = q
+	f.queue = cmds
+	if len(cmds) > 0 {
+		f.received = cmds[len(cmds)-1].Sequence
+		log.Info().Msgf("Loaded %d queued commands (seq=%d..%d)", len(cmds), cmds[0].Sequence, f.received)
+	}
+	f.paused, f.applyLimit = paused, limit
+	if paused {
+		log.Warn().Msgf("Replication is paused at seq=%d, resume it to apply queued commands", max64(f.lastSequence, limit))
+	}
+	return nil
+}
+
+// enqueue queues received commands for the applier
+func (f *StreamClient) enqueue(cmds []*gokvs.ReplicationCommand) {
+	f.queueMu.Lock()
+	added := len(f.queue)
+	for _, cmd := range cmds {
+		if cmd.Repair {
+			// An anti-entropy repair re-states a key at the last sequence;
+			// once a later command arrived, it would undo that command
+			if cmd.Sequence == max64(f.received, f.applied.Load()) {
+				f.queue = append(f.queue, cmd)
+			}
+			continue
+		}
+		if cmd.Sequence <= f.received {
+			continue // already queued before a reconnect
+		}
+		f.queue = append(f.queue, cmd)
+		f.received = cmd.Sequence
+	}
+	f.persistQueued(f.queue[added:])
+	f.queueMu.Unlock()
+
+	f.wakeApplier()
+}
+
+// persistQueued appends cmds to the queue file while commands are held
+// back. Caller must hold queueMu.
+func (f *StreamClient) persistQueued(cmds []*gokvs.ReplicationCommand) {
+	if f.queueFile == nil || len(cmds) == 0 || !f.heldLocked() {
+		return
+	}
+	if err := f.queueFile.append(cmds); err != nil {
+		log.Error().Err(err).Msg("Failed to persist queued commands, they are fetched from upstream again after a restart")
+	}
+}
+
+// persistQueue rewrites the queue file with the whole queue. Caller must
+// hold queueMu.
+func (f *StreamClient) persistQueue() {
+	if f.queueFile == nil {
+		return
+	}
+	if err := f.queueFile.rewrite(f.queue); err != nil {
+		log.Error().Err(err).Msg("Failed to persist the apply queue")
+	}
+}
+
+// persistPaused records the pause state. Caller must hold queueMu.
+func (f *StreamClient) persistPaused() {
+	if f.queueFile == nil {
+		return
+	}
+	if err := f.queueFile.savePaused(f.paused, f.applyLimit); err != nil {
+		log.Error().Err(err).Msg("Failed to persist the pause state, it is lost on a restart")
+	}
+}
+
+// receivedSequence is where the stream resumes after a reconnect: queued
+// commands don't need to be sent again
+func (f *StreamClient) receivedSequence() int64 {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	return max64(f.received, f.applied.Load())
+}
+
+func (f *StreamClient) wakeApplier() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runApplier applies queued commands in order as they become due, until Stop
+func (f *StreamClient) runApplier() {
+	defer f.running.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for f.ctx.Err() == nil {
+		cmd, wait := f.nextDue()
+		if cmd != nil {
+			err := f.handleCommand(cmd)
+			f.dequeue(cmd, err)
+			continue
+		}
+
+		var due <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-f.wake:
+		case <-due:
+		case <-f.ctx.Done():
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// nextDue returns the next command to apply, which stays queued until
+// dequeue. If none is due, it returns how long until the head of the queue
+// is, or 0 to wait for a wake-up.
+func (f *StreamClient) nextDue() (*gokvs.ReplicationCommand, time.Duration) {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+
+	if len(f.queue) == 0 {
+		return nil, 0
+	}
+	head := f.queue[0]
+
+	if f.paused {
+		// ApplyUpTo lets a paused follower apply up to applyLimit, delay or not
+		if head.Sequence > f.applyLimit {
+			return nil, 0
+		}
+	} else if wait := time.Until(f.dueTime(head)); wait > 0 {
+		return nil, wait
+	}
+	return head, 0
+}
+
+// dequeue removes cmd, the head of the queue, once applyErr tells how
+// applying it went, and completes a waiting ApplyUpTo
+func (f *StreamClient) dequeue(cmd *gokvs.ReplicationCommand, applyErr error) {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+
+	f.queue[0] = nil
+	f.queue = f.queue[1:]
+
+	// Leave applied commands out of the queue file once they make up most of it
+	if q := f.queueFile; q != nil && q.records-len(f.queue) >= queueCompactMin && q.records >= 2*len(f.queue) {
+		f.persistQueue()
+	}
+
+	if w := f.upTo; w != nil {
+		if applyErr != nil && cmd.Sequence <= w.target {
+			w.done <- status.Errorf(codes.Internal, "failed to apply seq=%d: %v", cmd.Sequence, applyErr)
+			f.upTo = nil
+		} else if cmd.Sequence >= w.target {
+			w.done <- nil
+			f.upTo = nil
+		}
+	}
+}
+
+// dueTime is when cmd may be applied
+func (f *StreamClient) dueTime(cmd *gokvs.ReplicationCommand) time.Time {
+	if f.applyDelay <= 0 || cmd.CommitTime == nil {
+		return time.Time{}
+	}
+	return cmd.CommitTime.AsTime().Add(f.applyDelay)
+}
+
+// PauseApply stops applying commands. They keep being received and queued.
+func (f *StreamClient) PauseApply() {
+	f.queueMu.Lock()
+	f.pauseLocked(0)
+	f.queueMu.Unlock()
+
+	log.Warn().Msg("Replication paused, received commands are queued")
+}
+
+// ResumeApply applies queued commands again, each once it is due
+func (f *StreamClient) ResumeApply() {
+	f.queueMu.Lock()
+	f.paused = false
+	f.applyLimit = 0
+	f.persistPaused()
+	f.queueMu.Unlock()
+
+	log.Info().Msg("Replication resumed")
+	f.wakeApplier()
+}
+
+// pauseLocked pauses replication, still applying commands up to limit.
+// Caller must hold queueMu.
+func (f *StreamClient) pauseLocked(limit int64) {
+	if !f.heldLocked() {
+		f.persistQueue() // queued commands weren't persisted while nothing was held
+	}
+	f.paused = true
+	f.applyLimit = limit
+	f.persistPaused()
+}
+
+// applyUpTo is an ApplyUpTo call waiting for the applier to reach target
+type applyUpTo struct {
+	target int64
+	done   chan error
+}
+
+// ApplyUpTo pauses replication and applies queued commands up to seq right
+// away, ignoring the delay. It returns once they are applied, or with the
+// error of the first one that fails to apply.
+func (f *StreamClient) ApplyUpTo(ctx context.Context, seq int64) error {
+	f.queueMu.Lock()
+	if applied := f.applied.Load(); seq < applied {
+		f.queueMu.Unlock()
+		return status.Errorf(codes.FailedPrecondition, "seq=%d is already applied (applied seq=%d)", seq, applied)
+	}
+	f.pauseLocked(seq)
+	target := seq
+	if target > f.received {
+		target = f.received // wait only for what has been received so far
+	}
+	if len(f.queue) == 0 || f.queue[0].Sequence > target {
+		f.queueMu.Unlock()
+		log.Warn().Msgf("Replication paused at seq=%d", f.applied.Load())
+		return nil
+	}
+	if prev := f.upTo; prev != nil {
+		prev.done <- status.Errorf(codes.Aborted, "superseded by apply-up-to seq=%d", seq)
+	}
+	w := &applyUpTo{target: target, done: make(chan error, 1)}
+	f.upTo = w
+	f.queueMu.Unlock()
+
+	log.Warn().Msgf("Replication paused, applying up to seq=%d", seq)
+	f.wakeApplier()
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		f.queueMu.Lock()
+		if f.upTo == w {
+			f.upTo = nil
+		}
+		f.queueMu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// applyHeld reports whether commands are held back by a delay or a pause
+func (f *StreamClient) applyHeld() bool {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	return f.heldLocked()
+}
+
+// heldLocked is applyHeld for callers holding queueMu
+func (f *StreamClient) heldLocked() bool {
+	return f.applyDelay > 0 || f.paused
+}
+
+// ApplyState returns the state of the apply queue
+func (f *StreamClient) ApplyState() replication.ApplyState {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+
+	state := replication.ApplyState{
+		Applied:  f.applied.Load(),
+		Received: max64(f.received, f.applied.Load()),
+		Queued:   len(f.queue),
+		Paused:   f.paused,
+		Delay:    f.applyDelay,
+	}
+	if len(f.queue) > 0 {
+		state.NextApply = f.dueTime(f.queue[0])
+	}
+	return state
+}
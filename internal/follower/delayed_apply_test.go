@@ -0,0 +1,140 @@
+package follower
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestApplyQueueRestart(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		pause      bool
+		applyUpTo  int64 // ApplyUpTo before the restart, 0 = none
+		wantSeq    int64 // applied before the restart
+		wantQueued int   // after the restart
+		wantPaused bool  // after the restart
+	}{
+		{name: "delayed", delay: time.Hour, wantQueued: 3},
+		{name: "paused", pause: true, wantQueued: 3, wantPaused: true},
+		{name: "paused after apply-up-to", pause: true, applyUpTo: 2, wantSeq: 2, wantQueued: 1, wantPaused: true},
+		{name: "delayed after apply-up-to", delay: time.Hour, applyUpTo: 1, wantSeq: 1, wantQueued: 2, wantPaused: true},
+		{name: "applied right away", wantSeq: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, queueDir := t.TempDir(), t.TempDir()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// start opens the store and the queue file and runs the applier
+			start := func(delay time.Duration) (*kvs.Kvs, *StreamClient) {
+				store, err := kvs.New(dir, 0, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f := NewStreamClient("delayed", "", "", store)
+				f.SetApplyDelay(delay)
+				if err := f.SetQueueDir(queueDir); err != nil {
+					t.Fatal(err)
+				}
+				f.running.Add(1)
+				go f.runApplier()
+				return store, f
+			}
+
+			store, f := start(tt.delay)
+			if tt.pause {
+				f.PauseApply()
+			}
+			for i, key := range []string{"a", "b", "c"} {
+				cmd := replicated(t, int64(i+1), "set", key)
+				cmd.CommitTime = timestamppb.Now()
+				f.enqueue([]*gokvs.ReplicationCommand{cmd})
+			}
+			if tt.applyUpTo > 0 {
+				if err := f.ApplyUpTo(ctx, tt.applyUpTo); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.wantSeq > 0 {
+				if err := f.applied.Wait(ctx, tt.wantSeq); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.Stop()
+			store.Close()
+
+			// Restarted without a delay, the queued commands are still held
+			// while paused, and applied once resumed
+			store, f = start(0)
+			defer store.Close()
+			defer f.Stop()
+			state := f.ApplyState()
+			if state.Applied != tt.wantSeq || state.Received != 3 || state.Queued != tt.wantQueued || state.Paused != tt.wantPaused {
+				t.Errorf("after restart: %+v, want applied=%d received=3 queued=%d paused=%v", state, tt.wantSeq, tt.wantQueued, tt.wantPaused)
+			}
+			if tt.wantPaused {
+				time.Sleep(10 * time.Millisecond)
+				if seq := f.LastSequence(); seq != tt.wantSeq {
+					t.Errorf("applied seq = %d while paused, want %d", seq, tt.wantSeq)
+				}
+				f.ResumeApply()
+			}
+			if err := f.applied.Wait(ctx, 3); err != nil {
+				t.Fatalf("queued commands weren't applied after the restart: %v", err)
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				if val, _, _ := store.Lookup(key); val != "value" {
+					t.Errorf("%s = %q, want value", key, val)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyQueueTornTail(t *testing.T) {
+	queueDir := t.TempDir()
+	store, err := kvs.New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	f := NewStreamClient("delayed", "", "", store)
+	f.SetApplyDelay(time.Hour)
+	if err := f.SetQueueDir(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	for seq := int64(1); seq <= 3; seq++ {
+		f.enqueue([]*gokvs.ReplicationCommand{replicated(t, seq, "set", "a")})
+	}
+	f.Stop()
+
+	// A crash in the middle of the last append
+	path := filepath.Join(queueDir, queueFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	f = NewStreamClient("delayed", "", "", store)
+	if err := f.SetQueueDir(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	if state := f.ApplyState(); state.Queued != 2 || state.Received != 2 {
+		t.Errorf("after a torn append: %+v, want seq 1..2 queued", state)
+	}
+}
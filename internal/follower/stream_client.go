@@ -6,148 +6,554 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/replication"
 	"go-kvs/pkg/kvs"
 	"go-kvs/pkg/kvs/command"
 
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// skipMarkInterval is how many sequences a replica may skip before it
+// records the sequence in its WAL without a write
+const skipMarkInterval = 1024
+
 type StreamClient struct {
 	nodeID       string
+	addr         string // client-facing address advertised to the leader
 	leaderAddr   string
 	kvs          *kvs.Kvs
-	lastSequence int64
-	seqFile      string
-}
+	lastSequence int64 // durable in the WAL with the command that set it, or by markSkipped
+	applied      *replication.SequenceWaiter
+	leader       gokvs.ReplicationClient    // nil while disconnected
+	relay        *replication.StreamManager // re-streams applied commands to downstream followers
+	lag          replication.Lag            // as reported by the last heartbeat
+	lagLimit     replication.LagThreshold
+	compression  gokvs.Compression     // requested for the replication stream
+	filter       replication.KeyFilter // keys this replica holds
+	changes      *cdc.Hub              // change stream for watchers, nil if disabled
+	identity     *datadir.Identity     // checked against upstream's IDs, nil to skip the check
+	joinCluster  bool                  // join upstream's cluster even with data of unknown origin
+	pending      []*pendingCheck       // anti-entropy work waiting for a sequence, guarded by applyMu
+	applyMu      sync.Mutex            // held while applying a command
+	mu           sync.Mutex
 
-func NewStreamClient(nodeID, leaderAddr string, kvs *kvs.Kvs) *StreamClient {
-	seqFile := fmt.Sprintf(".%s.seq", nodeID)
+	// Received commands wait in queue until applied (see delayed_apply.go)
+	applyDelay time.Duration
+	queue      []*gokvs.ReplicationCommand // the head stays queued while it is applied
+	queueFile  *queueFile                  // persists queue and pause state, nil to keep them in memory
+	received   int64                       // sequence of the last queued command
+	paused     bool
+	applyLimit int64      // while paused, commands up to here are still applied
+	upTo       *applyUpTo // the ApplyUpTo call waiting for the applier, if any
+	wake       chan struct{}
+	queueMu    sync.Mutex
 
+	ctx     context.Context // cancelled by Stop
+	stop    context.CancelFunc
+	running sync.WaitGroup // the applier and anti-entropy
+}
+
+func NewStreamClient(nodeID, addr, leaderAddr string, kvs *kvs.Kvs) *StreamClient {
 	client := &StreamClient{
 		nodeID:       nodeID,
+		addr:         addr,
 		leaderAddr:   leaderAddr,
 		kvs:          kvs,
-		lastSequence: 0,
-		seqFile:      seqFile,
+		lastSequence: kvs.LastSequence(),
+		wake:         make(chan struct{}, 1),
 	}
+	client.ctx, client.stop = context.WithCancel(context.Background())
 
-	// Load last sequence from file
-	client.loadLastSequence()
+	// Sequence files from older versions are folded into the WAL once
+	client.migrateSequenceFile(fmt.Sprintf(".%s.seq", nodeID))
+	log.Info().Msgf("Loaded last sequence: %d", client.lastSequence)
+	client.applied = replication.NewSequenceWaiter(client.lastSequence)
 
 	return client
 }
 
-// loadLastSequence loads the last applied sequence from disk
-func (f *StreamClient) loadLastSequence() {
-	data, err := os.ReadFile(f.seqFile)
+// SetRelay makes the client re-stream every applied command through relay,
+// keeping the original sequence numbers. Must be called before ConnectToLeader.
+func (f *StreamClient) SetRelay(relay *replication.StreamManager) {
+	f.relay = relay
+	relay.Resume(f.lastSequence)
+}
+
+// SetLagThreshold sets when this follower considers itself lagging
+func (f *StreamClient) SetLagThreshold(threshold replication.LagThreshold) {
+	f.lagLimit = threshold
+}
+
+// SetCompression sets the codec requested for the replication stream.
+// The upstream node falls back to no compression if it doesn't support it.
+func (f *StreamClient) SetCompression(codec gokvs.Compression) {
+	f.compression = codec
+}
+
+// SetFilter makes this a partial replica holding only keys that pass filter.
+// The upstream node sends skip markers for the other commands.
+func (f *StreamClient) SetFilter(filter replication.KeyFilter) {
+	f.filter = filter
+}
+
+// SetIdentity makes the client check that upstream belongs to the same
+// cluster, or join upstream's cluster if this node hasn't joined one yet
+func (f *StreamClient) SetIdentity(identity *datadir.Identity) {
+	f.identity = identity
+}
+
+// SetJoinCluster lets a node without a cluster ID join upstream's cluster
+// although its WAL already holds data. Without it such a node refuses to
+// join: its data may come from another cluster, e.g. after its identity
+// file was lost.
+func (f *StreamClient) SetJoinCluster(join bool) {
+	f.joinCluster = join
+}
+
+// SetChangeHub publishes every applied change to hub. Must be called
+// before ConnectToLeader.
+func (f *StreamClient) SetChangeHub(hub *cdc.Hub) {
+	f.changes = hub
+	hub.Reset(f.lastSequence)
+}
+
+// migrateSequenceFile records the sequence from a legacy sequence file in
+// the WAL and removes the file
+func (f *StreamClient) migrateSequenceFile(seqFile string) {
+	data, err := os.ReadFile(seqFile)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			log.Error().Err(err).Msg("Failed to read sequence file")
 		}
-		f.lastSequence = 0
 		return
 	}
 
 	seq, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse sequence file")
-		f.lastSequence = 0
 		return
 	}
 
-	f.lastSequence = seq
-	log.Info().Msgf("Loaded last sequence: %d", f.lastSequence)
+	if seq > f.lastSequence {
+		if err := f.kvs.MarkSequence(seq); err != nil {
+			log.Error().Err(err).Msg("Failed to migrate sequence file")
+			return
+		}
+		f.lastSequence = seq
+	}
+	os.Remove(seqFile)
+	log.Info().Msgf("Migrated sequence file %s (seq=%d)", seqFile, seq)
 }
 
-// saveLastSequence saves the last applied sequence to disk
-func (f *StreamClient) saveLastSequence() error {
-	data := fmt.Sprintf("%d\n", f.lastSequence)
-	return os.WriteFile(f.seqFile, []byte(data), 0644)
+// Stop closes the replication stream and waits for the applier to finish
+// the command it is applying, and for a running anti-entropy check. Queued commands are kept in the queue file
+// (see SetQueueDir), or else received again after a restart.
+func (f *StreamClient) Stop() {
+	f.stop()
+	f.running.Wait()
+	f.markSkipped()
+	if f.queueFile != nil {
+		f.queueFile.close()
+	}
+	log.Info().Msgf("Replication stopped at seq=%d", f.LastSequence())
 }
 
-// ConnectToLeader connects to leader and receives command stream
+// retryAfter waits before reconnecting. It returns false if Stop was called.
+func (f *StreamClient) retryAfter(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-f.ctx.Done():
+		return false
+	}
+}
+
+// ConnectToLeader connects to leader and receives command stream until Stop
 func (f *StreamClient) ConnectToLeader() {
-	for {
-		log.Info().Msgf("Connecting to leader at %s (last_seq=%d)...", f.leaderAddr, f.lastSequence)
+	// Received commands are applied in the background, after the apply delay
+	f.running.Add(1)
+	go f.runApplier()
+
+	for f.ctx.Err() == nil {
+		lastSeq := f.receivedSequence()
+		log.Info().Msgf("Connecting to leader at %s (last_seq=%d)...", f.leaderAddr, lastSeq)
 
 		conn, err := grpc.Dial(f.leaderAddr, grpc.WithInsecure())
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to dial leader, retrying in 2s...")
-			time.Sleep(2 * time.Second)
+			f.retryAfter(2 * time.Second)
 			continue
 		}
 
 		client := gokvs.NewReplicationClient(conn)
-		stream, err := client.StreamReplication(context.Background(), &gokvs.FollowerInfo{
-			FollowerId:   f.nodeID,
-			FollowerAddr: "",
-			LastSequence: f.lastSequence, // Send last sequence for catch-up
+		stream, err := client.StreamReplicationBatched(f.ctx, &gokvs.FollowerInfo{
+			FollowerId:        f.nodeID,
+			FollowerAddr:      f.addr,
+			LastSequence:      lastSeq, // Send last sequence for catch-up
+			AcceptCompression: []gokvs.Compression{f.compression},
+			Filter:            f.filter.Proto(),
+			ClusterId:         f.clusterID(),
+			NodeUuid:          f.nodeUUID(),
+			StoredCommands:    true,
 		})
 
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to start stream, retrying in 2s...")
 			conn.Close()
-			time.Sleep(2 * time.Second)
+			f.retryAfter(2 * time.Second)
+			continue
+		}
+
+		// Make sure upstream belongs to this node's cluster before applying anything
+		if err := f.checkUpstream(stream); err != nil {
+			log.Error().Err(err).Msg("Refusing upstream, retrying in 2s...")
+			conn.Close()
+			f.retryAfter(2 * time.Second)
 			continue
 		}
 
 		log.Info().Msg("Connected to leader, receiving stream")
 
-		// Receive commands from stream (including catch-up commands)
+		// Heartbeat while the stream is up so the leader keeps its lease
+		f.setLeader(client)
+		hbCtx, stopHeartbeats := context.WithCancel(f.ctx)
+		go f.sendHeartbeats(hbCtx, client)
+
+		// Receive command batches from stream (including catch-up commands)
 		for {
-			cmd, err := stream.Recv()
+			batch, err := stream.Recv()
 			if err != nil {
-				log.Error().Err(err).Msg("Stream error, reconnecting in 2s...")
 				conn.Close()
-				time.Sleep(2 * time.Second)
+				if f.ctx.Err() != nil {
+					break // Stopped
+				}
+				log.Error().Err(err).Msg("Stream error, reconnecting in 2s...")
+				f.retryAfter(2 * time.Second)
 				break // Break inner loop to reconnect
 			}
 
-			// Apply command to local KVS
-			if err := f.applyCommand(cmd); err != nil {
-				log.Error().Err(err).Msgf("Failed to apply command seq=%d", cmd.Sequence)
-			} else {
-				// Update last sequence
-				f.lastSequence = cmd.Sequence
-
-				// Persist sequence every 10 commands (or could be every command)
-				if f.lastSequence%10 == 0 {
-					if err := f.saveLastSequence(); err != nil {
-						log.Error().Err(err).Msg("Failed to save sequence")
-					}
+			cmds, err := replication.DecodeBatch(batch)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to decode batch, reconnecting in 2s...")
+				conn.Close()
+				f.retryAfter(2 * time.Second)
+				break
+			}
+
+			f.enqueue(cmds)
+		}
+
+		stopHeartbeats()
+		f.setLeader(nil)
+	}
+}
+
+// checkUpstream compares the IDs upstream sent in the stream's response
+// header with this node's. A node that hasn't joined a cluster yet joins
+// upstream's, if it has no data or SetJoinCluster allows it.
+func (f *StreamClient) checkUpstream(stream gokvs.Replication_StreamReplicationBatchedClient) error {
+	if f.identity == nil {
+		return nil
+	}
+
+	header, err := stream.Header()
+	if err != nil {
+		return err
+	}
+	clusterID := firstValue(header, replication.ClusterIDHeader)
+	nodeID := firstValue(header, replication.NodeIDHeader)
+
+	if clusterID == "" {
+		// A refused stream ends without a header; report why it was refused
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		return status.Error(codes.FailedPrecondition, "upstream didn't send a cluster ID")
+	}
+	if nodeID == f.identity.NodeID() {
+		return status.Errorf(codes.FailedPrecondition, "--leader-addr %s points to this node", f.leaderAddr)
+	}
+
+	own := f.identity.ClusterID()
+	if own == "" {
+		if (f.kvs.LastSequence() > 0 || f.kvs.Len() > 0) && !f.joinCluster {
+			return status.Errorf(codes.FailedPrecondition, "this node has data but no cluster ID, so it may belong to another cluster: start it with --join-cluster to join cluster %s anyway", clusterID)
+		}
+		if err := f.identity.SetClusterID(clusterID); err != nil {
+			return err
+		}
+		log.Info().Msgf("Joined cluster %s", clusterID)
+		return nil
+	}
+	if own != clusterID {
+		return status.Errorf(codes.FailedPrecondition, "cluster ID mismatch: upstream %s belongs to cluster %s, this node to cluster %s", f.leaderAddr, clusterID, own)
+	}
+	return nil
+}
+
+func (f *StreamClient) clusterID() string {
+	if f.identity == nil {
+		return ""
+	}
+	return f.identity.ClusterID()
+}
+
+func (f *StreamClient) nodeUUID() string {
+	if f.identity == nil {
+		return ""
+	}
+	return f.identity.NodeID()
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// handleCommand applies one streamed command and advances the applied sequence.
+// A command that fails to apply is logged and skipped; its error is returned.
+func (f *StreamClient) handleCommand(cmd *gokvs.ReplicationCommand) error {
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	// Anti-entropy checks waiting for a sequence this command skips past
+	f.runPending(cmd.Sequence)
+
+	// Apply command to local KVS
+	err := f.applyCommand(cmd)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to apply command seq=%d", cmd.Sequence)
+	} else {
+		// Update last sequence and wake up reads waiting for it
+		f.lastSequence = cmd.Sequence
+		f.applied.Advance(cmd.Sequence)
+
+		// Pass it on to followers replicating from us
+		if f.relay != nil {
+			f.relay.Relay(cmd)
+		}
+
+		log.Debug().Msgf("Applied command seq=%d", cmd.Sequence)
+	}
+
+	// Anti-entropy checks waiting for the sequence just applied
+	f.runPending(0)
+	return err
+}
+
+// LastSequence returns the last sequence applied to the local KVS
+func (f *StreamClient) LastSequence() int64 {
+	return f.applied.Load()
+}
+
+// WaitForSequence blocks until seq has been applied locally or ctx is done
+func (f *StreamClient) WaitForSequence(ctx context.Context, seq int64) error {
+	return f.applied.Wait(ctx, seq)
+}
+
+// ReadIndex asks the leader for the sequence a linearizable read must wait for
+func (f *StreamClient) ReadIndex(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	leader := f.leader
+	f.mu.Unlock()
+
+	if leader == nil {
+		return 0, status.Error(codes.Unavailable, "not connected to leader")
+	}
+
+	res, err := leader.ReadIndex(ctx, &gokvs.ReadIndexRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return res.Sequence, nil
+}
+
+// Lag returns how far behind the leader this follower was at the last heartbeat
+func (f *StreamClient) Lag() replication.Lag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lag
+}
+
+// Lagging reports whether the follower is over its lag threshold. A
+// follower that isn't connected to the leader can't tell how far behind it
+// is, so it counts as lagging too. Always false without a threshold.
+func (f *StreamClient) Lagging() bool {
+	if f.lagLimit == (replication.LagThreshold{}) {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leader == nil || f.lagLimit.Exceeded(f.lag)
+}
+
+func (f *StreamClient) setLeader(leader gokvs.ReplicationClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leader = leader
+}
+
+// sendHeartbeats reports the applied sequence to the leader until ctx is cancelled
+func (f *StreamClient) sendHeartbeats(ctx context.Context, client gokvs.ReplicationClient) {
+	ticker := time.NewTicker(replication.DefaultHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			applied := f.LastSequence()
+			res, err := client.Heartbeat(ctx, &gokvs.HeartbeatRequest{
+				FollowerId:      f.nodeID,
+				AppliedSequence: applied,
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Debug().Err(err).Msg("Heartbeat to leader failed")
 				}
+				continue
+			}
 
-				log.Debug().Msgf("Applied command seq=%d", cmd.Sequence)
+			f.mu.Lock()
+			f.lag = replication.Lag{
+				LeaderSequence: res.LeaderSequence,
+				Sequences:      max64(res.LeaderSequence-applied, 0),
+				Time:           time.Duration(res.LagSeconds * float64(time.Second)),
 			}
+			f.mu.Unlock()
 		}
+	}
+}
 
-		// Save sequence on disconnect
-		if err := f.saveLastSequence(); err != nil {
-			log.Error().Err(err).Msg("Failed to save sequence on disconnect")
+// store writes a replicated set, del or namespace command to the local KVS. The leader's
+// bytes are stored unchanged when they carry the command's sequence;
+// commands from older leaders don't and are written anew.
+func (f *StreamClient) store(c command.Cmd, cmd *gokvs.ReplicationCommand) error {
+	if c.Seq == cmd.Sequence {
+		codec, err := replication.CommandCodec(cmd)
+		if err != nil {
+			return err
 		}
+		return f.kvs.WriteEncoded(c, cmd.Command, codec)
 	}
+
+	if c.Cmd == "del" {
+		return f.kvs.DelInAt(c.Namespace, c.Key, cmd.Sequence)
+	}
+	return f.kvs.SetInAt(c.Namespace, c.Key, c.Val, cmd.Sequence)
 }
 
 // applyCommand deserializes and applies a command to the local KVS
 func (f *StreamClient) applyCommand(cmd *gokvs.ReplicationCommand) error {
-	// Deserialize command
-	c, err := command.Deserialize(cmd.Command)
+	// Skip marker: a command filtered out upstream, only the sequence advances
+	if len(cmd.Command) == 0 {
+		return f.skip(cmd.Sequence)
+	}
+
+	// Decompress and deserialize command
+	c, err := replication.DecodeCommand(cmd)
 	if err != nil {
 		return err
 	}
 
+	// Namespaces exist on every replica, whatever its filter
+	switch c.Cmd {
+	case "ns-create", "ns-quota", "ns-drop":
+		if err := f.store(c, cmd); err != nil {
+			return err
+		}
+		log.Info().Msgf("Applied %s of namespace %q (seq=%d)", c.Cmd, c.Namespace, cmd.Sequence)
+		if c.Cmd == "ns-drop" && f.changes != nil {
+			ev := cdc.Event{Namespace: c.Namespace, Op: cdc.OpDropNamespace, Sequence: cmd.Sequence}
+			if cmd.CommitTime != nil {
+				ev.Time = cmd.CommitTime.AsTime()
+			}
+			f.changes.Publish(ev)
+		}
+		return nil
+	}
+
+	// A partial replica refuses keys outside its filter
+	if !f.filter.Match(c.Key) {
+		log.Warn().Msgf("Skipping key %q outside replication filter (seq=%d)", c.Key, cmd.Sequence)
+		return f.skip(cmd.Sequence)
+	}
+
+	// Previous value for the change stream
+	var oldVal string
+	var existed bool
+	if f.changes != nil {
+		if oldVal, existed, err = f.kvs.LookupIn(c.Namespace, c.Key); err != nil {
+			return err
+		}
+	}
+
 	// Apply to local KVS
+	ev := cdc.Event{Namespace: c.Namespace, Key: c.Key, OldValue: oldVal, HasOldValue: existed, Sequence: cmd.Sequence}
 	switch c.Cmd {
 	case "set":
-		return f.kvs.Set(c.Key, c.Val)
+		ev.Op, ev.NewValue = cdc.OpSet, c.Val
 	case "del":
-		return f.kvs.Del(c.Key)
+		ev.Op = cdc.OpDelete
 	default:
 		log.Warn().Msgf("Unknown command type: %s", c.Cmd)
+		return f.skip(cmd.Sequence)
+	}
+	if err := f.store(c, cmd); err != nil {
+		return err
+	}
+
+	// Publish to watchers
+	if f.changes != nil {
+		if cmd.CommitTime != nil {
+			ev.Time = cmd.CommitTime.AsTime()
+		}
+		f.changes.Publish(ev)
+	}
+	return nil
+}
+
+// skip advances past a command this replica doesn't store. The sequence is
+// written to the WAL only every skipMarkInterval sequences, and on Stop, so
+// commands filtered out on a partial replica don't grow its WAL. After a
+// crash, the commands skipped since are received and skipped again.
+func (f *StreamClient) skip(seq int64) error {
+	if seq-f.kvs.LastSequence() < skipMarkInterval {
 		return nil
 	}
+	return f.kvs.MarkSequence(seq)
+}
+
+// markSkipped writes the sequence of commands skipped since the last write
+// to the WAL
+func (f *StreamClient) markSkipped() {
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+	if f.lastSequence <= f.kvs.LastSequence() {
+		return
+	}
+	if err := f.kvs.MarkSequence(f.lastSequence); err != nil {
+		log.Error().Err(err).Msgf("Failed to record skipped commands up to seq=%d", f.lastSequence)
+	}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
 }
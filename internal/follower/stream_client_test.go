@@ -0,0 +1,134 @@
+package follower
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/replication"
+	"go-kvs/pkg/kvs"
+	"go-kvs/pkg/kvs/command"
+)
+
+// replicated builds the command the leader streams for op on key at seq;
+// an empty op is a skip marker
+func replicated(t *testing.T, seq int64, op, key string) *gokvs.ReplicationCommand {
+	t.Helper()
+	if op == "" {
+		return &gokvs.ReplicationCommand{Sequence: seq}
+	}
+	c := command.New(op, key, "value")
+	c.Seq = seq
+	data, err := c.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gokvs.ReplicationCommand{Sequence: seq, Command: data}
+}
+
+// dirSize returns the bytes of all files under dir
+func dirSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestSkippedSequences(t *testing.T) {
+	skips := func(from, to int64) func(t *testing.T) []*gokvs.ReplicationCommand {
+		return func(t *testing.T) []*gokvs.ReplicationCommand {
+			var cmds []*gokvs.ReplicationCommand
+			for seq := from; seq <= to; seq++ {
+				op := ""
+				if seq%2 == 0 {
+					op = "set" // filtered out here rather than upstream
+				}
+				cmds = append(cmds, replicated(t, seq, op, "other:key"))
+			}
+			return cmds
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cmds    func(t *testing.T) []*gokvs.ReplicationCommand
+		stop    bool
+		wantSeq int64 // sequence in the WAL after reopening
+		maxWAL  int64 // largest WAL growth in bytes
+	}{
+		{
+			name:    "skipped, then crashed",
+			cmds:    skips(1, 100),
+			wantSeq: 0,
+			maxWAL:  0,
+		},
+		{
+			name:    "skipped, then stopped",
+			cmds:    skips(1, 100),
+			stop:    true,
+			wantSeq: 100,
+			maxWAL:  200,
+		},
+		{
+			name:    "skipped past the interval",
+			cmds:    skips(1, 3*skipMarkInterval+10),
+			wantSeq: 3 * skipMarkInterval,
+			maxWAL:  3 * 200,
+		},
+		{
+			name: "skipped, then a write",
+			cmds: func(t *testing.T) []*gokvs.ReplicationCommand {
+				return append(skips(1, 100)(t), replicated(t, 101, "set", "keep:a"))
+			},
+			wantSeq: 101,
+			maxWAL:  200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := kvs.New(dir, 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := dirSize(t, dir)
+
+			f := NewStreamClient("partial", "", "", store)
+			f.SetFilter(replication.KeyFilter{Include: []string{"keep:"}})
+			cmds := tt.cmds(t)
+			for _, cmd := range cmds {
+				if err := f.handleCommand(cmd); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := f.LastSequence(), cmds[len(cmds)-1].Sequence; got != want {
+				t.Errorf("applied seq = %d, want %d", got, want)
+			}
+			if tt.stop {
+				f.Stop()
+			}
+			if grown := dirSize(t, dir) - before; grown > tt.maxWAL {
+				t.Errorf("WAL grew by %d bytes, want at most %d", grown, tt.maxWAL)
+			}
+			store.Close()
+
+			reopened, err := kvs.New(dir, 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if got := reopened.LastSequence(); got != tt.wantSeq {
+				t.Errorf("seq after restart = %d, want %d", got, tt.wantSeq)
+			}
+		})
+	}
+}
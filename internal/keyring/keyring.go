@@ -0,0 +1,158 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Keyfile format: one key per line, "<id> <hex key>", with blank lines
+// and lines starting with # ignored. IDs are positive integers; keys are
+// 16, 24 or 32 bytes (AES-128, -192 or -256). The key with the highest ID
+// encrypts new data, the others are kept to decrypt what they encrypted.
+//
+//	# rotated 2024-05-01
+//	1 6f0d...
+//	2 91ab...
+
+// Sealed data layout:
+//
+//	key ID (4) | nonce (12) | ciphertext + GCM tag (16)
+const (
+	idSize    = 4
+	nonceSize = 12
+	Overhead  = idSize + nonceSize + 16 // bytes Seal adds to the plaintext
+)
+
+// ErrUnknownKey is returned for data encrypted with a key the keyfile doesn't have
+var ErrUnknownKey = errors.New("keyring: unknown key ID")
+
+// ErrDecrypt is returned when data fails authentication: the wrong key,
+// or data that was modified
+var ErrDecrypt = errors.New("keyring: decryption failed")
+
+// Keyring holds the keys from a keyfile
+type Keyring struct {
+	path   string
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// Load reads the keyfile at path
+func Load(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &Keyring{path: path, keys: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<id> <hex key>\"", path, line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%s:%d: key ID must be a positive integer", path, line)
+		}
+		if _, dup := k.keys[uint32(id)]; dup {
+			return nil, fmt.Errorf("%s:%d: key ID %d appears twice", path, line, id)
+		}
+		secret, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key is not hex: %w", path, line, err)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[uint32(id)] = aead
+		if uint32(id) > k.active {
+			k.active = uint32(id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return k, nil
+}
+
+// Path returns the keyfile's path
+func (k *Keyring) Path() string {
+	return k.path
+}
+
+// Active returns the ID of the key that encrypts new data
+func (k *Keyring) Active() uint32 {
+	return k.active
+}
+
+// Seal encrypts plaintext with the active key. additional is authenticated
+// but not stored; Open must be given the same bytes.
+func (k *Keyring) Seal(plaintext, additional []byte) []byte {
+	out := make([]byte, idSize+nonceSize, Overhead+len(plaintext))
+	binary.BigEndian.PutUint32(out[:idSize], k.active)
+	if _, err := rand.Read(out[idSize:]); err != nil {
+		panic(err)
+	}
+
+	nonce := out[idSize : idSize+nonceSize]
+	return k.keys[k.active].Seal(out, nonce, plaintext, aad(out[:idSize], additional))
+}
+
+// Open decrypts data sealed by Seal with any key in the keyring
+func (k *Keyring) Open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, ErrDecrypt
+	}
+	id := KeyID(sealed)
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d (not in %s)", ErrUnknownKey, id, k.path)
+	}
+
+	nonce := sealed[idSize : idSize+nonceSize]
+	plaintext, err := aead.Open(nil, nonce, sealed[idSize+nonceSize:], aad(sealed[:idSize], additional))
+	if err != nil {
+		return nil, fmt.Errorf("%w with key %d", ErrDecrypt, id)
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the ID of the key sealed was encrypted with
+func KeyID(sealed []byte) uint32 {
+	if len(sealed) < idSize {
+		return 0
+	}
+	return binary.BigEndian.Uint32(sealed[:idSize])
+}
+
+// aad authenticates the key ID along with the caller's additional data
+func aad(id, additional []byte) []byte {
+	return append(append(make([]byte, 0, len(id)+len(additional)), id...), additional...)
+}
@@ -0,0 +1,128 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	key1 = "000102030405060708090a0b0c0d0e0f"
+	key2 = "101112131415161718191a1b1c1d1e1f101112131415161718191a1b1c1d1e1f"
+)
+
+// loadKeys writes a keyfile with lines and loads it
+func loadKeys(t *testing.T, lines ...string) *Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := loadKeys(t, "# test keys", "1 "+key1, "", "2 "+key2)
+	if k.Active() != 2 {
+		t.Fatalf("active key = %d, want 2", k.Active())
+	}
+
+	for _, plaintext := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("value"), 1000)} {
+		sealed := k.Seal(plaintext, []byte("aad"))
+		if len(sealed) != len(plaintext)+Overhead {
+			t.Errorf("sealed %d bytes into %d, want %d", len(plaintext), len(sealed), len(plaintext)+Overhead)
+		}
+		if KeyID(sealed) != 2 {
+			t.Errorf("sealed with key %d, want 2", KeyID(sealed))
+		}
+		got, err := k.Open(sealed, []byte("aad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("Open = %q, want %q", got, plaintext)
+		}
+	}
+}
+
+func TestOpenRotatedKey(t *testing.T) {
+	old := loadKeys(t, "1 "+key1)
+	sealed := old.Seal([]byte("before rotation"), nil)
+
+	rotated := loadKeys(t, "1 "+key1, "2 "+key2)
+	got, err := rotated.Open(sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "before rotation" {
+		t.Errorf("Open = %q", got)
+	}
+
+	withoutOld := loadKeys(t, "2 "+key2)
+	if _, err := withoutOld.Open(sealed, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open without the old key: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	k := loadKeys(t, "1 "+key1, "2 "+key2)
+	plaintext := []byte("secret value")
+	additional := []byte("offset 42")
+
+	tests := []struct {
+		name       string
+		tamper     func(sealed []byte) []byte
+		additional []byte
+		want       error
+	}{
+		{"nonce", func(s []byte) []byte { s[idSize] ^= 1; return s }, additional, ErrDecrypt},
+		{"ciphertext", func(s []byte) []byte { s[idSize+nonceSize] ^= 1; return s }, additional, ErrDecrypt},
+		{"tag", func(s []byte) []byte { s[len(s)-1] ^= 1; return s }, additional, ErrDecrypt},
+		{"key ID of another key", func(s []byte) []byte { s[idSize-1] = 1; return s }, additional, ErrDecrypt},
+		{"unknown key ID", func(s []byte) []byte { s[idSize-1] = 9; return s }, additional, ErrUnknownKey},
+		{"truncated", func(s []byte) []byte { return s[:len(s)-1] }, additional, ErrDecrypt},
+		{"shorter than the overhead", func(s []byte) []byte { return s[:Overhead-1] }, additional, ErrDecrypt},
+		{"other additional data", func(s []byte) []byte { return s }, []byte("offset 43"), ErrDecrypt},
+		{"no additional data", func(s []byte) []byte { return s }, nil, ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed := tt.tamper(k.Seal(plaintext, additional))
+			if _, err := k.Open(sealed, tt.additional); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyfile string
+	}{
+		{"empty", "# no keys\n"},
+		{"missing key", "1\n"},
+		{"zero ID", "0 " + key1 + "\n"},
+		{"duplicate ID", "1 " + key1 + "\n1 " + key2 + "\n"},
+		{"not hex", "1 xyz\n"},
+		{"bad key size", "1 0001\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tt.keyfile), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Error("loaded an invalid keyfile")
+			}
+		})
+	}
+}
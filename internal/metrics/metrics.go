@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Publish exposes the value returned by fn under name. It is evaluated
+// every time the metrics endpoint is scraped.
+func Publish(name string, fn func() interface{}) {
+	expvar.Publish(name, expvar.Func(fn))
+}
+
+// Serve starts the metrics endpoint at http://addr/debug/vars (blocking)
+func Serve(addr string) {
+	log.Info().Msgf("Serving metrics on http://%s/debug/vars", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Error().Err(err).Msg("Metrics endpoint stopped")
+	}
+}
@@ -0,0 +1,165 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/keyring"
+	"go-kvs/internal/server/wal"
+	"go-kvs/internal/snapshot"
+	"go-kvs/pkg/kvs"
+	"go-kvs/pkg/kvs/command"
+)
+
+// errTargetReached stops the archive scan at the first record past the target
+var errTargetReached = errors.New("target reached")
+
+// Target is the point to recover to. Replay stops before the first write
+// with a sequence above Sequence or committed after Time; zero values
+// don't limit it.
+type Target struct {
+	Sequence int64
+	Time     time.Time
+}
+
+// Result describes a finished recovery
+type Result struct {
+	SnapshotSequence int64     // sequence of the base snapshot, 0 without one
+	Sequence         int64     // sequence of the last write recovered
+	Time             time.Time // commit time of the last write replayed, zero if none was
+	Replayed         int       // sets, deletes and namespace changes replayed from the archive
+	Keys             int       // keys in the recovered data
+}
+
+// Recover builds the state as of target in the empty data directory dir:
+// it loads the base snapshot at snapshotPath, if any, then replays the
+// writes in the archived WAL segments in archiveDir that came after it.
+// keys decrypts encrypted snapshots and segments and encrypts the new WAL.
+//
+// The recovered node doesn't join the snapshot's cluster, since its data
+// is behind what the cluster has already committed.
+func Recover(dir *datadir.Dir, snapshotPath, archiveDir string, target Target, segmentSize int64, keys *keyring.Keyring) (Result, error) {
+	store, err := kvs.New(dir.WALDir(), segmentSize, keys)
+	if err != nil {
+		return Result{}, err
+	}
+	defer store.Close()
+
+	if store.LastSequence() > 0 || store.Len() > 0 {
+		return Result{}, fmt.Errorf("%s already has data", dir.Path())
+	}
+
+	var result Result
+	if snapshotPath != "" {
+		meta, err := snapshot.Verify(snapshotPath, keys)
+		if err != nil {
+			return Result{}, err
+		}
+		if target.Sequence > 0 && target.Sequence < meta.Sequence {
+			return Result{}, fmt.Errorf("the snapshot is at seq=%d, after the target seq=%d", meta.Sequence, target.Sequence)
+		}
+		if !target.Time.IsZero() && target.Time.Before(meta.Created) {
+			return Result{}, fmt.Errorf("the snapshot was taken at %s, after the target time", meta.Created.Format(time.RFC3339))
+		}
+		if _, err := snapshot.Load(snapshotPath, store, keys); err != nil {
+			return Result{}, err
+		}
+		result.SnapshotSequence = meta.Sequence
+		result.Sequence = meta.Sequence
+	}
+
+	if err := replay(store, archiveDir, keys, target, &result); err != nil {
+		return Result{}, err
+	}
+
+	if err := store.MarkSequence(result.Sequence); err != nil {
+		return Result{}, err
+	}
+	result.Keys = store.Len()
+	return result, nil
+}
+
+// replay applies the archived writes after the snapshot up to the target.
+// Records without a sequence (written before replication numbered them, or
+// by a snapshot load) belong with the last sequence before them.
+func replay(store *kvs.Kvs, archiveDir string, keys *keyring.Keyring, target Target, result *Result) error {
+	var (
+		current int64 // sequence the scan is at
+		first   = true
+	)
+
+	err := wal.Scan(archiveDir, keys, func(offset int64, payload []byte) error {
+		cmd, err := command.Deserialize(payload)
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", offset, err)
+		}
+		if cmd.Seq > 0 {
+			if first && cmd.Seq > result.SnapshotSequence+1 {
+				return fmt.Errorf("the archive starts at seq=%d, writes after seq=%d are missing", cmd.Seq, result.SnapshotSequence)
+			}
+			first = false
+			current = cmd.Seq
+		}
+		if current <= result.SnapshotSequence && (current > 0 || result.SnapshotSequence > 0) {
+			return nil // already in the snapshot
+		}
+
+		if target.Sequence > 0 && current > target.Sequence {
+			return errTargetReached
+		}
+		if !target.Time.IsZero() && cmd.Time > target.Time.UnixNano() {
+			return errTargetReached
+		}
+
+		switch cmd.Cmd {
+		case "set", "del", "ns-create", "ns-quota", "ns-drop":
+			if err := apply(store, cmd); err != nil {
+				return fmt.Errorf("offset %d: %w", offset, err)
+			}
+			result.Replayed++
+		}
+		result.Sequence = max64(result.Sequence, current)
+		if cmd.Time > 0 {
+			result.Time = time.Unix(0, cmd.Time)
+		}
+		return nil
+	})
+	if err == errTargetReached {
+		return nil
+	}
+	return err
+}
+
+// apply writes one archived command to store
+func apply(store *kvs.Kvs, cmd command.Cmd) error {
+	switch cmd.Cmd {
+	case "set":
+		return store.SetIn(cmd.Namespace, cmd.Key, cmd.Val)
+	case "del":
+		if _, exists, err := store.LookupIn(cmd.Namespace, cmd.Key); err != nil || !exists {
+			return err
+		}
+		return store.DelIn(cmd.Namespace, cmd.Key)
+	case "ns-create", "ns-quota":
+		quota, err := kvs.ParseQuota(cmd.Val)
+		if err != nil {
+			return err
+		}
+		if cmd.Cmd == "ns-create" {
+			return store.CreateNamespace(cmd.Namespace, quota)
+		}
+		return store.SetQuota(cmd.Namespace, quota)
+	case "ns-drop":
+		return store.DropNamespace(cmd.Namespace)
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
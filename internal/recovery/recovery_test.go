@@ -0,0 +1,132 @@
+package recovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/snapshot"
+	"go-kvs/pkg/kvs"
+)
+
+func TestRecover(t *testing.T) {
+	// The archive holds seq 1..4; times[i] is just after write i+1
+	archive := t.TempDir()
+	source, err := kvs.New(archive, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Time
+	for _, write := range []func() error{
+		func() error { return source.SetAt("a", "1", 1) },
+		func() error { return source.SetAt("b", "1", 2) },
+		func() error { return source.SetAt("a", "2", 3) },
+		func() error { return source.DelAt("b", 4) },
+	} {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, time.Now())
+		time.Sleep(2 * time.Millisecond)
+	}
+	source.Close()
+
+	// A snapshot at seq 2, taken just after it
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot")
+	f, err := os.Create(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw := snapshot.NewWriter(f, snapshot.Meta{Sequence: 2, Created: times[1]}, nil)
+	sw.Add("a", "1")
+	sw.Add("b", "1")
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name         string
+		snapshot     bool
+		target       Target
+		want         map[string]string
+		wantSeq      int64
+		wantReplayed int
+		wantErr      bool
+	}{
+		{name: "whole archive", want: map[string]string{"a": "2"}, wantSeq: 4, wantReplayed: 4},
+		{name: "to a sequence", target: Target{Sequence: 2}, want: map[string]string{"a": "1", "b": "1"}, wantSeq: 2, wantReplayed: 2},
+		{name: "to a time", target: Target{Time: times[2]}, want: map[string]string{"a": "2", "b": "1"}, wantSeq: 3, wantReplayed: 3},
+		{name: "to a time before the first write", target: Target{Time: times[0].Add(-time.Hour)}, want: map[string]string{}, wantSeq: 0},
+		{name: "to the earlier of sequence and time", target: Target{Sequence: 3, Time: times[0]}, want: map[string]string{"a": "1"}, wantSeq: 1, wantReplayed: 1},
+		{name: "snapshot, whole archive", snapshot: true, want: map[string]string{"a": "2"}, wantSeq: 4, wantReplayed: 2},
+		{name: "snapshot, to a sequence", snapshot: true, target: Target{Sequence: 3}, want: map[string]string{"a": "2", "b": "1"}, wantSeq: 3, wantReplayed: 1},
+		{name: "snapshot, to its own sequence", snapshot: true, target: Target{Sequence: 2}, want: map[string]string{"a": "1", "b": "1"}, wantSeq: 2},
+		{name: "snapshot after the target sequence", snapshot: true, target: Target{Sequence: 1}, wantErr: true},
+		{name: "snapshot after the target time", snapshot: true, target: Target{Time: times[0]}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := datadir.Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dir.Close()
+			path := ""
+			if tt.snapshot {
+				path = snapshotPath
+			}
+
+			result, err := Recover(dir, path, archive, tt.target, 0, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if result.Sequence != tt.wantSeq || result.Replayed != tt.wantReplayed || result.Keys != len(tt.want) {
+				t.Errorf("result = %+v, want seq=%d replayed=%d keys=%d", result, tt.wantSeq, tt.wantReplayed, len(tt.want))
+			}
+
+			// The recovered node starts at the target with its data
+			store, err := kvs.New(dir.WALDir(), 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if seq := store.LastSequence(); seq != tt.wantSeq {
+				t.Errorf("recovered WAL seq = %d, want %d", seq, tt.wantSeq)
+			}
+			got := map[string]string{}
+			for _, key := range store.Keys() {
+				got[key], _, _ = store.Lookup(key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recovered %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverIntoData(t *testing.T) {
+	dir, err := datadir.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	store, err := kvs.New(dir.WALDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("a", "v"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if _, err := Recover(dir, "", t.TempDir(), Target{}, 0, nil); err == nil {
+		t.Error("recovered into a data directory that has data")
+	}
+}
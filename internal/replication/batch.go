@@ -0,0 +1,145 @@
+package replication
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	gokvs "go-kvs/api/proto/pb"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultBatchSize is the most commands sent in one batch message
+	DefaultBatchSize = 128
+	// DefaultBatchLinger is how long a partial batch waits for more commands
+	DefaultBatchLinger = 5 * time.Millisecond
+)
+
+// supportedCompression lists the codecs this node can send and receive
+var supportedCompression = map[gokvs.Compression]bool{
+	gokvs.Compression_COMPRESSION_NONE: true,
+	gokvs.Compression_GZIP:             true,
+	gokvs.Compression_SNAPPY:           true,
+	gokvs.Compression_ZSTD:             true,
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// NegotiateCompression picks the first codec in the follower's preference
+// list that this node supports, or no compression
+func NegotiateCompression(accepted []gokvs.Compression) gokvs.Compression {
+	for _, codec := range accepted {
+		if supportedCompression[codec] {
+			return codec
+		}
+	}
+	return gokvs.Compression_COMPRESSION_NONE
+}
+
+// ParseCompression parses a codec name as used on the command line
+func ParseCompression(name string) (gokvs.Compression, error) {
+	switch name {
+	case "none", "":
+		return gokvs.Compression_COMPRESSION_NONE, nil
+	case "gzip":
+		return gokvs.Compression_GZIP, nil
+	case "snappy":
+		return gokvs.Compression_SNAPPY, nil
+	case "zstd":
+		return gokvs.Compression_ZSTD, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q, expected none, gzip, snappy or zstd", name)
+	}
+}
+
+// EncodeBatch packs commands into one batch message compressed with codec
+func EncodeBatch(cmds []*gokvs.ReplicationCommand, codec gokvs.Compression) (*gokvs.ReplicationBatch, error) {
+	data, err := proto.Marshal(&gokvs.ReplicationCommands{Commands: cmds})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := compress(codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &gokvs.ReplicationBatch{
+		Compression: codec,
+		Payload:     payload,
+		Count:       int32(len(cmds)),
+	}
+	if len(cmds) > 0 {
+		batch.LastSequence = cmds[len(cmds)-1].Sequence
+	}
+	return batch, nil
+}
+
+// DecodeBatch unpacks the commands of a batch message
+func DecodeBatch(batch *gokvs.ReplicationBatch) ([]*gokvs.ReplicationCommand, error) {
+	data, err := decompress(batch.Compression, batch.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds gokvs.ReplicationCommands
+	if err := proto.Unmarshal(data, &cmds); err != nil {
+		return nil, err
+	}
+	if len(cmds.Commands) != int(batch.Count) {
+		return nil, fmt.Errorf("batch holds %d commands, header says %d", len(cmds.Commands), batch.Count)
+	}
+	return cmds.Commands, nil
+}
+
+func compress(codec gokvs.Compression, data []byte) ([]byte, error) {
+	switch codec {
+	case gokvs.Compression_COMPRESSION_NONE:
+		return data, nil
+	case gokvs.Compression_SNAPPY:
+		return snappy.Encode(nil, data), nil
+	case gokvs.Compression_ZSTD:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case gokvs.Compression_GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", codec)
+	}
+}
+
+func decompress(codec gokvs.Compression, data []byte) ([]byte, error) {
+	switch codec {
+	case gokvs.Compression_COMPRESSION_NONE:
+		return data, nil
+	case gokvs.Compression_SNAPPY:
+		return snappy.Decode(nil, data)
+	case gokvs.Compression_ZSTD:
+		return zstdDecoder.DecodeAll(data, nil)
+	case gokvs.Compression_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", codec)
+	}
+}
@@ -0,0 +1,100 @@
+package replication
+
+import (
+	"strings"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	cmds := []*gokvs.ReplicationCommand{
+		{Sequence: 1, Command: []byte(strings.Repeat("a", 1000))},
+		{Sequence: 2, Command: []byte("b")},
+		{Sequence: 2, Command: []byte("repair"), Repair: true},
+	}
+
+	tests := []struct {
+		name  string
+		codec gokvs.Compression
+		cmds  []*gokvs.ReplicationCommand
+	}{
+		{name: "uncompressed", codec: gokvs.Compression_COMPRESSION_NONE, cmds: cmds},
+		{name: "gzip", codec: gokvs.Compression_GZIP, cmds: cmds},
+		{name: "snappy", codec: gokvs.Compression_SNAPPY, cmds: cmds},
+		{name: "zstd", codec: gokvs.Compression_ZSTD, cmds: cmds},
+		{name: "empty", codec: gokvs.Compression_GZIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := EncodeBatch(tt.cmds, tt.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(batch.Count) != len(tt.cmds) {
+				t.Errorf("count = %d, want %d", batch.Count, len(tt.cmds))
+			}
+			if len(tt.cmds) > 0 && batch.LastSequence != tt.cmds[len(tt.cmds)-1].Sequence {
+				t.Errorf("last sequence = %d", batch.LastSequence)
+			}
+
+			got, err := DecodeBatch(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.cmds) {
+				t.Fatalf("decoded %d commands, want %d", len(got), len(tt.cmds))
+			}
+			for i := range got {
+				if !proto.Equal(got[i], tt.cmds[i]) {
+					t.Errorf("command %d = %v, want %v", i, got[i], tt.cmds[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBatchErrors(t *testing.T) {
+	valid, err := EncodeBatch([]*gokvs.ReplicationCommand{{Sequence: 1}}, gokvs.Compression_GZIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		batch *gokvs.ReplicationBatch
+	}{
+		{name: "count mismatch", batch: &gokvs.ReplicationBatch{Compression: valid.Compression, Payload: valid.Payload, Count: 2}},
+		{name: "wrong codec", batch: &gokvs.ReplicationBatch{Compression: gokvs.Compression_SNAPPY, Payload: valid.Payload, Count: 1}},
+		{name: "truncated", batch: &gokvs.ReplicationBatch{Compression: valid.Compression, Payload: valid.Payload[:len(valid.Payload)/2], Count: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeBatch(tt.batch); err == nil {
+				t.Error("decoded without an error")
+			}
+		})
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []gokvs.Compression
+		want     gokvs.Compression
+	}{
+		{name: "none offered", want: gokvs.Compression_COMPRESSION_NONE},
+		{name: "first preference", accepted: []gokvs.Compression{gokvs.Compression_ZSTD, gokvs.Compression_GZIP}, want: gokvs.Compression_ZSTD},
+		{name: "unknown codec skipped", accepted: []gokvs.Compression{99, gokvs.Compression_SNAPPY}, want: gokvs.Compression_SNAPPY},
+		{name: "only unknown codecs", accepted: []gokvs.Compression{99}, want: gokvs.Compression_COMPRESSION_NONE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateCompression(tt.accepted); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
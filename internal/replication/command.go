@@ -0,0 +1,77 @@
+package replication
+
+import (
+	"fmt"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs/command"
+)
+
+// A replicated command carries the bytes the leader stored in its WAL,
+// compressed with the leader's codec, so replicas store them unchanged.
+// Its compression field names the codec. Followers from before this
+// ignore the field, so they are only sent stored bytes if they ask for
+// them (FollowerInfo.stored_commands); see PlainCommands.
+
+// CommandCompression returns the compression field for a command stored with codec
+func CommandCompression(codec wal.Codec) gokvs.Compression {
+	switch codec {
+	case wal.CodecSnappy:
+		return gokvs.Compression_SNAPPY
+	case wal.CodecZstd:
+		return gokvs.Compression_ZSTD
+	default:
+		return gokvs.Compression_COMPRESSION_NONE
+	}
+}
+
+// CommandCodec returns the WAL codec a replicated command's bytes are compressed with
+func CommandCodec(cmd *gokvs.ReplicationCommand) (wal.Codec, error) {
+	switch cmd.Compression {
+	case gokvs.Compression_COMPRESSION_NONE:
+		return wal.CodecNone, nil
+	case gokvs.Compression_SNAPPY:
+		return wal.CodecSnappy, nil
+	case gokvs.Compression_ZSTD:
+		return wal.CodecZstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported command compression %s", cmd.Compression)
+	}
+}
+
+// PlainCommands returns cmds with each command's bytes decompressed, for
+// followers that can't read stored bytes. Commands that aren't compressed
+// are returned as they are.
+func PlainCommands(cmds []*gokvs.ReplicationCommand) ([]*gokvs.ReplicationCommand, error) {
+	plain := make([]*gokvs.ReplicationCommand, len(cmds))
+	for i, cmd := range cmds {
+		if cmd.Compression == gokvs.Compression_COMPRESSION_NONE {
+			plain[i] = cmd
+			continue
+		}
+		codec, err := CommandCodec(cmd)
+		if err != nil {
+			return nil, err
+		}
+		data, err := wal.Decompress(codec, cmd.Command)
+		if err != nil {
+			return nil, fmt.Errorf("seq=%d: %w", cmd.Sequence, err)
+		}
+		plain[i] = &gokvs.ReplicationCommand{Command: data, Sequence: cmd.Sequence, CommitTime: cmd.CommitTime, Repair: cmd.Repair}
+	}
+	return plain, nil
+}
+
+// DecodeCommand decompresses and deserializes a replicated command
+func DecodeCommand(cmd *gokvs.ReplicationCommand) (command.Cmd, error) {
+	codec, err := CommandCodec(cmd)
+	if err != nil {
+		return command.Cmd{}, err
+	}
+	data, err := wal.Decompress(codec, cmd.Command)
+	if err != nil {
+		return command.Cmd{}, err
+	}
+	return command.Deserialize(data)
+}
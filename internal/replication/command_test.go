@@ -0,0 +1,82 @@
+package replication
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs/command"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestStoredCommands(t *testing.T) {
+	want := command.New("set", "k", strings.Repeat("v", 1000))
+	data, err := want.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, codec := range []wal.Codec{wal.CodecNone, wal.CodecSnappy, wal.CodecZstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			stored, err := wal.Compress(codec, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := &gokvs.ReplicationCommand{
+				Command:     stored,
+				Sequence:    7,
+				CommitTime:  timestamppb.Now(),
+				Repair:      true,
+				Compression: CommandCompression(codec),
+			}
+
+			if got, err := CommandCodec(cmd); err != nil || got != codec {
+				t.Errorf("codec = %s, %v, want %s", got, err, codec)
+			}
+			got, err := DecodeCommand(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+
+			// Followers that don't read stored commands get the plain bytes
+			plain, err := PlainCommands([]*gokvs.ReplicationCommand{cmd})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := plain[0]
+			if !bytes.Equal(p.Command, data) || p.Compression != gokvs.Compression_COMPRESSION_NONE {
+				t.Errorf("plain command = %q (%s), want %q", p.Command, p.Compression, data)
+			}
+			if p.Sequence != cmd.Sequence || p.CommitTime != cmd.CommitTime || p.Repair != cmd.Repair {
+				t.Errorf("plain command = %+v, want fields of %+v", p, cmd)
+			}
+		})
+	}
+}
+
+func TestStoredCommandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  *gokvs.ReplicationCommand
+	}{
+		{"unsupported compression", &gokvs.ReplicationCommand{Command: []byte("x"), Compression: gokvs.Compression_GZIP}},
+		{"corrupt snappy", &gokvs.ReplicationCommand{Command: []byte("\xff\xff\xff\xff"), Compression: gokvs.Compression_SNAPPY}},
+		{"corrupt zstd", &gokvs.ReplicationCommand{Command: []byte("not zstd"), Compression: gokvs.Compression_ZSTD}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCommand(tt.cmd); err == nil {
+				t.Error("DecodeCommand succeeded")
+			}
+			if _, err := PlainCommands([]*gokvs.ReplicationCommand{tt.cmd}); err == nil {
+				t.Error("PlainCommands succeeded")
+			}
+		})
+	}
+}
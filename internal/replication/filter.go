@@ -0,0 +1,127 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+
+	gokvs "go-kvs/api/proto/pb"
+)
+
+// KeyFilter selects the keys a partial replica holds. A key matches if it
+// has one of the Include prefixes (or Include is empty) and none of the
+// Exclude prefixes. The zero value matches every key.
+type KeyFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// FilterFromProto converts the filter sent by a follower
+func FilterFromProto(filter *gokvs.KeyFilter) KeyFilter {
+	if filter == nil {
+		return KeyFilter{}
+	}
+	return KeyFilter{Include: filter.IncludePrefixes, Exclude: filter.ExcludePrefixes}
+}
+
+// Proto converts the filter for sending upstream, nil when it matches everything
+func (f KeyFilter) Proto() *gokvs.KeyFilter {
+	if f.Empty() {
+		return nil
+	}
+	return &gokvs.KeyFilter{IncludePrefixes: f.Include, ExcludePrefixes: f.Exclude}
+}
+
+// Empty reports whether the filter matches every key
+func (f KeyFilter) Empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match reports whether key passes the filter
+func (f KeyFilter) Match(key string) bool {
+	for _, prefix := range f.Exclude {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, prefix := range f.Include {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether every key with prefix passes the filter, so a
+// listing of the prefix on a partial replica is complete
+func (f KeyFilter) Covers(prefix string) bool {
+	for _, excluded := range f.Exclude {
+		if strings.HasPrefix(prefix, excluded) || strings.HasPrefix(excluded, prefix) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, included := range f.Include {
+		if strings.HasPrefix(prefix, included) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f KeyFilter) String() string {
+	if f.Empty() {
+		return "all keys"
+	}
+	return fmt.Sprintf("include=%v exclude=%v", f.Include, f.Exclude)
+}
+
+// Commands replaces the commands whose key doesn't pass the filter with
+// skip markers (a sequence without a command), so the follower's sequence
+// still advances. A run of skip markers is collapsed into its last one.
+func (f KeyFilter) Commands(cmds []*gokvs.ReplicationCommand) []*gokvs.ReplicationCommand {
+	if f.Empty() {
+		return cmds
+	}
+
+	matches := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		matches[i] = f.matchCommand(cmd)
+	}
+
+	filtered := make([]*gokvs.ReplicationCommand, 0, len(cmds))
+	for i, cmd := range cmds {
+		if matches[i] {
+			filtered = append(filtered, cmd)
+			continue
+		}
+		if cmd.Repair {
+			continue // its sequence was already sent
+		}
+
+		// Only the last skip marker of a run is needed
+		if i+1 < len(cmds) && !matches[i+1] && !cmds[i+1].Repair {
+			continue
+		}
+		filtered = append(filtered, &gokvs.ReplicationCommand{Sequence: cmd.Sequence, CommitTime: cmd.CommitTime})
+	}
+	return filtered
+}
+
+// matchCommand reports whether cmd must be sent in full. Skip markers,
+// namespace commands and commands that can't be decoded are passed on as
+// they are.
+func (f KeyFilter) matchCommand(cmd *gokvs.ReplicationCommand) bool {
+	if len(cmd.Command) == 0 {
+		return true
+	}
+	c, err := DecodeCommand(cmd)
+	if err != nil || (c.Cmd != "set" && c.Cmd != "del") {
+		return true
+	}
+	return f.Match(c.Key)
+}
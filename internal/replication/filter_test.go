@@ -0,0 +1,101 @@
+package replication
+
+import (
+	"reflect"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/pkg/kvs/command"
+)
+
+func TestKeyFilterCovers(t *testing.T) {
+	tenant := KeyFilter{Include: []string{"tenant42:"}}
+	noLogs := KeyFilter{Exclude: []string{"log:"}}
+	both := KeyFilter{Include: []string{"a:", "b:"}, Exclude: []string{"a:tmp:"}}
+
+	tests := []struct {
+		filter KeyFilter
+		prefix string
+		want   bool
+	}{
+		{KeyFilter{}, "", true},
+		{KeyFilter{}, "any", true},
+		{tenant, "", false},
+		{tenant, "tenant4", false},
+		{tenant, "tenant42:", true},
+		{tenant, "tenant42:orders:", true},
+		{tenant, "tenant43:", false},
+		{noLogs, "", false},
+		{noLogs, "lo", false},
+		{noLogs, "log:2024", false},
+		{noLogs, "user:", true},
+		{both, "a:", false},
+		{both, "a:x", true},
+		{both, "a:tmp:1", false},
+		{both, "b:", true},
+		{both, "c:", false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Covers(tt.prefix); got != tt.want {
+			t.Errorf("%s covers %q = %v, want %v", tt.filter, tt.prefix, got, tt.want)
+		}
+		// Any key under a covered prefix must match
+		if tt.want && !tt.filter.Match(tt.prefix+"key") {
+			t.Errorf("%s covers %q but doesn't match %q", tt.filter, tt.prefix, tt.prefix+"key")
+		}
+	}
+}
+
+func TestKeyFilterCommands(t *testing.T) {
+	cmd := func(seq int64, op, key string) *gokvs.ReplicationCommand {
+		c := command.New(op, key, "v")
+		data, err := c.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &gokvs.ReplicationCommand{Sequence: seq, Command: data}
+	}
+	skip := func(seq int64) *gokvs.ReplicationCommand {
+		return &gokvs.ReplicationCommand{Sequence: seq}
+	}
+	repair := func(seq int64, key string) *gokvs.ReplicationCommand {
+		c := cmd(seq, "set", key)
+		c.Repair = true
+		return c
+	}
+
+	filter := KeyFilter{Include: []string{"keep:"}}
+	tests := []struct {
+		name string
+		cmds []*gokvs.ReplicationCommand
+		want []int64 // sequences sent; negative for skip markers
+	}{
+		{"all match", []*gokvs.ReplicationCommand{cmd(1, "set", "keep:a"), cmd(2, "del", "keep:a")}, []int64{1, 2}},
+		{"one skipped", []*gokvs.ReplicationCommand{cmd(1, "set", "keep:a"), cmd(2, "set", "drop:a"), cmd(3, "set", "keep:b")}, []int64{1, -2, 3}},
+		{"run collapsed", []*gokvs.ReplicationCommand{cmd(1, "set", "drop:a"), cmd(2, "set", "drop:b"), cmd(3, "del", "drop:c")}, []int64{-3}},
+		{"skip markers passed on", []*gokvs.ReplicationCommand{skip(1), cmd(2, "set", "keep:a")}, []int64{1, 2}},
+		{"repairs passed on", []*gokvs.ReplicationCommand{cmd(1, "set", "keep:a"), repair(1, "keep:b")}, []int64{1, 1}},
+		{"filtered repairs dropped", []*gokvs.ReplicationCommand{cmd(1, "set", "keep:a"), repair(1, "drop:a")}, []int64{1}},
+		{"run with a filtered repair", []*gokvs.ReplicationCommand{cmd(1, "set", "drop:a"), repair(1, "drop:b"), cmd(2, "set", "drop:c")}, []int64{-1, -2}},
+		{"namespace commands passed on", []*gokvs.ReplicationCommand{cmd(1, "ns-create", ""), cmd(2, "set", "drop:a")}, []int64{1, -2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hadCommand := make(map[int64]bool)
+			for _, c := range tt.cmds {
+				hadCommand[c.Sequence] = len(c.Command) > 0
+			}
+			var got []int64
+			for _, c := range filter.Commands(tt.cmds) {
+				if len(c.Command) == 0 && hadCommand[c.Sequence] {
+					got = append(got, -c.Sequence)
+					continue
+				}
+				got = append(got, c.Sequence)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package replication
+
+// Response header keys with which the upstream node tells a follower its
+// IDs when a replication stream starts
+const (
+	ClusterIDHeader = "cluster-id"
+	NodeIDHeader    = "node-uuid"
+)
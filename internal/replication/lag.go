@@ -0,0 +1,38 @@
+package replication
+
+import "time"
+
+// Lag is how far a node is behind the leader
+type Lag struct {
+	LeaderSequence int64
+	Sequences      int64         // Commands committed on the leader but not applied yet
+	Time           time.Duration // Age of the oldest command not applied yet
+}
+
+// LagThreshold marks a node as lagging once either limit is exceeded.
+// Zero values disable the corresponding check.
+type LagThreshold struct {
+	Sequences int64
+	Time      time.Duration
+}
+
+// Exceeded reports whether lag is over the threshold
+func (t LagThreshold) Exceeded(lag Lag) bool {
+	if t.Sequences > 0 && lag.Sequences > t.Sequences {
+		return true
+	}
+	if t.Time > 0 && lag.Time > t.Time {
+		return true
+	}
+	return false
+}
+
+// ApplyState describes a follower's queue of received but unapplied commands
+type ApplyState struct {
+	Applied   int64
+	Received  int64
+	Queued    int
+	Paused    bool
+	Delay     time.Duration
+	NextApply time.Time // When the oldest queued command is due, zero if none
+}
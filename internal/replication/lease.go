@@ -0,0 +1,36 @@
+package replication
+
+import (
+	"time"
+)
+
+const (
+	DefaultHeartbeatInterval = 500 * time.Millisecond
+	DefaultLeaseDuration     = 2 * time.Second
+)
+
+// Lease decides whether the leader may serve linearizable reads. The leader
+// holds the lease while a majority of the cluster (itself plus every
+// follower in the membership table) heartbeated within the lease duration.
+// A leader cut off from the majority loses the lease.
+type Lease struct {
+	duration   time.Duration
+	membership *Membership
+}
+
+func NewLease(duration time.Duration, membership *Membership) *Lease {
+	if duration <= 0 {
+		duration = DefaultLeaseDuration
+	}
+	return &Lease{
+		duration:   duration,
+		membership: membership,
+	}
+}
+
+// Valid reports whether a quorum heartbeated within the lease duration
+func (l *Lease) Valid() bool {
+	alive, followers := l.membership.AliveSince(time.Now().Add(-l.duration))
+	clusterSize := followers + 1
+	return alive+1 > clusterSize/2 // +1: the leader itself
+}
@@ -0,0 +1,169 @@
+package replication
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultMemberTimeout is how long a disconnected follower stays in the
+// membership table and counts toward the lease quorum
+const DefaultMemberTimeout = 10 * time.Minute
+
+// MemberState describes a follower's connection to this node
+type MemberState string
+
+const (
+	MemberExpected     MemberState = "expected"    // Configured via --follower-addrs, never connected
+	MemberCatchingUp   MemberState = "catching_up" // Stream open, replaying missed commands
+	MemberConnected    MemberState = "connected"   // Receiving the live stream
+	MemberLagging      MemberState = "lagging"     // Connected, but behind the lag threshold
+	MemberDisconnected MemberState = "disconnected"
+)
+
+// Member is one follower as seen by the node it replicates from
+type Member struct {
+	NodeID         string
+	Addr           string // Client-facing address advertised by the follower
+	LastAckSeq     int64  // Applied sequence from the last heartbeat
+	LastHeartbeat  time.Time
+	ConnectedSince time.Time
+	DisconnectedAt time.Time // When the stream last ended
+	State          MemberState
+	Lag            Lag  // Filled in by StreamManager.Members
+	configured     bool // Seeded by --follower-addrs
+}
+
+// Membership is the table of followers known to the leader. Followers
+// join it by opening their replication stream. They are kept for the
+// member timeout after they disconnect, so the lease quorum doesn't shrink
+// when followers become briefly unreachable. After that, a follower seeded
+// by --follower-addrs goes back to expected and any other one is removed.
+type Membership struct {
+	members map[string]*Member // nodeID -> member; expected members are keyed by address
+	timeout time.Duration
+	mu      sync.Mutex
+}
+
+func NewMembership(expectedAddrs []string) *Membership {
+	m := &Membership{members: make(map[string]*Member), timeout: DefaultMemberTimeout}
+	for _, addr := range expectedAddrs {
+		m.members[addr] = &Member{Addr: addr, State: MemberExpected, configured: true}
+	}
+	return m
+}
+
+// SetTimeout sets how long disconnected followers are kept
+func (m *Membership) SetTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if timeout > 0 {
+		m.timeout = timeout
+	}
+}
+
+// Connecting records a follower opening its replication stream
+func (m *Membership) Connecting(nodeID, addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member := m.lookup(nodeID, addr)
+	member.Addr = addr
+	member.ConnectedSince = time.Now()
+	member.State = MemberCatchingUp
+}
+
+// SetState updates the state of a known follower
+func (m *Membership) SetState(nodeID string, state MemberState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if member, exists := m.members[nodeID]; exists {
+		member.State = state
+		if state == MemberDisconnected {
+			member.DisconnectedAt = time.Now()
+		}
+	}
+}
+
+// Heartbeat records the sequence a follower reports as applied. Heartbeats
+// from followers that never opened their stream are ignored, so they can't
+// add members to the lease quorum; ok is false for them.
+func (m *Membership) Heartbeat(nodeID string, appliedSeq int64) (ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member, exists := m.members[nodeID]
+	if !exists {
+		return false
+	}
+	member.LastAckSeq = appliedSeq
+	member.LastHeartbeat = time.Now()
+	return true
+}
+
+// AliveSince returns the number of followers that heartbeated after t and
+// the total number of known followers
+func (m *Membership) AliveSince(t time.Time) (alive, total int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(time.Now())
+	for _, member := range m.members {
+		if member.LastHeartbeat.After(t) {
+			alive++
+		}
+	}
+	return alive, len(m.members)
+}
+
+// List returns a copy of all members ordered by node ID (address for expected ones)
+func (m *Membership) List() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked(time.Now())
+
+	list := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		list = append(list, *member)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NodeID+list[i].Addr < list[j].NodeID+list[j].Addr
+	})
+	return list
+}
+
+// expireLocked removes followers disconnected for longer than the member
+// timeout. A configured follower is replaced by an expected entry for its
+// address, which a node with another ID may adopt. Caller must hold m.mu.
+func (m *Membership) expireLocked(now time.Time) {
+	for key, member := range m.members {
+		if member.State != MemberDisconnected || now.Sub(member.DisconnectedAt) < m.timeout {
+			continue
+		}
+		delete(m.members, key)
+		if member.configured {
+			m.members[member.Addr] = &Member{Addr: member.Addr, State: MemberExpected, configured: true}
+		}
+		log.Info().Msgf("Follower %s disconnected %s ago, removed from the membership table", member.NodeID, m.timeout)
+	}
+}
+
+// lookup returns the member for nodeID, adopting an expected member with
+// the same address or creating a new entry. Caller must hold m.mu.
+func (m *Membership) lookup(nodeID, addr string) *Member {
+	if member, exists := m.members[nodeID]; exists {
+		return member
+	}
+
+	if expected, exists := m.members[addr]; exists && addr != "" && expected.NodeID == "" {
+		delete(m.members, addr)
+		expected.NodeID = nodeID
+		m.members[nodeID] = expected
+		return expected
+	}
+
+	member := &Member{NodeID: nodeID, Addr: addr, State: MemberDisconnected}
+	m.members[nodeID] = member
+	return member
+}
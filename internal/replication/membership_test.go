@@ -0,0 +1,131 @@
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseQuorum(t *testing.T) {
+	const duration = time.Second
+
+	// connect opens a stream for id and heartbeats unless silent
+	connect := func(m *Membership, id, addr string, silent bool) {
+		m.Connecting(id, addr)
+		m.SetState(id, MemberConnected)
+		if !silent {
+			m.Heartbeat(id, 1)
+		}
+	}
+	// disconnect ends id's stream ago and makes its last heartbeat stale
+	disconnect := func(m *Membership, id string, ago time.Duration) {
+		m.SetState(id, MemberDisconnected)
+		m.members[id].DisconnectedAt = time.Now().Add(-ago)
+		m.members[id].LastHeartbeat = time.Now().Add(-ago)
+	}
+
+	tests := []struct {
+		name      string
+		expected  []string
+		setup     func(m *Membership)
+		wantValid bool
+		wantTotal int
+	}{
+		{
+			name:      "no followers",
+			setup:     func(*Membership) {},
+			wantValid: true,
+		},
+		{
+			name: "majority heartbeating",
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+				connect(m, "f2", "a2", true)
+			},
+			wantValid: true,
+			wantTotal: 2,
+		},
+		{
+			name: "heartbeats without a stream are ignored",
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+				for _, id := range []string{"bogus1", "bogus2", "bogus3"} {
+					if m.Heartbeat(id, 1) {
+						t.Errorf("heartbeat from %s was accepted", id)
+					}
+				}
+			},
+			wantValid: true,
+			wantTotal: 1,
+		},
+		{
+			name: "recently disconnected followers still count",
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+				connect(m, "f2", "a2", false)
+				connect(m, "f3", "a3", false)
+				disconnect(m, "f2", time.Minute)
+				disconnect(m, "f3", time.Minute)
+			},
+			wantValid: false,
+			wantTotal: 3,
+		},
+		{
+			name: "followers disconnected past the timeout are removed",
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+				connect(m, "f2", "a2", false)
+				connect(m, "f3", "a3", false)
+				disconnect(m, "f2", time.Hour)
+				disconnect(m, "f3", time.Hour)
+			},
+			wantValid: true,
+			wantTotal: 1,
+		},
+		{
+			name:     "expected followers count before connecting",
+			expected: []string{"a1", "a2"},
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+			},
+			wantValid: true,
+			wantTotal: 2,
+		},
+		{
+			name:     "expired expected followers go back to expected",
+			expected: []string{"a1", "a2", "a3"},
+			setup: func(m *Membership) {
+				connect(m, "f1", "a1", false)
+				connect(m, "f2", "a2", false)
+				disconnect(m, "f2", time.Hour)
+			},
+			wantValid: false,
+			wantTotal: 3,
+		},
+		{
+			name:     "a new node adopts an expired expected address",
+			expected: []string{"a1"},
+			setup: func(m *Membership) {
+				connect(m, "old", "a1", false)
+				disconnect(m, "old", time.Hour)
+				m.List() // expire
+				connect(m, "new", "a1", false)
+			},
+			wantValid: true,
+			wantTotal: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMembership(tt.expected)
+			m.SetTimeout(10 * time.Minute)
+			tt.setup(m)
+
+			if valid := NewLease(duration, m).Valid(); valid != tt.wantValid {
+				t.Errorf("lease valid = %v, want %v", valid, tt.wantValid)
+			}
+			if _, total := m.AliveSince(time.Now().Add(-duration)); total != tt.wantTotal {
+				t.Errorf("members = %d, want %d: %+v", total, tt.wantTotal, m.List())
+			}
+		})
+	}
+}
@@ -1,18 +1,23 @@
 package replication
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	gokvs "go-kvs/api/proto/pb"
 )
 
 const DefaultRecentLogSize = 10000
 
-// RecentLog keeps recent commands in memory for catch-up
+// RecentLog keeps recent commands in memory for catch-up.
+// Sequences are increasing but not necessarily contiguous: a relaying
+// follower only holds what it received from upstream.
 type RecentLog struct {
 	commands []*gokvs.ReplicationCommand
+	added    []time.Time // When each command was added, for lag in seconds
 	capacity int
-	startSeq int64 // Sequence number of first command in buffer
+	floorSeq int64 // Highest sequence no longer available (evicted or before start)
 	mu       sync.RWMutex
 }
 
@@ -22,8 +27,9 @@ func NewRecentLog(capacity int) *RecentLog {
 	}
 	return &RecentLog{
 		commands: make([]*gokvs.ReplicationCommand, 0, capacity),
+		added:    make([]time.Time, 0, capacity),
 		capacity: capacity,
-		startSeq: 1,
+		floorSeq: 0,
 	}
 }
 
@@ -33,42 +39,44 @@ func (r *RecentLog) Add(cmd *gokvs.ReplicationCommand) {
 	defer r.mu.Unlock()
 
 	r.commands = append(r.commands, cmd)
+	r.added = append(r.added, time.Now())
 
 	// If buffer is full, remove oldest command
 	if len(r.commands) > r.capacity {
+		r.floorSeq = r.commands[0].Sequence
 		r.commands = r.commands[1:]
-		r.startSeq++
+		r.added = r.added[1:]
 	}
 }
 
+// Reset drops all buffered commands and marks everything up to seq as
+// unavailable. Used when a node resumes at seq after a restart.
+func (r *RecentLog) Reset(seq int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = r.commands[:0]
+	r.added = r.added[:0]
+	r.floorSeq = seq
+}
+
 // GetSince returns commands since the given sequence number
 // Returns nil if requested sequence is too old (already evicted)
 func (r *RecentLog) GetSince(lastSeq int64) ([]*gokvs.ReplicationCommand, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// If asking for sequence 0, return all commands
-	if lastSeq == 0 {
-		result := make([]*gokvs.ReplicationCommand, len(r.commands))
-		copy(result, r.commands)
-		return result, true
-	}
-
 	// Check if requested sequence is too old
-	if lastSeq < r.startSeq {
+	if lastSeq < r.floorSeq {
 		return nil, false // Too old, can't catch up from memory
 	}
 
-	// Calculate offset in buffer
-	offset := lastSeq - r.startSeq + 1
-
-	// If offset is beyond current buffer, follower is already caught up
-	if offset >= int64(len(r.commands)) {
-		return []*gokvs.ReplicationCommand{}, true
-	}
+	// Find the first command after lastSeq; if there is none, follower is already caught up
+	offset := sort.Search(len(r.commands), func(i int) bool {
+		return r.commands[i].Sequence > lastSeq
+	})
 
 	// Return commands from offset onwards
-	result := make([]*gokvs.ReplicationCommand, len(r.commands)-int(offset))
+	result := make([]*gokvs.ReplicationCommand, len(r.commands)-offset)
 	copy(result, r.commands[offset:])
 	return result, true
 }
@@ -79,7 +87,7 @@ func (r *RecentLog) GetLatestSequence() int64 {
 	defer r.mu.RUnlock()
 
 	if len(r.commands) == 0 {
-		return 0
+		return r.floorSeq
 	}
 	return r.commands[len(r.commands)-1].Sequence
 }
@@ -88,5 +96,26 @@ func (r *RecentLog) GetLatestSequence() int64 {
 func (r *RecentLog) GetStartSequence() int64 {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	return r.startSeq
+
+	if len(r.commands) == 0 {
+		return r.floorSeq + 1
+	}
+	return r.commands[0].Sequence
+}
+
+// OldestAfter returns when the first command after lastSeq was added, i.e.
+// how long a node that applied up to lastSeq has been behind. ok is false
+// if there is no such command in the buffer (the node is caught up).
+// If the command was already evicted, the oldest buffered time is returned.
+func (r *RecentLog) OldestAfter(lastSeq int64) (added time.Time, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := sort.Search(len(r.commands), func(i int) bool {
+		return r.commands[i].Sequence > lastSeq
+	})
+	if i == len(r.commands) {
+		return time.Time{}, false
+	}
+	return r.added[i], true
 }
@@ -0,0 +1,58 @@
+package replication
+
+import (
+	"reflect"
+	"testing"
+
+	gokvs "go-kvs/api/proto/pb"
+)
+
+func TestRecentLogGetSince(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int     // 0 = the default
+		reset    int64   // sequence the log resumes at, 0 = none
+		added    []int64 // sequences added, in order
+		since    int64
+		want     []int64
+		wantOK   bool
+		latest   int64
+	}{
+		{name: "caught up", added: []int64{1, 2, 3}, since: 3, want: []int64{}, wantOK: true, latest: 3},
+		{name: "behind", added: []int64{1, 2, 3}, since: 1, want: []int64{2, 3}, wantOK: true, latest: 3},
+		{name: "gaps from upstream", added: []int64{2, 5, 9}, since: 4, want: []int64{5, 9}, wantOK: true, latest: 9},
+		{name: "repair after its sequence", added: []int64{1, 2, 2}, since: 1, want: []int64{2, 2}, wantOK: true, latest: 2},
+		{name: "evicted", added: []int64{1, 2, 3, 4}, capacity: 2, since: 1, wantOK: false, latest: 4},
+		{name: "oldest still buffered", added: []int64{1, 2, 3, 4}, capacity: 2, since: 2, want: []int64{3, 4}, wantOK: true, latest: 4},
+		{name: "before a restart", reset: 10, added: []int64{11}, since: 9, wantOK: false, latest: 11},
+		{name: "at a restart", reset: 10, added: []int64{11}, since: 10, want: []int64{11}, wantOK: true, latest: 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := NewRecentLog(tt.capacity)
+			if tt.reset > 0 {
+				log.Reset(tt.reset)
+			}
+			for _, seq := range tt.added {
+				log.Add(&gokvs.ReplicationCommand{Sequence: seq})
+			}
+
+			cmds, ok := log.GetSince(tt.since)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok {
+				got := []int64{}
+				for _, cmd := range cmds {
+					got = append(got, cmd.Sequence)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+			if latest := log.GetLatestSequence(); latest != tt.latest {
+				t.Errorf("latest = %d, want %d", latest, tt.latest)
+			}
+		})
+	}
+}
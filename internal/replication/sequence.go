@@ -0,0 +1,60 @@
+package replication
+
+import (
+	"context"
+	"sync"
+)
+
+// SequenceWaiter tracks the last applied replication sequence on a node and
+// lets readers block until it reaches a given value.
+type SequenceWaiter struct {
+	seq     int64
+	advance chan struct{} // closed and replaced every time seq moves forward
+	mu      sync.Mutex
+}
+
+func NewSequenceWaiter(seq int64) *SequenceWaiter {
+	return &SequenceWaiter{
+		seq:     seq,
+		advance: make(chan struct{}),
+	}
+}
+
+// Load returns the last applied sequence
+func (w *SequenceWaiter) Load() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// Advance records seq as applied and wakes up waiting readers.
+// Sequences lower than the current one are ignored.
+func (w *SequenceWaiter) Advance(seq int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.seq {
+		return
+	}
+	w.seq = seq
+	close(w.advance)
+	w.advance = make(chan struct{})
+}
+
+// Wait blocks until the applied sequence is at least seq or ctx is done
+func (w *SequenceWaiter) Wait(ctx context.Context, seq int64) error {
+	for {
+		w.mu.Lock()
+		current, advance := w.seq, w.advance
+		w.mu.Unlock()
+
+		if current >= seq {
+			return nil
+		}
+
+		select {
+		case <-advance:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package replication_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pb "go-kvs/api/proto/pb"
+	"go-kvs/internal/replication"
+	"go-kvs/internal/server"
+	"go-kvs/pkg/kvs/command"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	benchCommands  = 5000
+	benchValueSize = 1024
+)
+
+// BenchmarkBatchedStream measures a follower replaying benchCommands set
+// commands from the leader's catch-up log, over the unbatched stream and
+// the batched stream with each codec. Besides time per replay, it reports
+// the messages and bytes on the wire per replay:
+//
+//	go test ./internal/replication -run '^$' -bench BatchedStream
+func BenchmarkBatchedStream(b *testing.B) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	cmds, err := generateCommands(benchCommands, benchValueSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("unbatched", func(b *testing.B) {
+		benchStream(b, cmds, func(ctx context.Context, client pb.ReplicationClient) (int, int, error) {
+			stream, err := client.StreamReplication(ctx, &pb.FollowerInfo{FollowerId: "bench"})
+			if err != nil {
+				return 0, 0, err
+			}
+			var wire int
+			for received := 0; received < len(cmds); received++ {
+				cmd, err := stream.Recv()
+				if err != nil {
+					return 0, 0, err
+				}
+				wire += proto.Size(cmd)
+			}
+			return len(cmds), wire, nil
+		})
+	})
+
+	for _, name := range []string{"none", "gzip", "snappy", "zstd"} {
+		codec, err := replication.ParseCompression(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("batched/"+name, func(b *testing.B) {
+			benchStream(b, cmds, func(ctx context.Context, client pb.ReplicationClient) (int, int, error) {
+				stream, err := client.StreamReplicationBatched(ctx, &pb.FollowerInfo{
+					FollowerId:        "bench",
+					AcceptCompression: []pb.Compression{codec},
+				})
+				if err != nil {
+					return 0, 0, err
+				}
+				var messages, wire int
+				for received := 0; received < len(cmds); {
+					batch, err := stream.Recv()
+					if err != nil {
+						return 0, 0, err
+					}
+					decoded, err := replication.DecodeBatch(batch)
+					if err != nil {
+						return 0, 0, err
+					}
+					received += len(decoded)
+					messages++
+					wire += proto.Size(batch)
+				}
+				return messages, wire, nil
+			})
+		})
+	}
+}
+
+// benchStream serves cmds from a leader stream server and times replay,
+// which returns the messages and bytes it received
+func benchStream(b *testing.B, cmds [][]byte, replay func(context.Context, pb.ReplicationClient) (int, int, error)) {
+	streamMgr := replication.NewStreamManager(replication.DefaultLeaseDuration, nil)
+	defer streamMgr.Close()
+	for _, cmd := range cmds {
+		streamMgr.Broadcast(cmd, pb.Compression_COMPRESSION_NONE, time.Now())
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	streamServer := server.NewLeaderStreamServer(streamMgr, nil)
+	streamServer.SetBatching(replication.DefaultBatchSize, replication.DefaultBatchLinger)
+	pb.RegisterReplicationServer(grpcServer, streamServer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewReplicationClient(conn)
+
+	var messages, wire int
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		m, w, err := replay(ctx, client)
+		cancel()
+		if err != nil {
+			b.Fatal(err)
+		}
+		messages += m
+		wire += w
+	}
+	b.StopTimer()
+	elapsed := time.Since(start)
+
+	b.ReportMetric(float64(messages)/float64(b.N), "msgs/op")
+	b.ReportMetric(float64(wire)/float64(b.N)/(1<<20), "wireMB/op")
+	b.ReportMetric(float64(len(cmds))*float64(b.N)/elapsed.Seconds(), "cmds/s")
+}
+
+// generateCommands builds serialized set commands with JSON-like values,
+// which compress about as well as typical documents
+func generateCommands(count, valueSize int) ([][]byte, error) {
+	words := []string{"id", "name", "email", "status", "active", "created_at", "tags", "region", "score", "owner"}
+	rnd := rand.New(rand.NewSource(1))
+
+	cmds := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		var val strings.Builder
+		val.WriteString("{")
+		for val.Len() < valueSize {
+			fmt.Fprintf(&val, "%q:%d,", words[rnd.Intn(len(words))], rnd.Intn(100000))
+		}
+		val.WriteString("}")
+
+		cmd := command.New("set", fmt.Sprintf("user:%08d", i), val.String())
+		cmdBytes, err := cmd.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmdBytes)
+	}
+	return cmds, nil
+}
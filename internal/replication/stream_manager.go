@@ -1,11 +1,16 @@
 package replication
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	gokvs "go-kvs/api/proto/pb"
 
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type StreamManager struct {
@@ -13,21 +18,47 @@ type StreamManager struct {
 	recentLog *RecentLog
 	mu        sync.RWMutex
 	sequence  int64
+	applied   *SequenceWaiter
+	members   *Membership
+	lease     *Lease
+	lagLimit  LagThreshold
+	closed    bool
 }
 
-func NewStreamManager() *StreamManager {
+// NewStreamManager creates the stream manager for a node serving replication.
+// followerAddrs seeds the membership table with followers expected to join.
+func NewStreamManager(leaseDuration time.Duration, followerAddrs []string) *StreamManager {
+	members := NewMembership(followerAddrs)
 	return &StreamManager{
 		streams:   make(map[string]chan *gokvs.ReplicationCommand),
 		recentLog: NewRecentLog(DefaultRecentLogSize),
 		sequence:  0,
+		applied:   NewSequenceWaiter(0),
+		members:   members,
+		lease:     NewLease(leaseDuration, members),
 	}
 }
 
+// Connecting records a follower that opened its stream and is catching up
+func (sm *StreamManager) Connecting(followerID, followerAddr string) {
+	sm.members.Connecting(followerID, followerAddr)
+}
+
+// Disconnected records that a follower's stream has ended
+func (sm *StreamManager) Disconnected(followerID string) {
+	sm.members.SetState(followerID, MemberDisconnected)
+}
+
 // Register a new follower stream
 func (sm *StreamManager) Register(followerID string, ch chan *gokvs.ReplicationCommand) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	if sm.closed {
+		close(ch) // shutting down: end the stream right away
+		return
+	}
 	sm.streams[followerID] = ch
+	sm.members.SetState(followerID, MemberConnected)
 	log.Info().Msgf("Follower %s registered, total followers: %d", followerID, len(sm.streams))
 }
 
@@ -42,20 +73,84 @@ func (sm *StreamManager) Unregister(followerID string) {
 	}
 }
 
-// Broadcast command to all connected followers
-func (sm *StreamManager) Broadcast(cmdBytes []byte) {
+// Close ends every follower stream after the commands already queued for
+// it, e.g. on shutdown. Streams registered later end right away.
+func (sm *StreamManager) Close() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.closed = true
+	for followerID, ch := range sm.streams {
+		close(ch)
+		delete(sm.streams, followerID)
+	}
+	log.Info().Msg("Closed all follower streams")
+}
+
+// NextSequence returns the sequence the next Broadcast will assign. Callers
+// must serialize writes so no Broadcast happens in between.
+func (sm *StreamManager) NextSequence() int64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.sequence + 1
+}
+
+// Broadcast command to all connected followers and return the sequence
+// assigned to it. cmdBytes are compressed with compression, as stored in
+// the leader's WAL.
+func (sm *StreamManager) Broadcast(cmdBytes []byte, compression gokvs.Compression, commitTime time.Time) int64 {
 	sm.mu.Lock()
 	sm.sequence++
 	seq := sm.sequence
 	sm.mu.Unlock()
 
 	cmd := &gokvs.ReplicationCommand{
-		Command:  cmdBytes,
-		Sequence: seq,
+		Command:     cmdBytes,
+		Sequence:    seq,
+		CommitTime:  timestamppb.New(commitTime),
+		Compression: compression,
+	}
+
+	sm.publish(cmd)
+	return seq
+}
+
+// Relay forwards a command received from upstream, keeping its original
+// sequence. Used by followers that serve their own replication stream.
+func (sm *StreamManager) Relay(cmd *gokvs.ReplicationCommand) {
+	sm.mu.Lock()
+	if cmd.Repair && cmd.Sequence == sm.sequence {
+		// An anti-entropy repair at the last relayed sequence
+		sm.mu.Unlock()
+		sm.publish(cmd)
+		return
 	}
+	if cmd.Sequence <= sm.sequence {
+		sm.mu.Unlock()
+		return // already relayed (upstream replayed it during catch-up)
+	}
+	sm.sequence = cmd.Sequence
+	sm.mu.Unlock()
+
+	sm.publish(cmd)
+}
+
+// Resume continues numbering after seq, e.g. when a node restarts with
+// state up to seq. Commands up to seq can't be served for catch-up.
+func (sm *StreamManager) Resume(seq int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.sequence = seq
+	sm.recentLog.Reset(seq)
+	sm.applied.Advance(seq)
+}
+
+// publish stores cmd for catch-up and sends it to every follower stream
+func (sm *StreamManager) publish(cmd *gokvs.ReplicationCommand) {
+	seq := cmd.Sequence
 
 	// Add to recent log for catch-up
 	sm.recentLog.Add(cmd)
+	sm.applied.Advance(seq)
 
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -84,3 +179,75 @@ func (sm *StreamManager) GetFollowerCount() int {
 	defer sm.mu.RUnlock()
 	return len(sm.streams)
 }
+
+// LastSequence returns the sequence of the most recent broadcast command
+func (sm *StreamManager) LastSequence() int64 {
+	return sm.applied.Load()
+}
+
+// WaitForSequence blocks until seq has been broadcast or ctx is done
+func (sm *StreamManager) WaitForSequence(ctx context.Context, seq int64) error {
+	return sm.applied.Wait(ctx, seq)
+}
+
+// Heartbeat records a follower heartbeat and returns how far behind the follower is
+func (sm *StreamManager) Heartbeat(followerID string, appliedSeq int64) Lag {
+	if !sm.members.Heartbeat(followerID, appliedSeq) {
+		log.Debug().Msgf("Ignoring heartbeat from %s, which has no replication stream", followerID)
+	}
+
+	lag := sm.LagOf(appliedSeq)
+	if sm.lagLimit.Exceeded(lag) {
+		log.Debug().Msgf("Follower %s lagging: %d commands, %s behind", followerID, lag.Sequences, lag.Time)
+	}
+	return lag
+}
+
+// SetMemberTimeout sets how long disconnected followers count toward the lease quorum
+func (sm *StreamManager) SetMemberTimeout(timeout time.Duration) {
+	sm.members.SetTimeout(timeout)
+}
+
+// SetLagThreshold sets when a connected follower is reported as lagging
+func (sm *StreamManager) SetLagThreshold(threshold LagThreshold) {
+	sm.lagLimit = threshold
+}
+
+// LagOf returns how far behind a node that applied up to appliedSeq is
+func (sm *StreamManager) LagOf(appliedSeq int64) Lag {
+	leaderSeq := sm.LastSequence()
+	lag := Lag{LeaderSequence: leaderSeq}
+	if appliedSeq >= leaderSeq {
+		return lag
+	}
+
+	lag.Sequences = leaderSeq - appliedSeq
+	if added, ok := sm.recentLog.OldestAfter(appliedSeq); ok {
+		lag.Time = time.Since(added)
+	}
+	return lag
+}
+
+// Members returns the membership table with each follower's current lag
+func (sm *StreamManager) Members() []Member {
+	members := sm.members.List()
+	for i := range members {
+		if members[i].State == MemberExpected {
+			continue
+		}
+		members[i].Lag = sm.LagOf(members[i].LastAckSeq)
+		if members[i].State == MemberConnected && sm.lagLimit.Exceeded(members[i].Lag) {
+			members[i].State = MemberLagging
+		}
+	}
+	return members
+}
+
+// ReadIndex returns the latest sequence if the leader still holds its lease.
+// Reads that wait for this sequence are linearizable.
+func (sm *StreamManager) ReadIndex(ctx context.Context) (int64, error) {
+	if !sm.lease.Valid() {
+		return 0, status.Error(codes.Unavailable, "leadership not confirmed: no quorum heartbeat within lease")
+	}
+	return sm.LastSequence(), nil
+}
@@ -0,0 +1,88 @@
+package replication
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	gokvs "go-kvs/api/proto/pb"
+)
+
+func TestStreamManagerRelay(t *testing.T) {
+	repair := &gokvs.ReplicationCommand{Sequence: 2, Repair: true}
+
+	tests := []struct {
+		name string
+		cmds []*gokvs.ReplicationCommand
+		want []int64 // sequences kept for catch-up
+	}{
+		{"in order", []*gokvs.ReplicationCommand{{Sequence: 1}, {Sequence: 2}}, []int64{1, 2}},
+		{"replayed by upstream", []*gokvs.ReplicationCommand{{Sequence: 1}, {Sequence: 2}, {Sequence: 1}, {Sequence: 2}, {Sequence: 3}}, []int64{1, 2, 3}},
+		{"repair at the last sequence", []*gokvs.ReplicationCommand{{Sequence: 1}, {Sequence: 2}, repair}, []int64{1, 2, 2}},
+		{"stale repair", []*gokvs.ReplicationCommand{{Sequence: 1}, {Sequence: 2}, {Sequence: 3}, repair}, []int64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewStreamManager(0, nil)
+			defer sm.Close()
+			for _, cmd := range tt.cmds {
+				sm.Relay(cmd)
+			}
+
+			cmds, _ := sm.GetMissedCommands(0)
+			var got []int64
+			for _, cmd := range cmds {
+				got = append(got, cmd.Sequence)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relayed %v, want %v", got, tt.want)
+			}
+			if seq := sm.LastSequence(); seq != tt.want[len(tt.want)-1] {
+				t.Errorf("last sequence = %d, want %d", seq, tt.want[len(tt.want)-1])
+			}
+		})
+	}
+}
+
+func TestStreamManagerLag(t *testing.T) {
+	tests := []struct {
+		name      string
+		committed int64
+		applied   int64 // reported by the follower's heartbeat
+		threshold LagThreshold
+		wantLag   int64
+		wantState MemberState
+	}{
+		{name: "caught up", committed: 5, applied: 5, threshold: LagThreshold{Sequences: 1}, wantState: MemberConnected},
+		{name: "within the threshold", committed: 5, applied: 3, threshold: LagThreshold{Sequences: 2}, wantLag: 2, wantState: MemberConnected},
+		{name: "over the sequence threshold", committed: 5, applied: 2, threshold: LagThreshold{Sequences: 2}, wantLag: 3, wantState: MemberLagging},
+		{name: "over the time threshold", committed: 5, applied: 4, threshold: LagThreshold{Time: time.Nanosecond}, wantLag: 1, wantState: MemberLagging},
+		{name: "no threshold", committed: 5, applied: 0, wantLag: 5, wantState: MemberConnected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewStreamManager(0, nil)
+			defer sm.Close()
+			sm.SetLagThreshold(tt.threshold)
+			for seq := int64(1); seq <= tt.committed; seq++ {
+				sm.Relay(&gokvs.ReplicationCommand{Sequence: seq})
+			}
+			sm.Connecting("f1", "f1:1")
+			sm.members.SetState("f1", MemberConnected)
+			time.Sleep(time.Millisecond) // let the unapplied commands age
+
+			lag := sm.Heartbeat("f1", tt.applied)
+			if lag.LeaderSequence != tt.committed || lag.Sequences != tt.wantLag {
+				t.Errorf("lag = %+v, want %d of %d", lag, tt.wantLag, tt.committed)
+			}
+			if (lag.Time > 0) != (tt.wantLag > 0) {
+				t.Errorf("lag time = %s with %d commands behind", lag.Time, tt.wantLag)
+			}
+
+			members := sm.Members()
+			if len(members) != 1 || members[0].State != tt.wantState || members[0].Lag.Sequences != tt.wantLag {
+				t.Errorf("members = %+v, want f1 %s %d behind", members, tt.wantState, tt.wantLag)
+			}
+		})
+	}
+}
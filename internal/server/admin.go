@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/antientropy"
+	"go-kvs/internal/config"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/keyring"
+	"go-kvs/internal/replication"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FollowerStatus reports a follower's replication progress.
+// Implemented by follower.StreamClient.
+type FollowerStatus interface {
+	LastSequence() int64
+	Lag() replication.Lag
+	Lagging() bool
+}
+
+// Verifier compares the local store with the upstream node.
+// Implemented by follower.StreamClient.
+type Verifier interface {
+	Verify(ctx context.Context, ns string, depth int, repair bool) (*antientropy.Report, error)
+}
+
+// ApplyController pauses and resumes applying replicated commands.
+// Implemented by follower.StreamClient.
+type ApplyController interface {
+	PauseApply()
+	ResumeApply()
+	ApplyUpTo(ctx context.Context, seq int64) error
+	ApplyState() replication.ApplyState
+}
+
+// AdminServer serves cluster administration RPCs
+type AdminServer struct {
+	nodeID      string
+	addr        string
+	isLeader    bool
+	leaderAddr  string
+	proxyWrites bool
+	streamMgr   *replication.StreamManager
+	follower    FollowerStatus
+	verifier    Verifier
+	applier     ApplyController
+	leader      *leaderForwarder
+	identity    *datadir.Identity // reported in ClusterStatus, may be nil
+	snapshots   SnapshotSource    // serves Snapshot, may be nil
+	keys        *keyring.Keyring  // encrypts served snapshots, nil = plaintext
+	namespaces  NamespaceManager  // serves the namespace RPCs, may be nil
+	filter      replication.KeyFilter
+	go_kvs.UnimplementedAdminServer
+}
+
+func NewAdminServer(streamMgr *replication.StreamManager, follower FollowerStatus, cfg *config.ServerConfig) *AdminServer {
+	a := &AdminServer{
+		nodeID:      cfg.NodeID,
+		addr:        cfg.AdvertiseAddr,
+		isLeader:    cfg.IsLeader,
+		leaderAddr:  cfg.LeaderAddr,
+		proxyWrites: cfg.ProxyWrites,
+		streamMgr:   streamMgr,
+		follower:    follower,
+		filter:      replication.KeyFilter{Include: cfg.IncludePrefixes, Exclude: cfg.ExcludePrefixes},
+	}
+	if !a.isLeader {
+		a.leader = newLeaderForwarder(cfg.LeaderAddr)
+	}
+	if verifier, ok := follower.(Verifier); ok {
+		a.verifier = verifier
+	}
+	if applier, ok := follower.(ApplyController); ok {
+		a.applier = applier
+	}
+	return a
+}
+
+// SetIdentity sets the IDs reported by ClusterStatus
+func (a *AdminServer) SetIdentity(identity *datadir.Identity) {
+	a.identity = identity
+}
+
+// ClusterStatus returns the leader's membership table. Followers forward
+// the request, so it can be sent to any node.
+func (a *AdminServer) ClusterStatus(ctx context.Context, request *go_kvs.ClusterStatusRequest) (*go_kvs.ClusterStatusResponse, error) {
+	if !a.isLeader {
+		return a.leader.ClusterStatus(ctx, request)
+	}
+
+	res := &go_kvs.ClusterStatusResponse{
+		LeaderId:       a.nodeID,
+		LeaderAddr:     a.addr,
+		LeaderSequence: a.streamMgr.LastSequence(),
+		Members:        a.memberStatuses(),
+	}
+	if a.identity != nil {
+		res.ClusterId = a.identity.ClusterID()
+	}
+	return res, nil
+}
+
+// ReplicationStatus returns this node's own view of replication lag
+func (a *AdminServer) ReplicationStatus(ctx context.Context, request *go_kvs.ReplicationStatusRequest) (*go_kvs.ReplicationStatusResponse, error) {
+	return a.replicationStatus(), nil
+}
+
+// replicationStatus is shared by the ReplicationStatus RPC and the metrics endpoint
+func (a *AdminServer) replicationStatus() *go_kvs.ReplicationStatusResponse {
+	res := &go_kvs.ReplicationStatusResponse{
+		NodeId:   a.nodeID,
+		IsLeader: a.isLeader,
+	}
+
+	if a.isLeader {
+		res.AppliedSequence = a.streamMgr.LastSequence()
+		res.LeaderSequence = res.AppliedSequence
+		res.Followers = a.memberStatuses()
+		return res
+	}
+
+	lag := a.follower.Lag()
+	res.AppliedSequence = a.follower.LastSequence()
+	res.LeaderAddr = a.leaderAddr
+	res.LeaderSequence = lag.LeaderSequence
+	res.LagSequences = lag.Sequences
+	res.LagSeconds = lag.Time.Seconds()
+	res.Lagging = a.follower.Lagging()
+	return res
+}
+
+// Verify compares one namespace of this follower's data with its upstream
+// node and reports the differing keys without repairing them
+func (a *AdminServer) Verify(ctx context.Context, request *go_kvs.VerifyRequest) (*go_kvs.VerifyResponse, error) {
+	if a.verifier == nil {
+		return nil, status.Error(codes.FailedPrecondition, "verify must be sent to a follower")
+	}
+
+	report, err := a.verifier.Verify(ctx, request.Namespace, int(request.Depth), false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &go_kvs.VerifyResponse{
+		Sequence: report.Sequence,
+		Depth:    int32(report.Depth),
+	}
+	for _, diff := range report.Ranges {
+		res.Ranges = append(res.Ranges, &go_kvs.RangeDiff{
+			Bucket:    int32(diff.Bucket),
+			Missing:   diff.Missing,
+			Extra:     diff.Extra,
+			Different: diff.Different,
+		})
+	}
+	return res, nil
+}
+
+// PauseReplication stops applying replicated commands on this follower
+func (a *AdminServer) PauseReplication(ctx context.Context, request *go_kvs.PauseReplicationRequest) (*go_kvs.ApplyStatus, error) {
+	if a.applier == nil {
+		return nil, status.Error(codes.FailedPrecondition, "replication can only be paused on a follower")
+	}
+	a.applier.PauseApply()
+	return a.applyStatus(), nil
+}
+
+// ResumeReplication applies queued commands again once they are due
+func (a *AdminServer) ResumeReplication(ctx context.Context, request *go_kvs.ResumeReplicationRequest) (*go_kvs.ApplyStatus, error) {
+	if a.applier == nil {
+		return nil, status.Error(codes.FailedPrecondition, "replication can only be resumed on a follower")
+	}
+	a.applier.ResumeApply()
+	return a.applyStatus(), nil
+}
+
+// ApplyUpTo applies queued commands up to the given sequence, ignoring the
+// apply delay, and leaves replication paused
+func (a *AdminServer) ApplyUpTo(ctx context.Context, request *go_kvs.ApplyUpToRequest) (*go_kvs.ApplyStatus, error) {
+	if a.applier == nil {
+		return nil, status.Error(codes.FailedPrecondition, "apply-up-to must be sent to a follower")
+	}
+	if err := a.applier.ApplyUpTo(ctx, request.Sequence); err != nil {
+		return nil, err
+	}
+	return a.applyStatus(), nil
+}
+
+func (a *AdminServer) applyStatus() *go_kvs.ApplyStatus {
+	state := a.applier.ApplyState()
+	res := &go_kvs.ApplyStatus{
+		AppliedSequence:   state.Applied,
+		ReceivedSequence:  state.Received,
+		Queued:            int32(state.Queued),
+		Paused:            state.Paused,
+		ApplyDelaySeconds: state.Delay.Seconds(),
+	}
+	if !state.NextApply.IsZero() {
+		res.NextApplyTime = timestamppb.New(state.NextApply)
+	}
+	return res
+}
+
+// Metrics returns the replication status in a form suitable for expvar
+func (a *AdminServer) Metrics() interface{} {
+	return a.replicationStatus()
+}
+
+func (a *AdminServer) memberStatuses() []*go_kvs.MemberStatus {
+	members := a.streamMgr.Members()
+	statuses := make([]*go_kvs.MemberStatus, 0, len(members))
+	for _, member := range members {
+		status := &go_kvs.MemberStatus{
+			NodeId:          member.NodeID,
+			Addr:            member.Addr,
+			LastAckSequence: member.LastAckSeq,
+			Lag:             member.Lag.Sequences,
+			LagSeconds:      member.Lag.Time.Seconds(),
+			State:           string(member.State),
+		}
+		if !member.ConnectedSince.IsZero() {
+			status.ConnectedSince = timestamppb.New(member.ConnectedSince)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/config"
+)
+
+func TestClusterStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		followerAddrs []string // configured on the leader
+		replicas      int      // followers replicating from the leader
+		stopped       int      // of which stopped again
+		askFollower   bool     // send ClusterStatus to a follower
+		want          []string // "addr state", addr "replicaN" for the Nth replica
+	}{
+		{name: "no followers", want: nil},
+		{name: "expected follower", followerAddrs: []string{"f1:1"}, want: []string{"f1:1 expected"}},
+		{name: "connected followers", replicas: 2, want: []string{"replica0 connected", "replica1 connected"}},
+		{name: "disconnected follower", replicas: 2, stopped: 1, want: []string{"replica0 disconnected", "replica1 connected"}},
+		{name: "asked through a follower", replicas: 1, askFollower: true, want: []string{"replica0 connected"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{FollowerAddrs: tt.followerAddrs})
+			names := map[string]string{}
+			var replicas []*testNode
+			for i := 0; i < tt.replicas; i++ {
+				replica := startReplica(t, config.ServerConfig{LeaderAddr: leader.addr})
+				names[replica.addr] = fmt.Sprintf("replica%d", i)
+				replicas = append(replicas, replica)
+			}
+			if tt.stopped > 0 {
+				res, err := go_kvs.NewGoKvsClient(dial(t, leader.addr)).Set(context.Background(), &go_kvs.KeyValRequest{Key: "k", Val: "v"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, replica := range replicas[:tt.stopped] {
+					replica.waitForSequence(t, res.Sequence)
+					replica.replica.Stop()
+				}
+			}
+
+			ask := leader
+			if tt.askFollower {
+				ask = replicas[0]
+			}
+			admin := go_kvs.NewAdminClient(dial(t, ask.addr))
+
+			// Streams connect and end in the background
+			var got []string
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				res, err := admin.ClusterStatus(context.Background(), &go_kvs.ClusterStatusRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.LeaderAddr != leader.addr {
+					t.Fatalf("leader addr = %q, want %q", res.LeaderAddr, leader.addr)
+				}
+				got = nil
+				for _, member := range res.Members {
+					addr := member.Addr
+					if name, ok := names[addr]; ok {
+						addr = name
+					}
+					got = append(got, addr+" "+member.State)
+				}
+				sort.Strings(got)
+				if reflect.DeepEqual(got, tt.want) {
+					return
+				}
+			}
+			t.Errorf("members = %q, want %q", got, tt.want)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storeCodes maps the store's errors to gRPC codes
+var storeCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{kvs.ErrNotFound, codes.NotFound},
+	{kvs.ErrCorrupt, codes.DataLoss},
+	{kvs.ErrKeyUnavailable, codes.FailedPrecondition},
+	{kvs.ErrReadOnly, codes.FailedPrecondition},
+	{kvs.ErrFull, codes.ResourceExhausted},
+	{kvs.ErrClosed, codes.Unavailable},
+	{kvs.ErrNamespaceNotFound, codes.NotFound},
+	{kvs.ErrNamespaceExists, codes.AlreadyExists},
+}
+
+// statusErr turns an error from the store into a gRPC status with the
+// matching code, and the error's reason attached so clients can get the
+// same error back. Statuses and context errors keep their code; other
+// errors, e.g. I/O errors, are Internal.
+func statusErr(err error) error {
+	for _, sc := range storeCodes {
+		if !errors.Is(err, sc.err) {
+			continue
+		}
+		st := status.New(sc.code, err.Error())
+		if detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{Domain: kvs.ErrorDomain, Reason: kvs.Reason(err)}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
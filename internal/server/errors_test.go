@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/client"
+	"go-kvs/internal/config"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErr(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string // attached as ErrorInfo, "" = none
+	}{
+		{"not found", fmt.Errorf("%w, key: a", kvs.ErrNotFound), codes.NotFound, "NOT_FOUND"},
+		{"corrupt", fmt.Errorf("%w: wal offset 0: bad checksum", kvs.ErrCorrupt), codes.DataLoss, "CORRUPT"},
+		{"key unavailable", kvs.ErrKeyUnavailable, codes.FailedPrecondition, "KEY_UNAVAILABLE"},
+		{"read-only", kvs.ErrReadOnly, codes.FailedPrecondition, "READ_ONLY"},
+		{"full", wal.ErrFull, codes.ResourceExhausted, "FULL"},
+		{"closed", kvs.ErrClosed, codes.Unavailable, "CLOSED"},
+		{"namespace not found", kvs.ErrNamespaceNotFound, codes.NotFound, "NAMESPACE_NOT_FOUND"},
+		{"namespace exists", kvs.ErrNamespaceExists, codes.AlreadyExists, "NAMESPACE_EXISTS"},
+		{"status", status.Error(codes.InvalidArgument, "bad key"), codes.InvalidArgument, ""},
+		{"canceled", fmt.Errorf("waiting: %w", context.Canceled), codes.Canceled, ""},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, ""},
+		{"I/O error", errors.New("read /data: input/output error"), codes.Internal, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(statusErr(tt.err))
+			if st.Code() != tt.wantCode {
+				t.Errorf("code = %s, want %s", st.Code(), tt.wantCode)
+			}
+			var reason string
+			for _, detail := range st.Details() {
+				if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == kvs.ErrorDomain {
+					reason = info.Reason
+				}
+			}
+			if reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestStoreErrorsOverGRPC(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context, leader *testNode, c *client.KvsClient) error
+		wantCode codes.Code
+		wantErr  error // the client library's error, nil = none
+	}{
+		{
+			name: "missing key",
+			call: func(ctx context.Context, _ *testNode, c *client.KvsClient) error {
+				_, err := c.Get(ctx, &go_kvs.GetRequest{Key: "missing"})
+				return err
+			},
+			wantCode: codes.NotFound,
+			wantErr:  client.ErrNotFound,
+		},
+		{
+			name: "delete missing key",
+			call: func(ctx context.Context, _ *testNode, c *client.KvsClient) error {
+				_, err := c.Del(ctx, &go_kvs.KeyRequest{Key: "missing"})
+				return err
+			},
+			wantCode: codes.NotFound,
+			wantErr:  client.ErrNotFound,
+		},
+		{
+			name: "missing namespace",
+			call: func(ctx context.Context, _ *testNode, c *client.KvsClient) error {
+				_, err := c.Get(ctx, &go_kvs.GetRequest{Key: "a", Namespace: "missing"})
+				return err
+			},
+			wantCode: codes.NotFound,
+			wantErr:  client.ErrNamespaceNotFound,
+		},
+		{
+			name: "damaged value",
+			call: func(ctx context.Context, leader *testNode, c *client.KvsClient) error {
+				path := filepath.Join(leader.dir, wal.SegmentName(0))
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				_, err = c.Get(ctx, &go_kvs.GetRequest{Key: "a"})
+				return err
+			},
+			wantCode: codes.DataLoss,
+			wantErr:  client.ErrCorrupt,
+		},
+		{
+			name: "closed store",
+			call: func(ctx context.Context, leader *testNode, c *client.KvsClient) error {
+				leader.kvs.Close()
+				_, err := c.Get(ctx, &go_kvs.GetRequest{Key: "a"})
+				return err
+			},
+			wantCode: codes.Unavailable,
+			wantErr:  client.ErrClosed,
+		},
+		{
+			name: "existing key in a batch",
+			call: func(ctx context.Context, _ *testNode, c *client.KvsClient) error {
+				_, err := c.Batch(ctx, &go_kvs.BatchRequest{Entries: []*go_kvs.KeyValue{{Key: "a", Value: "v"}}})
+				return err
+			},
+			wantCode: codes.AlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			c := client.NewKvsClient(dial(t, leader.addr))
+			ctx := context.Background()
+			if _, err := c.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "value"}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := tt.call(ctx, leader, c)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("code = %s (%v), want %s", code, err, tt.wantCode)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			var storeErr *client.Error
+			if tt.wantErr == nil && errors.As(err, &storeErr) {
+				t.Errorf("err = %v, want no store error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"go-kvs/api/proto/pb"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// leaderForwarder proxies RPCs from a follower to its leader.
+// The connection is dialed lazily on the first forwarded call.
+type leaderForwarder struct {
+	leaderAddr string
+	conn       *grpc.ClientConn
+	mu         sync.Mutex
+}
+
+func newLeaderForwarder(leaderAddr string) *leaderForwarder {
+	return &leaderForwarder{leaderAddr: leaderAddr}
+}
+
+func (f *leaderForwarder) getConn() (*grpc.ClientConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		return f.conn, nil
+	}
+
+	conn, err := grpc.Dial(f.leaderAddr, grpc.WithInsecure())
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to dial leader at %s", f.leaderAddr)
+		return nil, status.Errorf(codes.Unavailable, "leader %s unreachable", f.leaderAddr)
+	}
+	f.conn = conn
+	return f.conn, nil
+}
+
+func (f *leaderForwarder) Set(ctx context.Context, request *go_kvs.KeyValRequest) (*go_kvs.WriteResponse, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding Set(%s) to leader %s", request.Key, f.leaderAddr)
+	return go_kvs.NewGoKvsClient(conn).Set(ctx, request)
+}
+
+func (f *leaderForwarder) Del(ctx context.Context, request *go_kvs.KeyRequest) (*go_kvs.WriteResponse, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding Del(%s) to leader %s", request.Key, f.leaderAddr)
+	return go_kvs.NewGoKvsClient(conn).Del(ctx, request)
+}
+
+func (f *leaderForwarder) Batch(ctx context.Context, request *go_kvs.BatchRequest) (*go_kvs.BatchResponse, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding Batch(%d entries) to leader %s", len(request.Entries), f.leaderAddr)
+	return go_kvs.NewGoKvsClient(conn).Batch(ctx, request)
+}
+
+func (f *leaderForwarder) ClusterStatus(ctx context.Context, request *go_kvs.ClusterStatusRequest) (*go_kvs.ClusterStatusResponse, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	return go_kvs.NewAdminClient(conn).ClusterStatus(ctx, request)
+}
+
+func (f *leaderForwarder) CreateNamespace(ctx context.Context, request *go_kvs.CreateNamespaceRequest) (*go_kvs.NamespaceInfo, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding CreateNamespace(%s) to leader %s", request.Name, f.leaderAddr)
+	return go_kvs.NewAdminClient(conn).CreateNamespace(ctx, request)
+}
+
+func (f *leaderForwarder) DropNamespace(ctx context.Context, request *go_kvs.DropNamespaceRequest) (*go_kvs.DropNamespaceResponse, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding DropNamespace(%s) to leader %s", request.Name, f.leaderAddr)
+	return go_kvs.NewAdminClient(conn).DropNamespace(ctx, request)
+}
+
+func (f *leaderForwarder) SetNamespaceQuota(ctx context.Context, request *go_kvs.SetNamespaceQuotaRequest) (*go_kvs.NamespaceInfo, error) {
+	conn, err := f.getConn()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Forwarding SetNamespaceQuota(%s) to leader %s", request.Name, f.leaderAddr)
+	return go_kvs.NewAdminClient(conn).SetNamespaceQuota(ctx, request)
+}
+
+// notLeaderError returns FailedPrecondition with the leader address attached
+// as a NotLeader detail, so clients can redirect the write themselves.
+func notLeaderError(leaderAddr string) error {
+	st := status.New(codes.FailedPrecondition, "not leader")
+	if leaderAddr == "" {
+		return st.Err()
+	}
+
+	detailed, err := st.WithDetails(&go_kvs.NotLeader{LeaderAddr: leaderAddr})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/client"
+	"go-kvs/internal/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWritesOnFollower(t *testing.T) {
+	leader := startLeader(t, config.ServerConfig{})
+
+	tests := []struct {
+		name        string
+		proxyWrites bool
+		kvs         func(conn *grpc.ClientConn) go_kvs.GoKvsClient
+		want        codes.Code
+	}{
+		{
+			name: "rejected with a leader hint",
+			kvs:  func(conn *grpc.ClientConn) go_kvs.GoKvsClient { return go_kvs.NewGoKvsClient(conn) },
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "hint followed by the client",
+			kvs:  func(conn *grpc.ClientConn) go_kvs.GoKvsClient { return client.NewKvsClient(conn) },
+			want: codes.OK,
+		},
+		{
+			name:        "forwarded with --proxy-writes",
+			proxyWrites: true,
+			kvs:         func(conn *grpc.ClientConn) go_kvs.GoKvsClient { return go_kvs.NewGoKvsClient(conn) },
+			want:        codes.OK,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			follower := startFollower(t, config.ServerConfig{LeaderAddr: leader.addr, ProxyWrites: tt.proxyWrites}, nil)
+			kvsClient := tt.kvs(dial(t, follower.addr))
+			ctx := context.Background()
+			key := string(rune('a'+i)) + "-key"
+
+			calls := []struct {
+				name    string
+				call    func() error
+				wantVal string // on the leader afterwards, "" = deleted
+			}{
+				{"Set", func() error {
+					_, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: key, Val: "v1"})
+					return err
+				}, "v1"},
+				{"Batch", func() error {
+					_, err := kvsClient.Batch(ctx, &go_kvs.BatchRequest{Entries: []*go_kvs.KeyValue{{Key: key, Value: "v2"}}, Mode: go_kvs.BatchMode_OVERWRITE})
+					return err
+				}, "v2"},
+				{"Del", func() error {
+					_, err := kvsClient.Del(ctx, &go_kvs.KeyRequest{Key: key})
+					return err
+				}, ""},
+			}
+			for _, c := range calls {
+				err := c.call()
+				if code := status.Code(err); code != tt.want {
+					t.Fatalf("%s: code = %v, want %v (%v)", c.name, code, tt.want, err)
+				}
+				if tt.want == codes.FailedPrecondition {
+					if addr, ok := client.LeaderAddr(err); !ok || addr != leader.addr {
+						t.Errorf("%s: leader hint = %q, %v, want %q", c.name, addr, ok, leader.addr)
+					}
+					continue
+				}
+				if got, _, _ := leader.kvs.Lookup(key); got != c.wantVal {
+					t.Errorf("%s: leader's %s = %q, want %q", c.name, key, got, c.wantVal)
+				}
+			}
+			if follower.kvs.Len() != 0 {
+				t.Errorf("follower stored %d keys itself", follower.kvs.Len())
+			}
+		})
+	}
+}
@@ -1,28 +1,168 @@
 package server
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	gokvs "go-kvs/api/proto/pb"
+	"go-kvs/internal/antientropy"
+	"go-kvs/internal/datadir"
 	"go-kvs/internal/replication"
+	"go-kvs/pkg/kvs"
 
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// SnapshotSource captures the local store at a known sequence: one
+// namespace for anti-entropy, or every namespace for a backup.
+// Implemented by KvsServer (leader) and follower.StreamClient.
+type SnapshotSource interface {
+	ConsistentSnapshot(ns string) (*kvs.Snapshot, int64, error)
+	ConsistentSnapshotAll() ([]*kvs.Snapshot, int64)
+}
+
 type LeaderStreamServer struct {
 	streamMgr *replication.StreamManager
+	upstream  ReadIndexer       // set on relaying followers, nil on the leader
+	source    SnapshotSource    // serves anti-entropy checks
+	identity  *datadir.Identity // checked against each follower's IDs, nil to skip the check
+
+	batchSize   int           // most commands per StreamReplicationBatched message
+	batchLinger time.Duration // how long a partial batch waits for more commands
 	gokvs.UnimplementedReplicationServer
 }
 
-func NewLeaderStreamServer(streamMgr *replication.StreamManager) *LeaderStreamServer {
+func NewLeaderStreamServer(streamMgr *replication.StreamManager, source SnapshotSource) *LeaderStreamServer {
 	return &LeaderStreamServer{
-		streamMgr: streamMgr,
+		streamMgr:   streamMgr,
+		source:      source,
+		batchSize:   replication.DefaultBatchSize,
+		batchLinger: replication.DefaultBatchLinger,
+	}
+}
+
+// NewRelayStreamServer serves the replication stream from a follower.
+// streamMgr re-streams what the follower applies; ReadIndex requests are
+// passed on to the follower's own upstream.
+func NewRelayStreamServer(streamMgr *replication.StreamManager, upstream ReadIndexer, source SnapshotSource) *LeaderStreamServer {
+	return &LeaderStreamServer{
+		streamMgr:   streamMgr,
+		upstream:    upstream,
+		source:      source,
+		batchSize:   replication.DefaultBatchSize,
+		batchLinger: replication.DefaultBatchLinger,
+	}
+}
+
+// SetBatching sets how StreamReplicationBatched groups commands: at most
+// size commands per message, a partial batch is sent after linger
+func (s *LeaderStreamServer) SetBatching(size int, linger time.Duration) {
+	s.batchSize = size
+	s.batchLinger = linger
+}
+
+// SetIdentity makes the server refuse followers from another cluster and
+// send its IDs to the ones it accepts
+func (s *LeaderStreamServer) SetIdentity(identity *datadir.Identity) {
+	s.identity = identity
+}
+
+// handshake checks the follower's IDs against this node's and sends this
+// node's IDs in the response header
+func (s *LeaderStreamServer) handshake(req *gokvs.FollowerInfo, stream grpc.ServerStream) error {
+	if s.identity == nil {
+		return nil
+	}
+
+	clusterID := s.identity.ClusterID()
+	if clusterID == "" {
+		// A relay that hasn't connected to its own upstream yet
+		return status.Error(codes.Unavailable, "this node hasn't joined a cluster yet")
+	}
+	if req.ClusterId != "" && req.ClusterId != clusterID {
+		log.Error().Msgf("Refusing follower %s from cluster %s (this cluster: %s)", req.FollowerId, req.ClusterId, clusterID)
+		return status.Errorf(codes.FailedPrecondition, "cluster ID mismatch: follower %s belongs to cluster %s, this node to cluster %s", req.FollowerId, req.ClusterId, clusterID)
 	}
+	if req.NodeUuid == s.identity.NodeID() {
+		return status.Errorf(codes.FailedPrecondition, "follower %s has this node's ID %s: --leader-addr points to this node, or its data directory is a copy of this node's", req.FollowerId, req.NodeUuid)
+	}
+
+	return stream.SendHeader(metadata.Pairs(
+		replication.ClusterIDHeader, clusterID,
+		replication.NodeIDHeader, s.identity.NodeID(),
+	))
 }
 
 // StreamReplication handles follower connections and streams commands to them
 func (s *LeaderStreamServer) StreamReplication(req *gokvs.FollowerInfo, stream gokvs.Replication_StreamReplicationServer) error {
+	if err := s.handshake(req, stream); err != nil {
+		return err
+	}
+	return s.streamCommands(req, stream.Context(), 1, 0, func(cmds []*gokvs.ReplicationCommand) error {
+		return stream.Send(cmds[0])
+	})
+}
+
+// StreamReplicationBatched streams commands to a follower in compressed
+// batches, using the first codec in the follower's list this node supports
+func (s *LeaderStreamServer) StreamReplicationBatched(req *gokvs.FollowerInfo, stream gokvs.Replication_StreamReplicationBatchedServer) error {
+	if err := s.handshake(req, stream); err != nil {
+		return err
+	}
+
+	codec := replication.NegotiateCompression(req.AcceptCompression)
+	log.Info().Msgf("Follower %s uses batched stream (batch=%d, linger=%s, compression=%s)", req.FollowerId, s.batchSize, s.batchLinger, codec)
+
+	return s.streamCommands(req, stream.Context(), s.batchSize, s.batchLinger, func(cmds []*gokvs.ReplicationCommand) error {
+		batch, err := replication.EncodeBatch(cmds, codec)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to encode batch: %v", err)
+		}
+		return stream.Send(batch)
+	})
+}
+
+// streamCommands replays missed commands to a follower, then streams live
+// ones through send until the follower disconnects. Commands are handed to
+// send batchSize at a time; a partial batch is flushed after linger.
+func (s *LeaderStreamServer) streamCommands(req *gokvs.FollowerInfo, ctx context.Context, batchSize int, linger time.Duration, send func([]*gokvs.ReplicationCommand) error) error {
 	followerID := req.FollowerId
 	lastSeq := req.LastSequence
-	log.Info().Msgf("Follower %s connected (last_seq=%d)", followerID, lastSeq)
+	log.Info().Msgf("Follower %s connected from %s (last_seq=%d)", followerID, req.FollowerAddr, lastSeq)
+	s.streamMgr.Connecting(followerID, req.FollowerAddr)
+	defer s.streamMgr.Disconnected(followerID)
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	// Followers from before stored commands can only read uncompressed ones
+	if !req.StoredCommands {
+		log.Info().Msgf("Follower %s doesn't read stored commands, sending them uncompressed", followerID)
+		sendStored := send
+		send = func(cmds []*gokvs.ReplicationCommand) error {
+			plain, err := replication.PlainCommands(cmds)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to decompress command: %v", err)
+			}
+			return sendStored(plain)
+		}
+	}
+
+	// Partial replicas get skip markers in place of filtered-out commands
+	filter := replication.FilterFromProto(req.Filter)
+	if !filter.Empty() {
+		log.Info().Msgf("Follower %s replicates %s", followerID, filter)
+		sendAll := send
+		send = func(cmds []*gokvs.ReplicationCommand) error {
+			return sendAll(filter.Commands(cmds))
+		}
+	}
 
 	// Step 1: Catch-up - replay missed commands
 	missedCommands, canCatchUp := s.streamMgr.GetMissedCommands(lastSeq)
@@ -35,12 +175,16 @@ func (s *LeaderStreamServer) StreamReplication(req *gokvs.FollowerInfo, stream g
 		log.Info().Msgf("Replaying %d missed commands to follower %s", len(missedCommands), followerID)
 
 		// Send missed commands
-		for _, cmd := range missedCommands {
-			if err := stream.Send(cmd); err != nil {
+		for start := 0; start < len(missedCommands); start += batchSize {
+			end := start + batchSize
+			if end > len(missedCommands) {
+				end = len(missedCommands)
+			}
+			if err := send(missedCommands[start:end]); err != nil {
 				log.Error().Err(err).Msgf("Failed to send catch-up command to %s", followerID)
 				return err
 			}
-			log.Debug().Msgf("Catch-up: sent seq=%d to follower %s", cmd.Sequence, followerID)
+			log.Debug().Msgf("Catch-up: sent up to seq=%d to follower %s", missedCommands[end-1].Sequence, followerID)
 		}
 
 		log.Info().Msgf("Follower %s caught up successfully", followerID)
@@ -56,14 +200,125 @@ func (s *LeaderStreamServer) StreamReplication(req *gokvs.FollowerInfo, stream g
 	s.streamMgr.Register(followerID, cmdChan)
 	defer s.streamMgr.Unregister(followerID)
 
-	// Send commands from channel to stream
-	for cmd := range cmdChan {
-		if err := stream.Send(cmd); err != nil {
+	// Step 3: Send commands from channel to stream, batchSize at a time
+	pending := make([]*gokvs.ReplicationCommand, 0, batchSize)
+	lingerTimer := time.NewTimer(linger)
+	lingerTimer.Stop()
+	defer lingerTimer.Stop()
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := send(pending); err != nil {
 			log.Error().Err(err).Msgf("Failed to send to follower %s, disconnecting", followerID)
 			return err
 		}
-		log.Debug().Msgf("Sent seq=%d to follower %s", cmd.Sequence, followerID)
+		log.Debug().Msgf("Sent %d commands up to seq=%d to follower %s", len(pending), pending[len(pending)-1].Sequence, followerID)
+		pending = make([]*gokvs.ReplicationCommand, 0, batchSize)
+		return nil
+	}
+
+	for {
+		select {
+		case cmd, ok := <-cmdChan:
+			if !ok {
+				return flush()
+			}
+			pending = append(pending, cmd)
+			if len(pending) >= batchSize {
+				lingerTimer.Stop()
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if len(pending) == 1 {
+				lingerTimer.Reset(linger)
+			}
+		case <-lingerTimer.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
 
-	return nil
+// Heartbeat keeps the leader's lease alive and tells the follower how far ahead the leader is
+func (s *LeaderStreamServer) Heartbeat(ctx context.Context, req *gokvs.HeartbeatRequest) (*gokvs.HeartbeatResponse, error) {
+	lag := s.streamMgr.Heartbeat(req.FollowerId, req.AppliedSequence)
+	return &gokvs.HeartbeatResponse{
+		LeaderSequence: lag.LeaderSequence,
+		LagSeconds:     lag.Time.Seconds(),
+	}, nil
+}
+
+// ReadIndex confirms leadership and returns the sequence a linearizable read must wait for
+func (s *LeaderStreamServer) ReadIndex(ctx context.Context, req *gokvs.ReadIndexRequest) (*gokvs.ReadIndexResponse, error) {
+	var readIndexer ReadIndexer = s.streamMgr
+	if s.upstream != nil {
+		readIndexer = s.upstream
+	}
+
+	seq, err := readIndexer.ReadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gokvs.ReadIndexResponse{Sequence: seq}, nil
+}
+
+// MerkleTree returns the leaf hashes of a Merkle tree over the local store
+// and the sequence it was built at
+func (s *LeaderStreamServer) MerkleTree(ctx context.Context, req *gokvs.MerkleTreeRequest) (*gokvs.MerkleTreeResponse, error) {
+	depth := int(req.Depth)
+	if depth == 0 {
+		depth = antientropy.DefaultDepth
+	}
+
+	snap, seq, err := s.source.ConsistentSnapshot(req.Namespace)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	if filter := replication.FilterFromProto(req.Filter); !filter.Empty() {
+		snap = snap.Filter(filter.Match)
+	}
+	tree, err := antientropy.BuildTree(snap, depth)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &gokvs.MerkleTreeResponse{
+		Sequence: seq,
+		Depth:    int32(depth),
+		Leaves:   tree.Leaves(),
+	}, nil
+}
+
+// FetchRanges returns every key/value pair in the requested hash ranges
+func (s *LeaderStreamServer) FetchRanges(ctx context.Context, req *gokvs.FetchRangesRequest) (*gokvs.FetchRangesResponse, error) {
+	buckets := make([]int, 0, len(req.Buckets))
+	for _, bucket := range req.Buckets {
+		buckets = append(buckets, int(bucket))
+	}
+
+	snap, seq, err := s.source.ConsistentSnapshot(req.Namespace)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	if filter := replication.FilterFromProto(req.Filter); !filter.Empty() {
+		snap = snap.Filter(filter.Match)
+	}
+	entries, err := antientropy.RangeEntries(snap, int(req.Depth), buckets)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &gokvs.FetchRangesResponse{Sequence: seq}
+	for key, val := range entries {
+		res.Entries = append(res.Entries, &gokvs.RangeEntry{Key: key, Value: val})
+	}
+	sort.Slice(res.Entries, func(i, j int) bool {
+		return res.Entries[i].Key < res.Entries[j].Key
+	})
+	return res, nil
 }
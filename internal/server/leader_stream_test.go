@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/config"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/replication"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs"
+)
+
+func TestCascadingReplication(t *testing.T) {
+	tests := []struct {
+		name      string
+		connectAt int // writes made before the downstream follower connects
+	}{
+		{name: "downstream connected before the writes", connectAt: 0},
+		{name: "downstream catches up from the relay", connectAt: 2},
+		{name: "downstream connected after all writes", connectAt: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			relay := startReplica(t, config.ServerConfig{LeaderAddr: leader.addr, ServeReplication: true})
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx := context.Background()
+
+			writes := []func() (*go_kvs.WriteResponse, error){
+				func() (*go_kvs.WriteResponse, error) {
+					return kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v1"})
+				},
+				func() (*go_kvs.WriteResponse, error) {
+					return kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "b", Val: "v1"})
+				},
+				func() (*go_kvs.WriteResponse, error) {
+					return kvsClient.Del(ctx, &go_kvs.KeyRequest{Key: "a"})
+				},
+			}
+			var downstream *testNode
+			var last int64
+			for i, write := range writes {
+				if i == tt.connectAt {
+					downstream = startReplica(t, config.ServerConfig{LeaderAddr: relay.addr})
+				}
+				res, err := write()
+				if err != nil {
+					t.Fatal(err)
+				}
+				last = res.Sequence
+			}
+			if downstream == nil {
+				relay.waitForSequence(t, last)
+				downstream = startReplica(t, config.ServerConfig{LeaderAddr: relay.addr})
+			}
+
+			// The downstream follower applies the leader's sequences through the relay
+			downstream.waitForSequence(t, last)
+			if got := downstream.kvs.LastSequence(); got != last {
+				t.Errorf("downstream WAL seq = %d, want %d", got, last)
+			}
+			if members := leader.streamMgr.Members(); len(members) != 1 || members[0].Addr != relay.addr {
+				t.Errorf("leader streams to %+v, want only the relay", members)
+			}
+			for key, want := range map[string]string{"a": "", "b": "v1"} {
+				if got, _, _ := downstream.kvs.Lookup(key); got != want {
+					t.Errorf("downstream %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClusterIdentity(t *testing.T) {
+	// writeIdentity gives the data directory at path the IDs node and cluster
+	writeIdentity := func(t *testing.T, path, node, cluster string) {
+		dataDir, err := datadir.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dataDir.Close()
+		data := fmt.Sprintf(`{"node_id": %q, "cluster_id": %q}`, node, cluster)
+		if err := os.WriteFile(dataDir.IdentityFile(), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// writeData leaves a key in the WAL of the data directory at path
+	writeData := func(t *testing.T, path string) {
+		dataDir, err := datadir.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dataDir.Close()
+		store, err := kvs.New(dataDir.WALDir(), 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.Set("old", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		prepare     func(t *testing.T, path string, leader *datadir.Identity) // the follower's data directory
+		joinCluster bool
+		wantCluster string // the follower's cluster ID afterwards, "leader" for the leader's
+		refused     bool
+	}{
+		{
+			name:        "new follower joins the cluster",
+			prepare:     func(*testing.T, string, *datadir.Identity) {},
+			wantCluster: "leader",
+		},
+		{
+			name: "follower of the same cluster",
+			prepare: func(t *testing.T, path string, leader *datadir.Identity) {
+				writeIdentity(t, path, datadir.NewID(), leader.ClusterID())
+			},
+			wantCluster: "leader",
+		},
+		{
+			name: "follower from another cluster",
+			prepare: func(t *testing.T, path string, leader *datadir.Identity) {
+				writeIdentity(t, path, datadir.NewID(), "other")
+			},
+			wantCluster: "other",
+			refused:     true,
+		},
+		{
+			name:    "data but no cluster ID",
+			prepare: func(t *testing.T, path string, _ *datadir.Identity) { writeData(t, path) },
+			refused: true,
+		},
+		{
+			name:        "data, joining anyway",
+			prepare:     func(t *testing.T, path string, _ *datadir.Identity) { writeData(t, path) },
+			joinCluster: true,
+			wantCluster: "leader",
+		},
+		{
+			name: "copy of the leader's data directory",
+			prepare: func(t *testing.T, path string, leader *datadir.Identity) {
+				writeIdentity(t, path, leader.NodeID(), leader.ClusterID())
+			},
+			wantCluster: "leader",
+			refused:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{DataDir: t.TempDir(), Bootstrap: true})
+			path := t.TempDir()
+			tt.prepare(t, path, leader.identity)
+			replica := startReplica(t, config.ServerConfig{LeaderAddr: leader.addr, DataDir: path, JoinCluster: tt.joinCluster})
+
+			res, err := go_kvs.NewGoKvsClient(dial(t, leader.addr)).Set(context.Background(), &go_kvs.KeyValRequest{Key: "a", Val: "v"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.refused {
+				time.Sleep(200 * time.Millisecond)
+				if got, _, _ := replica.kvs.Lookup("a"); got != "" {
+					t.Error("replicated from the leader")
+				}
+			} else {
+				replica.waitForSequence(t, res.Sequence)
+			}
+
+			want := tt.wantCluster
+			if want == "leader" {
+				want = leader.identity.ClusterID()
+			}
+			if got := replica.identity.ClusterID(); got != want {
+				t.Errorf("follower's cluster = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCompressedReplication(t *testing.T) {
+	val := strings.Repeat("v", 1000)
+
+	tests := []struct {
+		name         string
+		leaderCodec  string
+		replicaCodec string
+	}{
+		{name: "uncompressed leader", leaderCodec: "none", replicaCodec: "zstd"},
+		{name: "snappy leader", leaderCodec: "snappy", replicaCodec: "none"},
+		{name: "zstd leader", leaderCodec: "zstd", replicaCodec: "zstd"},
+		{name: "replica with another codec", leaderCodec: "zstd", replicaCodec: "snappy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{WALCompression: tt.leaderCodec})
+			replica := startReplica(t, config.ServerConfig{LeaderAddr: leader.addr, WALCompression: tt.replicaCodec})
+			res, err := go_kvs.NewGoKvsClient(dial(t, leader.addr)).Set(context.Background(), &go_kvs.KeyValRequest{Key: "a", Val: val})
+			if err != nil {
+				t.Fatal(err)
+			}
+			replica.waitForSequence(t, res.Sequence)
+			if got, _, _ := replica.kvs.Lookup("a"); got != val {
+				t.Errorf("replica a = %q, want %d bytes", got, len(val))
+			}
+
+			// Followers that don't read stored commands get them uncompressed
+			codec, err := wal.ParseCodec(tt.leaderCodec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stored := range []bool{true, false} {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				stream, err := go_kvs.NewReplicationClient(dial(t, leader.addr)).StreamReplication(ctx, &go_kvs.FollowerInfo{FollowerId: "raw", StoredCommands: stored})
+				if err != nil {
+					t.Fatal(err)
+				}
+				cmd, err := stream.Recv()
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := go_kvs.Compression_COMPRESSION_NONE
+				if stored {
+					want = replication.CommandCompression(codec)
+				}
+				if cmd.Compression != want {
+					t.Errorf("stored_commands=%v: compression = %s, want %s", stored, cmd.Compression, want)
+				}
+				decoded, err := replication.DecodeCommand(cmd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded.Key != "a" || decoded.Val != val {
+					t.Errorf("stored_commands=%v: decoded %s %q", stored, decoded.Cmd, decoded.Key)
+				}
+			}
+		})
+	}
+}
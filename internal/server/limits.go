@@ -0,0 +1,59 @@
+package server
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limits bounds what clients can store on the node. Zero means no limit.
+type limits struct {
+	maxKeySize   int // bytes
+	maxValueSize int // bytes
+	maxKeys      int
+}
+
+// checkSize rejects a key or value larger than the node allows
+func (k *KvsServer) checkSize(key, val string) error {
+	if k.limits.maxKeySize > 0 && len(key) > k.limits.maxKeySize {
+		return status.Errorf(codes.InvalidArgument, "key is %d bytes, at most %d allowed", len(key), k.limits.maxKeySize)
+	}
+	if k.limits.maxValueSize > 0 && len(val) > k.limits.maxValueSize {
+		return status.Errorf(codes.InvalidArgument, "value of %q is %d bytes, at most %d allowed", key, len(val), k.limits.maxValueSize)
+	}
+	return nil
+}
+
+// checkQuota rejects writes to the namespace ns that add newKeys keys,
+// grow its keys and values by growth bytes and write size bytes if they
+// would take the namespace past its quota or the node past its key count
+// or data directory size. Sizes are checked uncompressed. The caller holds
+// writeMu, so the counts can't change before the write.
+func (k *KvsServer) checkQuota(ns string, newKeys int, growth, size int64) error {
+	info, err := k.kvs.Namespace(ns)
+	if err != nil {
+		return statusErr(err)
+	}
+	if quota := info.Quota; quota.MaxKeys > 0 && newKeys > 0 && int64(info.Keys+newKeys) > quota.MaxKeys {
+		return status.Errorf(codes.ResourceExhausted, "namespace %q holds %d keys, writing %d more would exceed its quota of %d", ns, info.Keys, newKeys, quota.MaxKeys)
+	}
+	if quota := info.Quota; quota.MaxBytes > 0 && growth > 0 && info.Bytes+growth > quota.MaxBytes {
+		return status.Errorf(codes.ResourceExhausted, "namespace %q holds %d bytes, writing %d more would exceed its quota of %d", ns, info.Bytes, growth, quota.MaxBytes)
+	}
+
+	if k.limits.maxKeys > 0 && newKeys > 0 {
+		if n := k.kvs.Len(); n+newKeys > k.limits.maxKeys {
+			return status.Errorf(codes.ResourceExhausted, "node holds %d keys, writing %d more would exceed the limit of %d", n, newKeys, k.limits.maxKeys)
+		}
+	}
+	if left, limited := k.kvs.SpaceLeft(); limited && size > left {
+		return status.Errorf(codes.ResourceExhausted, "data directory size limit reached (%d bytes left, write needs %d)", max64(left, 0), size)
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.ServerConfig
+		spaceLeft int64 // WAL bytes left after the first write, 0 = no limit
+		key, val  string
+		batch     bool // write with Batch rather than Set
+		wantCode  codes.Code
+	}{
+		{name: "no limits", key: strings.Repeat("k", 1000), val: strings.Repeat("v", 100000), wantCode: codes.OK},
+		{name: "key at the limit", cfg: config.ServerConfig{MaxKeySize: 4}, key: "abcd", val: "v", wantCode: codes.OK},
+		{name: "key too large", cfg: config.ServerConfig{MaxKeySize: 4}, key: "abcde", val: "v", wantCode: codes.InvalidArgument},
+		{name: "value too large", cfg: config.ServerConfig{MaxValueSize: 4}, key: "b", val: "12345", wantCode: codes.InvalidArgument},
+		{name: "value too large in a batch", cfg: config.ServerConfig{MaxValueSize: 4}, key: "b", val: "12345", batch: true, wantCode: codes.InvalidArgument},
+		{name: "key count reached", cfg: config.ServerConfig{MaxKeys: 1}, key: "b", val: "v", wantCode: codes.ResourceExhausted},
+		{name: "key count reached by a batch", cfg: config.ServerConfig{MaxKeys: 1}, key: "b", val: "v", batch: true, wantCode: codes.ResourceExhausted},
+		{name: "overwrite at the key count", cfg: config.ServerConfig{MaxKeys: 1}, key: "a", val: "v2", wantCode: codes.OK},
+		{name: "data directory has room", spaceLeft: 1000, key: "b", val: strings.Repeat("v", 100), wantCode: codes.OK},
+		{name: "data directory full", spaceLeft: 50, key: "b", val: strings.Repeat("v", 100), wantCode: codes.ResourceExhausted},
+		{name: "data directory full for a batch", spaceLeft: 50, key: "b", val: strings.Repeat("v", 100), batch: true, wantCode: codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, tt.cfg)
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx := context.Background()
+			if _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.spaceLeft > 0 {
+				leader.kvs.SetMaxSize(leader.kvs.Size() + tt.spaceLeft)
+			}
+
+			var err error
+			if tt.batch {
+				_, err = kvsClient.Batch(ctx, &go_kvs.BatchRequest{
+					Entries: []*go_kvs.KeyValue{{Key: tt.key, Value: tt.val}},
+					Mode:    go_kvs.BatchMode_OVERWRITE,
+				})
+			} else {
+				_, err = kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: tt.key, Val: tt.val})
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("write: %v, want code %s", err, tt.wantCode)
+			}
+
+			// A refused write leaves the store as it was
+			want := tt.val
+			if tt.wantCode != codes.OK {
+				want = ""
+				if tt.key == "a" {
+					want = "v"
+				}
+			}
+			if got, _, _ := leader.kvs.Lookup(tt.key); got != want {
+				t.Errorf("%s = %q, want %q", tt.key, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Drainer tracks in-flight RPCs so shutdown can wait for them, and rejects
+// new RPCs once draining has started
+type Drainer struct {
+	longLived map[string]bool // RPCs closed by their owner rather than waited for
+	draining  bool
+	inFlight  sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// NewDrainer creates a Drainer. longLived lists the full method names of
+// RPCs that only end when the server closes them (replication, watches,
+// long polls); they are rejected while draining but not waited for.
+func NewDrainer(longLived ...string) *Drainer {
+	d := &Drainer{longLived: make(map[string]bool)}
+	for _, method := range longLived {
+		d.longLived[method] = true
+	}
+	return d
+}
+
+// UnaryServerInterceptor rejects unary RPCs while draining and tracks the
+// ones that aren't long-lived
+func (d *Drainer) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	track := !d.longLived[info.FullMethod]
+	if !d.begin(track) {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	if track {
+		defer d.inFlight.Done()
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor rejects streams while draining and tracks the
+// ones that aren't long-lived
+func (d *Drainer) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	track := !d.longLived[info.FullMethod]
+	if !d.begin(track) {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	if track {
+		defer d.inFlight.Done()
+	}
+
+	return handler(srv, ss)
+}
+
+// begin admits an RPC unless draining
+func (d *Drainer) begin(track bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	if track {
+		d.inFlight.Add(1)
+	}
+	return true
+}
+
+// Drain stops admitting RPCs and waits up to timeout for in-flight ones.
+// It reports whether all of them finished.
+func (d *Drainer) Drain(timeout time.Duration) bool {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDrain(t *testing.T) {
+	const watch = "/go_kvs.GoKvs/Watch"
+
+	tests := []struct {
+		name      string
+		method    string        // of the RPC in flight when draining starts
+		runs      time.Duration // how long that RPC keeps running
+		wantDrain bool
+	}{
+		{name: "nothing in flight", wantDrain: true},
+		{name: "in flight, finishes in time", method: "/go_kvs.GoKvs/Set", runs: 20 * time.Millisecond, wantDrain: true},
+		{name: "in flight, runs past the timeout", method: "/go_kvs.GoKvs/Set", runs: time.Second, wantDrain: false},
+		{name: "long-lived, not waited for", method: watch, runs: time.Second, wantDrain: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDrainer(watch)
+			started, release := make(chan struct{}), make(chan struct{})
+			defer close(release)
+			if tt.method != "" {
+				runs := tt.runs
+				go d.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+					func(ctx context.Context, req interface{}) (interface{}, error) {
+						close(started)
+						select {
+						case <-time.After(runs):
+						case <-release:
+						}
+						return nil, nil
+					})
+				<-started
+			}
+
+			if drained := d.Drain(100 * time.Millisecond); drained != tt.wantDrain {
+				t.Errorf("drained = %v, want %v", drained, tt.wantDrain)
+			}
+
+			// New RPCs are rejected once draining has started
+			_, err := d.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/go_kvs.GoKvs/Get"},
+				func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("unary RPC while draining: %v, want Unavailable", err)
+			}
+			err = d.StreamServerInterceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: watch},
+				func(srv interface{}, stream grpc.ServerStream) error { return nil })
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("stream while draining: %v, want Unavailable", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/pkg/kvs"
+	"go-kvs/pkg/kvs/command"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NamespaceManager creates, drops and lists the namespaces of the store.
+// Implemented by KvsServer.
+type NamespaceManager interface {
+	CreateNamespace(name string, quota kvs.Quota) (kvs.NamespaceInfo, error)
+	DropNamespace(name string) (seq int64, keys int, err error)
+	SetNamespaceQuota(name string, quota kvs.Quota) (kvs.NamespaceInfo, error)
+	Namespaces() []kvs.NamespaceInfo
+}
+
+// CreateNamespace creates the namespace name and replicates it to followers
+func (k *KvsServer) CreateNamespace(name string, quota kvs.Quota) (kvs.NamespaceInfo, error) {
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	cmd := command.New("ns-create", "", quota.String())
+	cmd.Namespace = name
+	seq, _, err := k.commitLocked(cmd)
+	if err != nil {
+		return kvs.NamespaceInfo{}, err
+	}
+	log.Info().Msgf("Created namespace %q (seq=%d)", name, seq)
+	return k.kvs.Namespace(name)
+}
+
+// DropNamespace drops the namespace name with all its keys and replicates
+// the drop to followers. It returns the drop's sequence and how many keys
+// were dropped.
+func (k *KvsServer) DropNamespace(name string) (int64, int, error) {
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	info, err := k.kvs.Namespace(name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cmd := command.New("ns-drop", "", "")
+	cmd.Namespace = name
+	seq, commitTime, err := k.commitLocked(cmd)
+	if err != nil {
+		return 0, 0, err
+	}
+	k.publishChange(cdc.Event{Namespace: name, Op: cdc.OpDropNamespace, Sequence: seq, Time: commitTime})
+	log.Info().Msgf("Dropped namespace %q with %d keys (seq=%d)", name, info.Keys, seq)
+	return seq, info.Keys, nil
+}
+
+// SetNamespaceQuota replaces the quota of the namespace name and
+// replicates it to followers. Keys already over the quota are kept.
+func (k *KvsServer) SetNamespaceQuota(name string, quota kvs.Quota) (kvs.NamespaceInfo, error) {
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	cmd := command.New("ns-quota", "", quota.String())
+	cmd.Namespace = name
+	if _, _, err := k.commitLocked(cmd); err != nil {
+		return kvs.NamespaceInfo{}, err
+	}
+	return k.kvs.Namespace(name)
+}
+
+// Namespaces returns the quota and statistics of every namespace
+func (k *KvsServer) Namespaces() []kvs.NamespaceInfo {
+	return k.kvs.Namespaces()
+}
+
+// SetNamespaces enables the namespace RPCs, served by manager
+func (a *AdminServer) SetNamespaces(manager NamespaceManager) {
+	a.namespaces = manager
+}
+
+// CreateNamespace creates a namespace on the leader. Followers forward the
+// request with --proxy-writes, like writes.
+func (a *AdminServer) CreateNamespace(ctx context.Context, request *go_kvs.CreateNamespaceRequest) (*go_kvs.NamespaceInfo, error) {
+	if !a.isLeader {
+		if a.proxyWrites {
+			return a.leader.CreateNamespace(ctx, request)
+		}
+		return nil, notLeaderError(a.leaderAddr)
+	}
+	if err := a.checkNamespaces(); err != nil {
+		return nil, err
+	}
+	if !kvs.ValidNamespace(request.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid namespace name %q: use 1 to 64 letters, digits, '-', '_' or '.'", request.Name)
+	}
+
+	info, err := a.namespaces.CreateNamespace(request.Name, quotaFromProto(request.Quota))
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return namespaceInfo(info), nil
+}
+
+// DropNamespace drops a namespace and every key in it on the leader.
+// Followers forward the request with --proxy-writes.
+func (a *AdminServer) DropNamespace(ctx context.Context, request *go_kvs.DropNamespaceRequest) (*go_kvs.DropNamespaceResponse, error) {
+	if !a.isLeader {
+		if a.proxyWrites {
+			return a.leader.DropNamespace(ctx, request)
+		}
+		return nil, notLeaderError(a.leaderAddr)
+	}
+	if err := a.checkNamespaces(); err != nil {
+		return nil, err
+	}
+	if request.Name == kvs.DefaultNamespace {
+		return nil, status.Error(codes.InvalidArgument, "the default namespace can't be dropped")
+	}
+
+	seq, keys, err := a.namespaces.DropNamespace(request.Name)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return &go_kvs.DropNamespaceResponse{Sequence: seq, Keys: int64(keys)}, nil
+}
+
+// SetNamespaceQuota replaces a namespace's quota on the leader. Followers
+// forward the request with --proxy-writes.
+func (a *AdminServer) SetNamespaceQuota(ctx context.Context, request *go_kvs.SetNamespaceQuotaRequest) (*go_kvs.NamespaceInfo, error) {
+	if !a.isLeader {
+		if a.proxyWrites {
+			return a.leader.SetNamespaceQuota(ctx, request)
+		}
+		return nil, notLeaderError(a.leaderAddr)
+	}
+	if err := a.checkNamespaces(); err != nil {
+		return nil, err
+	}
+
+	info, err := a.namespaces.SetNamespaceQuota(request.Name, quotaFromProto(request.Quota))
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return namespaceInfo(info), nil
+}
+
+// ListNamespaces returns this node's namespaces with their quotas and statistics
+func (a *AdminServer) ListNamespaces(ctx context.Context, request *go_kvs.ListNamespacesRequest) (*go_kvs.ListNamespacesResponse, error) {
+	if err := a.checkNamespaces(); err != nil {
+		return nil, err
+	}
+
+	res := &go_kvs.ListNamespacesResponse{}
+	for _, info := range a.namespaces.Namespaces() {
+		res.Namespaces = append(res.Namespaces, namespaceInfo(info))
+	}
+	return res, nil
+}
+
+func (a *AdminServer) checkNamespaces() error {
+	if a.namespaces == nil {
+		return status.Error(codes.Unimplemented, "namespaces aren't managed by this node")
+	}
+	return nil
+}
+
+func quotaFromProto(quota *go_kvs.NamespaceQuota) kvs.Quota {
+	if quota == nil {
+		return kvs.Quota{}
+	}
+	return kvs.Quota{MaxKeys: quota.MaxKeys, MaxBytes: quota.MaxBytes}
+}
+
+func namespaceInfo(info kvs.NamespaceInfo) *go_kvs.NamespaceInfo {
+	res := &go_kvs.NamespaceInfo{
+		Name:   info.Name,
+		Quota:  &go_kvs.NamespaceQuota{MaxKeys: info.Quota.MaxKeys, MaxBytes: info.Quota.MaxBytes},
+		Keys:   int64(info.Keys),
+		Bytes:  info.Bytes,
+		Writes: info.Writes,
+	}
+	if !info.Created.IsZero() {
+		res.Created = timestamppb.New(info.Created)
+	}
+	return res
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/client"
+	"go-kvs/internal/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// namespaceAdmin is the part of the Admin API sent to followers below,
+// implemented by the generated client and by client.AdminClient
+type namespaceAdmin interface {
+	CreateNamespace(ctx context.Context, in *go_kvs.CreateNamespaceRequest, opts ...grpc.CallOption) (*go_kvs.NamespaceInfo, error)
+	SetNamespaceQuota(ctx context.Context, in *go_kvs.SetNamespaceQuotaRequest, opts ...grpc.CallOption) (*go_kvs.NamespaceInfo, error)
+	DropNamespace(ctx context.Context, in *go_kvs.DropNamespaceRequest, opts ...grpc.CallOption) (*go_kvs.DropNamespaceResponse, error)
+}
+
+func TestNamespaceWritesOnFollower(t *testing.T) {
+	leader := startLeader(t, config.ServerConfig{})
+
+	tests := []struct {
+		name        string
+		proxyWrites bool
+		admin       func(conn *grpc.ClientConn) namespaceAdmin
+		want        codes.Code
+	}{
+		{
+			name:  "rejected with a leader hint",
+			admin: func(conn *grpc.ClientConn) namespaceAdmin { return go_kvs.NewAdminClient(conn) },
+			want:  codes.FailedPrecondition,
+		},
+		{
+			name:  "hint followed by the client",
+			admin: func(conn *grpc.ClientConn) namespaceAdmin { return client.NewAdminClient(conn) },
+			want:  codes.OK,
+		},
+		{
+			name:        "forwarded with --proxy-writes",
+			proxyWrites: true,
+			admin:       func(conn *grpc.ClientConn) namespaceAdmin { return go_kvs.NewAdminClient(conn) },
+			want:        codes.OK,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			follower := startFollower(t, config.ServerConfig{LeaderAddr: leader.addr, ProxyWrites: tt.proxyWrites}, nil)
+			admin := tt.admin(dial(t, follower.addr))
+			ctx := context.Background()
+			ns := string(rune('a'+i)) + "-ns"
+
+			calls := []struct {
+				name string
+				call func() error
+			}{
+				{"CreateNamespace", func() error {
+					_, err := admin.CreateNamespace(ctx, &go_kvs.CreateNamespaceRequest{Name: ns})
+					return err
+				}},
+				{"SetNamespaceQuota", func() error {
+					_, err := admin.SetNamespaceQuota(ctx, &go_kvs.SetNamespaceQuotaRequest{Name: ns, Quota: &go_kvs.NamespaceQuota{MaxKeys: 5}})
+					return err
+				}},
+				{"DropNamespace", func() error {
+					_, err := admin.DropNamespace(ctx, &go_kvs.DropNamespaceRequest{Name: ns})
+					return err
+				}},
+			}
+			for _, c := range calls {
+				err := c.call()
+				if code := status.Code(err); code != tt.want {
+					t.Fatalf("%s: code = %v, want %v (%v)", c.name, code, tt.want, err)
+				}
+				if tt.want == codes.FailedPrecondition {
+					if addr, ok := client.LeaderAddr(err); !ok || addr != leader.addr {
+						t.Errorf("%s: leader hint = %q, %v, want %q", c.name, addr, ok, leader.addr)
+					}
+				}
+				if c.name == "SetNamespaceQuota" && tt.want == codes.OK {
+					info, err := leader.kvs.Namespace(ns)
+					if err != nil || info.Quota.MaxKeys != 5 {
+						t.Errorf("leader's namespace %q = %+v, %v, want max keys 5", ns, info, err)
+					}
+				}
+			}
+			if _, err := follower.kvs.Namespace(ns); err == nil {
+				t.Errorf("namespace %q was created on the follower itself", ns)
+			}
+		})
+	}
+}
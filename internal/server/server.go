@@ -3,85 +3,398 @@ package server
 import (
 	"context"
 	"go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/internal/config"
 	"go-kvs/internal/replication"
 	"go-kvs/pkg/kvs"
 	"go-kvs/pkg/kvs/command"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// DefaultReadWaitTimeout bounds how long a Get waits for min_sequence
+// when the client didn't set a deadline of its own.
+const DefaultReadWaitTimeout = 5 * time.Second
+
+// MaxBatchEntries is the most entries a Batch request may carry
+const MaxBatchEntries = 10000
+
+// ReadIndexer confirms leadership and returns the leader's latest sequence
+type ReadIndexer interface {
+	ReadIndex(ctx context.Context) (int64, error)
+}
+
+// SequenceTracker reports the replication sequence applied on this node.
+// Implemented by replication.StreamManager (leader) and follower.StreamClient.
+type SequenceTracker interface {
+	ReadIndexer
+	LastSequence() int64
+	WaitForSequence(ctx context.Context, seq int64) error
+}
+
 type KvsServer struct {
-	kvs       *kvs.Kvs
-	streamMgr *replication.StreamManager
-	isLeader  bool
+	kvs             *kvs.Kvs
+	streamMgr       *replication.StreamManager
+	sequences       SequenceTracker
+	isLeader        bool
+	leaderAddr      string
+	proxyWrites     bool
+	leader          *leaderForwarder
+	readWaitTimeout time.Duration
+	rejectIfLagging FollowerStatus        // set with --reject-lagging-reads
+	filter          replication.KeyFilter // keys held by a partial replica
+	changes         *cdc.Hub              // change stream for Watch, nil if disabled
+	limits          limits                // key and value sizes, key count
+	writeMu         sync.Mutex            // keeps WAL order and sequence order identical
 	go_kvs.UnimplementedGoKvsServer
 }
 
-func NewKvsServer(kvs *kvs.Kvs, streamMgr *replication.StreamManager, isLeader bool) *KvsServer {
-	return &KvsServer{
-		kvs:       kvs,
-		streamMgr: streamMgr,
-		isLeader:  isLeader,
+func NewKvsServer(kvs *kvs.Kvs, streamMgr *replication.StreamManager, sequences SequenceTracker, cfg *config.ServerConfig) *KvsServer {
+	k := &KvsServer{
+		kvs:             kvs,
+		streamMgr:       streamMgr,
+		sequences:       sequences,
+		isLeader:        cfg.IsLeader,
+		leaderAddr:      cfg.LeaderAddr,
+		proxyWrites:     cfg.ProxyWrites,
+		readWaitTimeout: cfg.ReadWaitTimeout,
+		filter:          replication.KeyFilter{Include: cfg.IncludePrefixes, Exclude: cfg.ExcludePrefixes},
+		limits:          limits{maxKeySize: cfg.MaxKeySize, maxValueSize: cfg.MaxValueSize, maxKeys: cfg.MaxKeys},
+	}
+	if k.readWaitTimeout <= 0 {
+		k.readWaitTimeout = DefaultReadWaitTimeout
+	}
+	if !k.isLeader && k.proxyWrites {
+		k.leader = newLeaderForwarder(cfg.LeaderAddr)
 	}
+	if follower, ok := sequences.(FollowerStatus); ok && cfg.RejectLaggingReads {
+		k.rejectIfLagging = follower
+	}
+	return k
 }
 
-func (k *KvsServer) Get(ctx context.Context, request *go_kvs.KeyRequest) (*go_kvs.ValResponse, error) {
-	res, err := k.kvs.Get(request.Key)
-	if err != nil {
+func (k *KvsServer) Get(ctx context.Context, request *go_kvs.GetRequest) (*go_kvs.ValResponse, error) {
+	if !k.filter.Match(request.Key) {
+		return nil, status.Errorf(codes.FailedPrecondition, "key %q is not replicated to this node (%s)", request.Key, k.filter)
+	}
+	if err := k.prepareRead(ctx, request.Consistency, request.MinSequence); err != nil {
 		return nil, err
 	}
 
+	res, err := k.kvs.GetIn(request.Namespace, request.Key)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+
 	return &go_kvs.ValResponse{
 		Value: res,
 	}, nil
 }
 
-func (k *KvsServer) Set(ctx context.Context, request *go_kvs.KeyValRequest) (*go_kvs.EmptyResponse, error) {
+func (k *KvsServer) Set(ctx context.Context, request *go_kvs.KeyValRequest) (*go_kvs.WriteResponse, error) {
 	// Check if this node is the leader
 	if !k.isLeader {
-		return nil, status.Error(codes.FailedPrecondition, "not leader")
+		if k.proxyWrites {
+			return k.leader.Set(ctx, request)
+		}
+		return nil, notLeaderError(k.leaderAddr)
+	}
+	if err := k.checkSize(request.Key, request.Val); err != nil {
+		return nil, err
 	}
 
-	// Apply to local KVS first
-	err := k.kvs.Set(request.Key, request.Val)
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	// Previous value for the change stream
+	oldVal, existed, err := k.kvs.LookupIn(request.Namespace, request.Key)
 	if err != nil {
+		return nil, statusErr(err)
+	}
+
+	newKeys := 1
+	size := int64(len(request.Key) + len(request.Val))
+	growth := size
+	if existed {
+		newKeys = 0
+		growth -= int64(len(request.Key) + len(oldVal))
+	}
+	if err := k.checkQuota(request.Namespace, newKeys, growth, size); err != nil {
 		return nil, err
 	}
 
-	// Broadcast to followers via streams
+	seq, err := k.setLocked(request.Namespace, request.Key, request.Val, oldVal, existed)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return &go_kvs.WriteResponse{Sequence: seq}, nil
+}
+
+// setLocked writes key in the namespace ns, broadcasts it to followers and
+// publishes it to watchers. The caller holds writeMu and has looked up the
+// previous value.
+func (k *KvsServer) setLocked(ns, key, val, oldVal string, existed bool) (int64, error) {
+	cmd := command.New("set", key, val)
+	cmd.Namespace = ns
+	seq, commitTime, err := k.commitLocked(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	// Publish to watchers
+	k.publishChange(cdc.Event{
+		Namespace:   ns,
+		Key:         key,
+		OldValue:    oldVal,
+		NewValue:    val,
+		HasOldValue: existed,
+		Op:          cdc.OpSet,
+		Sequence:    seq,
+		Time:        commitTime,
+	})
+
+	return seq, nil
+}
+
+// commitLocked writes cmd to the local KVS with the sequence it will be
+// broadcast with, then broadcasts it to followers with the bytes as stored
+// in the WAL. The caller holds writeMu.
+func (k *KvsServer) commitLocked(cmd command.Cmd) (int64, time.Time, error) {
+	var seq int64
 	if k.streamMgr != nil {
-		cmd := command.New("set", request.Key, request.Val)
-		cmdBytes, _ := cmd.Serialize()
-		k.streamMgr.Broadcast(cmdBytes)
+		seq = k.streamMgr.NextSequence()
+	}
+	commitTime := time.Now()
+	cmd.Seq, cmd.Time = seq, commitTime.UnixNano()
+	data, codec, err := k.kvs.Write(cmd)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if k.streamMgr != nil {
+		seq = k.streamMgr.Broadcast(data, replication.CommandCompression(codec), commitTime)
+	}
+	return seq, commitTime, nil
+}
+
+// Batch writes request's entries in order. Holding writeMu for the whole
+// batch keeps FAIL_EXISTING's check and the writes together.
+func (k *KvsServer) Batch(ctx context.Context, request *go_kvs.BatchRequest) (*go_kvs.BatchResponse, error) {
+	if !k.isLeader {
+		if k.proxyWrites {
+			return k.leader.Batch(ctx, request)
+		}
+		return nil, notLeaderError(k.leaderAddr)
+	}
+	if len(request.Entries) > MaxBatchEntries {
+		return nil, status.Errorf(codes.InvalidArgument, "batch has %d entries, at most %d allowed", len(request.Entries), MaxBatchEntries)
+	}
+	for _, entry := range request.Entries {
+		if err := k.checkSize(entry.Key, entry.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	// Check the whole batch against existing keys and the quotas before
+	// writing any of it
+	var size, growth int64
+	added := make(map[string]bool)
+	seen := make(map[string]bool, len(request.Entries))
+	for _, entry := range request.Entries {
+		if seen[entry.Key] {
+			return nil, status.Errorf(codes.InvalidArgument, "key %q appears more than once in the batch", entry.Key)
+		}
+		seen[entry.Key] = true
+
+		oldVal, existed, err := k.kvs.LookupIn(request.Namespace, entry.Key)
+		if err != nil {
+			return nil, statusErr(err)
+		}
+		if existed && request.Mode == go_kvs.BatchMode_FAIL_EXISTING {
+			return nil, status.Errorf(codes.AlreadyExists, "key %q already exists", entry.Key)
+		}
+		if existed && request.Mode == go_kvs.BatchMode_SKIP_EXISTING {
+			continue
+		}
+		if !existed {
+			added[entry.Key] = true
+		} else {
+			growth -= int64(len(entry.Key) + len(oldVal))
+		}
+		size += int64(len(entry.Key) + len(entry.Value))
+	}
+	if err := k.checkQuota(request.Namespace, len(added), growth+size, size); err != nil {
+		return nil, err
 	}
 
-	return &go_kvs.EmptyResponse{}, nil
+	res := &go_kvs.BatchResponse{}
+	for _, entry := range request.Entries {
+		oldVal, existed, err := k.kvs.LookupIn(request.Namespace, entry.Key)
+		if err != nil {
+			return nil, statusErr(err)
+		}
+		if existed && request.Mode == go_kvs.BatchMode_SKIP_EXISTING {
+			res.Skipped++
+			continue
+		}
+
+		seq, err := k.setLocked(request.Namespace, entry.Key, entry.Value, oldVal, existed)
+		if err != nil {
+			code := status.Code(statusErr(err))
+			// The entries before this one stay written: say how many
+			st := status.Newf(code, "writing %q after %d keys: %v", entry.Key, res.Written, err)
+			if detailed, detailErr := st.WithDetails(res); detailErr == nil {
+				st = detailed
+			}
+			return nil, st.Err()
+		}
+		res.Sequence = seq
+		res.Written++
+	}
+	return res, nil
 }
 
-func (k *KvsServer) Del(ctx context.Context, request *go_kvs.KeyRequest) (*go_kvs.EmptyResponse, error) {
+func (k *KvsServer) Del(ctx context.Context, request *go_kvs.KeyRequest) (*go_kvs.WriteResponse, error) {
 	// Check if this node is the leader
 	if !k.isLeader {
-		return nil, status.Error(codes.FailedPrecondition, "not leader")
+		if k.proxyWrites {
+			return k.leader.Del(ctx, request)
+		}
+		return nil, notLeaderError(k.leaderAddr)
 	}
 
-	// Apply to local KVS first
-	err := k.kvs.Del(request.Key)
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+
+	// Previous value for the change stream
+	oldVal, existed, err := k.kvs.LookupIn(request.Namespace, request.Key)
 	if err != nil {
-		return nil, err
+		return nil, statusErr(err)
 	}
 
-	// Broadcast to followers via streams
-	if k.streamMgr != nil {
-		cmd := command.New("del", request.Key, "")
-		cmdBytes, _ := cmd.Serialize()
-		k.streamMgr.Broadcast(cmdBytes)
+	cmd := command.New("del", request.Key, "")
+	cmd.Namespace = request.Namespace
+	seq, commitTime, err := k.commitLocked(cmd)
+	if err != nil {
+		return nil, statusErr(err)
 	}
 
-	return &go_kvs.EmptyResponse{}, nil
+	// Publish to watchers
+	k.publishChange(cdc.Event{
+		Namespace:   request.Namespace,
+		Key:         request.Key,
+		OldValue:    oldVal,
+		NewValue:    "",
+		HasOldValue: existed,
+		Op:          cdc.OpDelete,
+		Sequence:    seq,
+		Time:        commitTime,
+	})
+
+	return &go_kvs.WriteResponse{Sequence: seq}, nil
 }
 
-func (k *KvsServer) Keys(ctx context.Context, request *go_kvs.EmptyRequest) (*go_kvs.KeysResponse, error) {
-	keys := k.kvs.Keys()
+func (k *KvsServer) Keys(ctx context.Context, request *go_kvs.KeysRequest) (*go_kvs.KeysResponse, error) {
+	if !k.filter.Covers("") {
+		return nil, status.Errorf(codes.FailedPrecondition, "this node holds only part of the keys (%s), list them on the leader", k.filter)
+	}
+	if err := k.prepareRead(ctx, request.Consistency, request.MinSequence); err != nil {
+		return nil, err
+	}
+
+	keys, err := k.kvs.KeysIn(request.Namespace)
+	if err != nil {
+		return nil, statusErr(err)
+	}
 	return &go_kvs.KeysResponse{Keys: keys}, nil
 }
+
+func (k *KvsServer) Scan(request *go_kvs.ScanRequest, stream go_kvs.GoKvs_ScanServer) error {
+	if !k.filter.Covers(request.Prefix) {
+		return status.Errorf(codes.FailedPrecondition, "prefix %q is not fully replicated to this node (%s)", request.Prefix, k.filter)
+	}
+	if err := k.prepareRead(stream.Context(), request.Consistency, request.MinSequence); err != nil {
+		return err
+	}
+
+	return statusErr(k.kvs.ScanIn(request.Namespace, request.Prefix, func(key, val string) error {
+		return stream.Send(&go_kvs.KeyValue{Key: key, Value: val})
+	}))
+}
+
+// prepareRead blocks until this node's state satisfies the requested consistency:
+//   - STALE: serve immediately
+//   - SESSION: wait for min_sequence (read-your-writes); rejected on a
+//     lagging follower with --reject-lagging-reads
+//   - LINEARIZABLE: confirm leadership via the leader lease, then wait for
+//     the leader's latest sequence (ReadIndex)
+func (k *KvsServer) prepareRead(ctx context.Context, consistency go_kvs.Consistency, minSeq int64) error {
+	switch consistency {
+	case go_kvs.Consistency_STALE:
+		return nil
+	case go_kvs.Consistency_LINEARIZABLE:
+		if k.sequences == nil {
+			return status.Error(codes.Unavailable, "linearizable reads not available on this node")
+		}
+		readIndex, err := k.sequences.ReadIndex(ctx)
+		if err != nil {
+			return err
+		}
+		return k.waitForSequence(ctx, readIndex)
+	default:
+		// SESSION, or unspecified with a token
+		if consistency != go_kvs.Consistency_SESSION && minSeq <= 0 {
+			return nil
+		}
+		if k.rejectIfLagging != nil && k.rejectIfLagging.Lagging() {
+			return status.Error(codes.Unavailable, "replica is lagging, read from another node")
+		}
+		if minSeq > 0 {
+			return k.waitForSequence(ctx, minSeq)
+		}
+		return nil
+	}
+}
+
+// waitForSequence blocks until this node has applied seq, bounded by the
+// request deadline or readWaitTimeout, whichever comes first.
+func (k *KvsServer) waitForSequence(ctx context.Context, seq int64) error {
+	if k.sequences == nil {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, k.readWaitTimeout)
+	defer cancel()
+
+	if err := k.sequences.WaitForSequence(waitCtx, seq); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Errorf(codes.Unavailable, "node has applied seq=%d, timed out waiting for seq=%d", k.sequences.LastSequence(), seq)
+	}
+	return nil
+}
+
+// ConsistentSnapshot captures the namespace ns of the store together with
+// the sequence it reflects. Writes are held off only while the namespace's
+// key offsets are copied.
+func (k *KvsServer) ConsistentSnapshot(ns string) (*kvs.Snapshot, int64, error) {
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	snap, err := k.kvs.SnapshotIn(ns, "")
+	return snap, k.sequences.LastSequence(), err
+}
+
+// ConsistentSnapshotAll captures every namespace of the store at the same
+// sequence, like ConsistentSnapshot
+func (k *KvsServer) ConsistentSnapshotAll() ([]*kvs.Snapshot, int64) {
+	k.writeMu.Lock()
+	defer k.writeMu.Unlock()
+	return k.kvs.SnapshotAll(), k.sequences.LastSequence()
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/internal/client"
+	"go-kvs/internal/config"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/follower"
+	"go-kvs/internal/replication"
+	"go-kvs/internal/server/wal"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testNode is a KvsServer and AdminServer over a store in a temp dir,
+// served on a local port
+type testNode struct {
+	addr      string
+	lis       net.Listener
+	dir       string // the store's WAL
+	kvs       *kvs.Kvs
+	streamMgr *replication.StreamManager // nil on followers that don't relay
+	replica   *follower.StreamClient     // nil on the leader and on followers started by startFollower
+	server    *KvsServer
+	admin     *AdminServer
+	changes   *cdc.Hub
+	identity  *datadir.Identity // with cfg.DataDir only
+}
+
+// startLeader serves a leader configured by cfg. Its replication service
+// is registered too, so followers and ReadIndex calls can reach it.
+func startLeader(t *testing.T, cfg config.ServerConfig) *testNode {
+	t.Helper()
+	cfg.IsLeader = true
+	n := &testNode{streamMgr: replication.NewStreamManager(cfg.LeaseDuration, cfg.FollowerAddrs)}
+	t.Cleanup(n.streamMgr.Close)
+	n.open(t, &cfg)
+	n.serve(t, &cfg, n.streamMgr)
+	return n
+}
+
+// startFollower serves a follower of leaderAddr that applies nothing by
+// itself; tests write to its store directly. sequences stands in for the
+// stream client and may be nil.
+func startFollower(t *testing.T, cfg config.ServerConfig, sequences SequenceTracker) *testNode {
+	t.Helper()
+	cfg.IsLeader = false
+	n := &testNode{}
+	n.open(t, &cfg)
+	n.serve(t, &cfg, sequences)
+	return n
+}
+
+// startReplica serves a follower that replicates from cfg.LeaderAddr, and
+// with cfg.ServeReplication relays to its own followers
+func startReplica(t *testing.T, cfg config.ServerConfig) *testNode {
+	t.Helper()
+	cfg.IsLeader = false
+	n := &testNode{}
+	n.open(t, &cfg)
+	n.replica = follower.NewStreamClient(n.addr, n.addr, cfg.LeaderAddr, n.kvs)
+	n.replica.SetChangeHub(n.changes)
+	if n.identity != nil {
+		n.replica.SetIdentity(n.identity)
+		n.replica.SetJoinCluster(cfg.JoinCluster)
+	}
+	if cfg.ServeReplication {
+		n.streamMgr = replication.NewStreamManager(cfg.LeaseDuration, nil)
+		t.Cleanup(n.streamMgr.Close)
+		n.replica.SetRelay(n.streamMgr)
+	}
+	n.serve(t, &cfg, n.replica)
+
+	go n.replica.ConnectToLeader()
+	t.Cleanup(n.replica.Stop)
+	return n
+}
+
+// open creates the node's store and listener. With cfg.DataDir, the store
+// and the node's identity are kept there, and cfg.Bootstrap creates a
+// cluster for a leader. cfg.WALCompression applies to the store.
+func (n *testNode) open(t *testing.T, cfg *config.ServerConfig) {
+	t.Helper()
+	dir := t.TempDir()
+	if cfg.DataDir != "" {
+		dataDir, err := datadir.Open(cfg.DataDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dataDir.Close() })
+		if n.identity, err = dataDir.Identity(); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Bootstrap && n.identity.ClusterID() == "" {
+			if err := n.identity.SetClusterID(datadir.NewID()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dir = dataDir.WALDir()
+	}
+	store, err := kvs.New(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if cfg.WALCompression != "" {
+		codec, err := wal.ParseCodec(cfg.WALCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.SetCompression(codec, cfg.WALCompressMin)
+	}
+	n.dir, n.kvs = dir, store
+	n.changes = cdc.NewHub(cfg.ChangeBufferSize)
+
+	n.lis, err = net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.addr = n.lis.Addr().String()
+	cfg.Address, cfg.AdvertiseAddr = n.addr, n.addr
+}
+
+// serve registers the node's services and serves them until the test ends
+func (n *testNode) serve(t *testing.T, cfg *config.ServerConfig, sequences SequenceTracker) {
+	t.Helper()
+	n.server = NewKvsServer(n.kvs, n.streamMgr, sequences, cfg)
+	n.server.SetChangeHub(n.changes)
+	var followerStatus FollowerStatus
+	if n.replica != nil {
+		followerStatus = n.replica
+	}
+	n.admin = NewAdminServer(n.streamMgr, followerStatus, cfg)
+	n.admin.SetNamespaces(n.server)
+	if n.replica != nil {
+		n.admin.SetSnapshotSource(n.replica)
+	} else {
+		n.admin.SetSnapshotSource(n.server)
+	}
+	if n.identity != nil {
+		n.admin.SetIdentity(n.identity)
+	}
+
+	grpcServer := grpc.NewServer()
+	go_kvs.RegisterGoKvsServer(grpcServer, n.server)
+	go_kvs.RegisterAdminServer(grpcServer, n.admin)
+	var stream *LeaderStreamServer
+	switch {
+	case n.replica != nil && n.streamMgr != nil:
+		stream = NewRelayStreamServer(n.streamMgr, n.replica, n.replica)
+	case n.streamMgr != nil:
+		stream = NewLeaderStreamServer(n.streamMgr, n.server)
+	}
+	if stream != nil {
+		if n.identity != nil {
+			stream.SetIdentity(n.identity)
+		}
+		go_kvs.RegisterReplicationServer(grpcServer, stream)
+	}
+	go grpcServer.Serve(n.lis)
+	t.Cleanup(grpcServer.Stop)
+}
+
+// waitForSequence waits until the node has applied seq
+func (n *testNode) waitForSequence(t *testing.T, seq int64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.replica.WaitForSequence(ctx, seq); err != nil {
+		t.Fatalf("%s applied seq=%d, want %d: %v", n.addr, n.replica.LastSequence(), seq, err)
+	}
+}
+
+// sequences stands in for a follower's stream client
+type sequences struct {
+	applied   *replication.SequenceWaiter
+	readIndex int64 // returned by ReadIndex, or readErr
+	readErr   error
+	lagging   bool
+}
+
+func newSequences(applied int64) *sequences {
+	return &sequences{applied: replication.NewSequenceWaiter(applied)}
+}
+
+func (s *sequences) ReadIndex(ctx context.Context) (int64, error) {
+	return s.readIndex, s.readErr
+}
+
+func (s *sequences) LastSequence() int64 {
+	return s.applied.Load()
+}
+
+func (s *sequences) WaitForSequence(ctx context.Context, seq int64) error {
+	return s.applied.Wait(ctx, seq)
+}
+
+func (s *sequences) Lag() replication.Lag {
+	return replication.Lag{}
+}
+
+func (s *sequences) Lagging() bool {
+	return s.lagging
+}
+
+// dial connects to addr and closes the connection when the test ends
+func dial(t *testing.T, addr string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestPartialReplicaReads(t *testing.T) {
+	cfg := config.ServerConfig{IncludePrefixes: []string{"tenant42:"}, ExcludePrefixes: []string{"tenant42:tmp:"}}
+	follower := startFollower(t, cfg, nil)
+	for _, key := range []string{"tenant42:a1", "tenant42:a2", "tenant42:b"} {
+		if err := follower.kvs.Set(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvsClient := go_kvs.NewGoKvsClient(dial(t, follower.addr))
+	ctx := context.Background()
+
+	scan := func(prefix string) ([]string, error) {
+		stream, err := kvsClient.Scan(ctx, &go_kvs.ScanRequest{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for {
+			kv, err := stream.Recv()
+			if err == io.EOF {
+				return keys, nil
+			}
+			if err != nil {
+				return keys, err
+			}
+			keys = append(keys, kv.Key)
+		}
+	}
+
+	tests := []struct {
+		name string
+		read func() ([]string, error)
+		want []string
+		code codes.Code
+	}{
+		{"get held key", func() ([]string, error) {
+			res, err := kvsClient.Get(ctx, &go_kvs.GetRequest{Key: "tenant42:b"})
+			return []string{res.GetValue()}, err
+		}, []string{"v"}, codes.OK},
+		{"get filtered key", func() ([]string, error) {
+			_, err := kvsClient.Get(ctx, &go_kvs.GetRequest{Key: "tenant7:a"})
+			return nil, err
+		}, nil, codes.FailedPrecondition},
+		{"keys", func() ([]string, error) {
+			res, err := kvsClient.Keys(ctx, &go_kvs.KeysRequest{})
+			return res.GetKeys(), err
+		}, nil, codes.FailedPrecondition},
+		{"scan covered prefix", func() ([]string, error) { return scan("tenant42:a") }, []string{"tenant42:a1", "tenant42:a2"}, codes.OK},
+		{"scan everything", func() ([]string, error) { return scan("") }, nil, codes.FailedPrecondition},
+		{"scan prefix with excluded keys", func() ([]string, error) { return scan("tenant42:") }, nil, codes.FailedPrecondition},
+		{"scan filtered prefix", func() ([]string, error) { return scan("tenant7:") }, nil, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.read()
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionReads(t *testing.T) {
+	tests := []struct {
+		name        string
+		consistency go_kvs.Consistency
+		minSeq      int64
+		applied     int64
+		appliedSoon int64 // applied while the read waits
+		lagging     bool
+		want        codes.Code
+	}{
+		{name: "no token", applied: 1, want: codes.OK},
+		{name: "token applied", minSeq: 5, applied: 5, want: codes.OK},
+		{name: "token applied while waiting", minSeq: 5, applied: 4, appliedSoon: 5, want: codes.OK},
+		{name: "token not applied in time", minSeq: 5, applied: 4, want: codes.Unavailable},
+		{name: "stale ignores the token", consistency: go_kvs.Consistency_STALE, minSeq: 5, applied: 4, want: codes.OK},
+		{name: "session without a token", consistency: go_kvs.Consistency_SESSION, applied: 4, want: codes.OK},
+		{name: "lagging with a token", minSeq: 5, applied: 5, lagging: true, want: codes.Unavailable},
+		{name: "lagging session read", consistency: go_kvs.Consistency_SESSION, applied: 4, lagging: true, want: codes.Unavailable},
+		{name: "lagging stale read", consistency: go_kvs.Consistency_STALE, applied: 4, lagging: true, want: codes.OK},
+		{name: "lagging without a token", applied: 4, lagging: true, want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seqs := newSequences(tt.applied)
+			seqs.lagging = tt.lagging
+			cfg := config.ServerConfig{ReadWaitTimeout: 100 * time.Millisecond, RejectLaggingReads: true}
+			follower := startFollower(t, cfg, seqs)
+			if err := follower.kvs.Set("a", "v"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.appliedSoon > 0 {
+				time.AfterFunc(20*time.Millisecond, func() { seqs.applied.Advance(tt.appliedSoon) })
+			}
+
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, follower.addr))
+			_, err := kvsClient.Get(context.Background(), &go_kvs.GetRequest{Key: "a", MinSequence: tt.minSeq, Consistency: tt.consistency})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("code = %v, want %v (%v)", code, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSessionReadsFollowClientWrites(t *testing.T) {
+	leader := startLeader(t, config.ServerConfig{})
+	seqs := newSequences(0)
+	follower := startFollower(t, config.ServerConfig{LeaderAddr: leader.addr, ReadWaitTimeout: 100 * time.Millisecond}, seqs)
+	kvsClient := client.NewKvsClient(dial(t, follower.addr))
+	ctx := context.Background()
+
+	// The write is redirected to the leader; the follower hasn't applied it
+	if _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvsClient.Get(ctx, &go_kvs.GetRequest{Key: "a"}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("read before the write was applied: %v, want Unavailable", err)
+	}
+
+	if err := follower.kvs.SetAt("a", "v", 1); err != nil {
+		t.Fatal(err)
+	}
+	seqs.applied.Advance(1)
+	res, err := kvsClient.Get(ctx, &go_kvs.GetRequest{Key: "a"})
+	if err != nil || res.Value != "v" {
+		t.Fatalf("read after the write was applied = %v, %v, want v", res, err)
+	}
+}
+
+func TestLinearizableReads(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "leadership not confirmed")
+
+	tests := []struct {
+		name      string
+		leader    *config.ServerConfig // serve the read on a leader with this config, else on a follower
+		readIndex int64
+		readErr   error
+		applied   int64
+		want      codes.Code
+	}{
+		{name: "leader without followers", leader: &config.ServerConfig{LeaseDuration: time.Second}, want: codes.OK},
+		{name: "leader without a quorum", leader: &config.ServerConfig{LeaseDuration: time.Second, FollowerAddrs: []string{"f1:1", "f2:1"}}, want: codes.Unavailable},
+		{name: "follower has applied the read index", readIndex: 5, applied: 5, want: codes.OK},
+		{name: "follower behind the read index", readIndex: 5, applied: 4, want: codes.Unavailable},
+		{name: "leader unreachable", readErr: unavailable, applied: 5, want: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var node *testNode
+			if tt.leader != nil {
+				node = startLeader(t, *tt.leader)
+			} else {
+				seqs := newSequences(tt.applied)
+				seqs.readIndex, seqs.readErr = tt.readIndex, tt.readErr
+				node = startFollower(t, config.ServerConfig{ReadWaitTimeout: 100 * time.Millisecond}, seqs)
+			}
+			if err := node.kvs.Set("a", "v"); err != nil {
+				t.Fatal(err)
+			}
+			conn := dial(t, node.addr)
+			ctx := context.Background()
+
+			_, err := go_kvs.NewGoKvsClient(conn).Get(ctx, &go_kvs.GetRequest{Key: "a", Consistency: go_kvs.Consistency_LINEARIZABLE})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("Get: code = %v, want %v (%v)", code, tt.want, err)
+			}
+
+			// Followers ask their upstream node's replication service
+			if node.streamMgr != nil {
+				_, err := go_kvs.NewReplicationClient(conn).ReadIndex(ctx, &go_kvs.ReadIndexRequest{})
+				if code := status.Code(err); code != tt.want {
+					t.Errorf("ReadIndex: code = %v, want %v (%v)", code, tt.want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	entries := func(keys ...string) []*go_kvs.KeyValue {
+		var res []*go_kvs.KeyValue
+		for _, key := range keys {
+			res = append(res, &go_kvs.KeyValue{Key: key, Value: "new"})
+		}
+		return res
+	}
+	tooMany := make([]*go_kvs.KeyValue, MaxBatchEntries+1)
+	for i := range tooMany {
+		tooMany[i] = &go_kvs.KeyValue{Key: fmt.Sprintf("k%d", i)}
+	}
+
+	tests := []struct {
+		name        string
+		mode        go_kvs.BatchMode
+		namespace   string // with a quota of 3 keys
+		entries     []*go_kvs.KeyValue
+		want        codes.Code
+		wantWritten int32
+		wantSkipped int32
+		wantVals    map[string]string // "" = not set
+	}{
+		{name: "new keys", entries: entries("b", "c"), wantWritten: 2, wantVals: map[string]string{"a": "old", "b": "new", "c": "new"}},
+		{name: "existing key fails the batch", entries: entries("b", "a"), want: codes.AlreadyExists, wantVals: map[string]string{"a": "old", "b": ""}},
+		{name: "existing key skipped", mode: go_kvs.BatchMode_SKIP_EXISTING, entries: entries("a", "b"), wantWritten: 1, wantSkipped: 1, wantVals: map[string]string{"a": "old", "b": "new"}},
+		{name: "existing key overwritten", mode: go_kvs.BatchMode_OVERWRITE, entries: entries("a", "b"), wantWritten: 2, wantVals: map[string]string{"a": "new", "b": "new"}},
+		{name: "repeated key", mode: go_kvs.BatchMode_OVERWRITE, entries: entries("b", "b"), want: codes.InvalidArgument, wantVals: map[string]string{"b": ""}},
+		{name: "too many entries", entries: tooMany, want: codes.InvalidArgument},
+		{name: "within the namespace quota", namespace: "t1", entries: entries("b", "c"), wantWritten: 2, wantVals: map[string]string{"b": "new", "c": "new"}},
+		{name: "over the namespace quota", namespace: "t1", entries: entries("b", "c", "d"), want: codes.ResourceExhausted, wantVals: map[string]string{"b": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			if _, err := leader.server.CreateNamespace("t1", kvs.Quota{MaxKeys: 3}); err != nil {
+				t.Fatal(err)
+			}
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx := context.Background()
+			if _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Namespace: tt.namespace, Key: "a", Val: "old"}); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := kvsClient.Batch(ctx, &go_kvs.BatchRequest{Namespace: tt.namespace, Entries: tt.entries, Mode: tt.mode})
+			if code := status.Code(err); code != tt.want {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.want, err)
+			}
+			if err == nil {
+				if res.Written != tt.wantWritten || res.Skipped != tt.wantSkipped || res.Sequence != leader.kvs.LastSequence() {
+					t.Errorf("response %+v, want written=%d skipped=%d sequence=%d", res, tt.wantWritten, tt.wantSkipped, leader.kvs.LastSequence())
+				}
+			}
+			for key, want := range tt.wantVals {
+				if got, _, _ := leader.kvs.LookupIn(tt.namespace, key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/config"
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/snapshot"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string                          // "leader", "replica" or "partial"
+		restore func(t *testing.T, path string) // prepares the data directory restored into
+		want    codes.Code
+		wantErr bool // from Restore
+	}{
+		{name: "from the leader", from: "leader"},
+		{name: "from a follower", from: "replica"},
+		{name: "from a partial replica", from: "partial", want: codes.FailedPrecondition},
+		{
+			name: "into another cluster's data directory",
+			from: "leader",
+			restore: func(t *testing.T, path string) {
+				dataDir, err := datadir.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer dataDir.Close()
+				identity, err := dataDir.Identity()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := identity.SetClusterID("other"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "into a data directory with data",
+			from: "leader",
+			restore: func(t *testing.T, path string) {
+				dataDir, err := datadir.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer dataDir.Close()
+				store, err := kvs.New(dataDir.WALDir(), 0, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer store.Close()
+				if err := store.Set("old", "v"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{DataDir: t.TempDir(), Bootstrap: true})
+			if _, err := leader.server.CreateNamespace("t1", kvs.Quota{MaxKeys: 10}); err != nil {
+				t.Fatal(err)
+			}
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx := context.Background()
+			var last int64
+			for _, kv := range [][3]string{{"", "a", "1"}, {"", "b", "2"}, {"t1", "a", "3"}} {
+				res, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Namespace: kv[0], Key: kv[1], Val: kv[2]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				last = res.Sequence
+			}
+
+			source := leader
+			switch tt.from {
+			case "replica":
+				source = startReplica(t, config.ServerConfig{LeaderAddr: leader.addr, DataDir: t.TempDir()})
+				source.waitForSequence(t, last)
+			case "partial":
+				source = startFollower(t, config.ServerConfig{LeaderAddr: leader.addr, IncludePrefixes: []string{"a"}}, nil)
+			}
+
+			// Back up over the Snapshot RPC
+			stream, err := go_kvs.NewAdminClient(dial(t, source.addr)).Snapshot(ctx, &go_kvs.SnapshotRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			backup := filepath.Join(t.TempDir(), "backup.snap")
+			f, err := os.Create(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				chunk, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if code := status.Code(err); code != tt.want {
+						t.Fatalf("code = %v, want %v (%v)", code, tt.want, err)
+					}
+					return
+				}
+				f.Write(chunk.Data)
+			}
+			f.Close()
+			if tt.want != codes.OK {
+				t.Fatalf("snapshot served, want %v", tt.want)
+			}
+
+			// Restore it offline into a new node's data directory
+			path := t.TempDir()
+			if tt.restore != nil {
+				tt.restore(t, path)
+			}
+			dataDir, err := datadir.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dataDir.Close()
+			meta, err := snapshot.Restore(backup, dataDir, 0, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restore: %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if meta.Sequence != last || meta.ClusterID != leader.identity.ClusterID() {
+				t.Errorf("snapshot at seq=%d of cluster %q, want seq=%d of %q", meta.Sequence, meta.ClusterID, last, leader.identity.ClusterID())
+			}
+			identity, err := dataDir.Identity()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.ClusterID() != leader.identity.ClusterID() {
+				t.Errorf("restored node's cluster = %q, want the leader's", identity.ClusterID())
+			}
+
+			store, err := kvs.New(dataDir.WALDir(), 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if seq := store.LastSequence(); seq != last {
+				t.Errorf("restored seq = %d, want %d", seq, last)
+			}
+			got := map[string]string{}
+			for _, ns := range []string{kvs.DefaultNamespace, "t1"} {
+				keys, err := store.KeysIn(ns)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, key := range keys {
+					got[ns+"/"+key], _, _ = store.LookupIn(ns, key)
+				}
+			}
+			if want := map[string]string{"/a": "1", "/b": "2", "t1/a": "3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("restored %v, want %v", got, want)
+			}
+			if info, err := store.Namespace("t1"); err != nil || info.Quota.MaxKeys != 10 {
+				t.Errorf("restored namespace t1 = %+v, %v, want a quota of 10 keys", info, err)
+			}
+		})
+	}
+}
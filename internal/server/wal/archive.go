@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetArchiveDir makes the log copy every sealed segment into dir, for
+// point-in-time recovery. Sealed segments that are missing from dir, or
+// were only partly archived, are copied in the background right away.
+func (w *WriteAheadLog) SetArchiveDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if own, err := filepath.Abs(w.dir); err != nil || own == abs {
+		return fmt.Errorf("wal: archive directory must not be the log's own directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.archiveDir = dir
+	for _, seg := range w.segments[:len(w.segments)-1] {
+		info, err := os.Stat(filepath.Join(dir, filepath.Base(seg.path)))
+		if err == nil && info.Size() == seg.size {
+			continue
+		}
+		w.archive(seg.path)
+	}
+	return nil
+}
+
+// archive copies the segment file at path into the archive directory in
+// the background. Close waits for running copies.
+func (w *WriteAheadLog) archive(path string) {
+	w.archiving.Add(1)
+	go func() {
+		defer w.archiving.Done()
+		if err := archiveSegment(path, w.archiveDir); err != nil {
+			log.Error().Err(err).Msgf("Failed to archive WAL segment %s", filepath.Base(path))
+			return
+		}
+		log.Info().Msgf("Archived WAL segment %s", filepath.Base(path))
+	}()
+}
+
+// archiveSegment copies the segment file at path into dir under the same
+// name. The copy is written next to its final name and renamed, so an
+// archived segment is either complete or absent.
+func archiveSegment(path, dir string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := filepath.Join(dir, filepath.Base(path))
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,92 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is the compression of a record's command
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZstd
+)
+
+// DefaultCompressMinSize is the smallest command that is compressed;
+// smaller ones rarely shrink enough to pay for the work
+const DefaultCompressMinSize = 256
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxRecord))
+)
+
+// ParseCodec parses a codec name as used on the command line
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "none", "":
+		return CodecNone, nil
+	case "snappy":
+		return CodecSnappy, nil
+	case "zstd":
+		return CodecZstd, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q, expected none, snappy or zstd", name)
+	}
+}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// Compress returns cmd compressed with codec
+func Compress(codec Codec, cmd []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return cmd, nil
+	case CodecSnappy:
+		return snappy.Encode(nil, cmd), nil
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(cmd, nil), nil
+	default:
+		return nil, fmt.Errorf("wal: unknown codec %d", codec)
+	}
+}
+
+// Decompress returns the command that data holds compressed with codec
+func Decompress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecSnappy:
+		if n, err := snappy.DecodedLen(data); err != nil || n > maxRecord {
+			return nil, fmt.Errorf("%w: bad snappy data", ErrCorrupt)
+		}
+		cmd, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return cmd, nil
+	case CodecZstd:
+		cmd, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return cmd, nil
+	default:
+		return nil, fmt.Errorf("wal: unknown codec %d", codec)
+	}
+}
@@ -0,0 +1,123 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-kvs/internal/keyring"
+)
+
+// SegmentFile reads the records of one segment file without modifying it,
+// for inspection and repair tools. Offsets are global, like the log's.
+// Records are expected to be read in order: plaintext records after the
+// first encrypted one read so far are rejected.
+type SegmentFile struct {
+	seg      *segment
+	size     int64            // size of the file, including any damaged tail
+	keys     *keyring.Keyring // decrypts encrypted records, may be nil
+	sealedAt int64            // offset of the first encrypted record read, 0 if none
+}
+
+// OpenSegmentFile opens the segment file at path read-only. Its base
+// offset comes from the file name, 0 if the name isn't a segment name.
+// keys decrypts encrypted records; without it, reading one fails.
+func OpenSegmentFile(path string, keys *keyring.Keyring) (*SegmentFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, head); err != nil || string(head) != magic {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w: not a segment file (a log from an older version is converted when the server opens it)", path, ErrCorrupt)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	base, _ := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+	return &SegmentFile{seg: &segment{file: file, path: path, base: base}, size: info.Size(), keys: keys}, nil
+}
+
+// Path returns the file's path
+func (f *SegmentFile) Path() string {
+	return f.seg.path
+}
+
+// Base returns the global offset of the start of the file
+func (f *SegmentFile) Base() int64 {
+	return f.seg.base
+}
+
+// First returns the offset of the first record
+func (f *SegmentFile) First() int64 {
+	return f.seg.base + headerSize
+}
+
+// End returns the offset just past the end of the file
+func (f *SegmentFile) End() int64 {
+	return f.seg.base + f.size
+}
+
+// RecordInfo describes how a record is stored
+type RecordInfo struct {
+	KeyID  uint32 // key the record was encrypted with, 0 if it wasn't
+	Codec  Codec  // compression of the command
+	Stored int    // size of the stored payload
+}
+
+// Read returns the command in the record at offset, how it is stored and
+// the offset of the next record. It returns io.EOF at the end,
+// io.ErrUnexpectedEOF for a record cut short and ErrCorrupt for a bad
+// checksum or compressed data that doesn't decompress. A record that is
+// intact but can't be decrypted returns a keyring error or ErrNoKeyring
+// instead.
+func (f *SegmentFile) Read(offset int64) ([]byte, RecordInfo, int64, error) {
+	payload, flags, next, err := f.seg.read(offset-f.seg.base, f.size)
+	if err != nil {
+		return nil, RecordInfo{}, f.seg.base + next, err
+	}
+	info := RecordInfo{KeyID: keyID(payload, flags), Codec: Codec(flags & codecMask >> codecShift), Stored: len(payload)}
+	cmd, err := decode(payload, flags, offset, f.sealedAt, f.keys)
+	if err == nil && flags&flagEncrypted != 0 && f.sealedAt == 0 {
+		f.sealedAt = offset
+	}
+	return cmd, info, f.seg.base + next, err
+}
+
+// Resync returns the offset of the first valid record after offset, for
+// skipping a damaged record whose length can't be trusted. ok is false if
+// no valid record follows.
+func (f *SegmentFile) Resync(offset int64) (int64, bool) {
+	local, ok := f.seg.resync(offset-f.seg.base, f.size)
+	if !ok {
+		return 0, false
+	}
+	return f.seg.base + local, true
+}
+
+// Close closes the file
+func (f *SegmentFile) Close() error {
+	return f.seg.file.Close()
+}
+
+// SegmentPaths returns the paths of the segment files in dir, in log order
+func SegmentPaths(dir string) ([]string, error) {
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(bases))
+	for i, base := range bases {
+		paths[i] = filepath.Join(dir, SegmentName(base))
+	}
+	return paths, nil
+}
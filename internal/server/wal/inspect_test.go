@@ -0,0 +1,112 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSegmentFile(t *testing.T) {
+	records := [][]byte{[]byte("first"), bytes.Repeat([]byte("second "), 50), []byte("third")}
+
+	tests := []struct {
+		name        string
+		codec       Codec
+		segmentSize int64
+		damage      func(data []byte, offsets []int64) []byte // of the first segment, nil = none
+		want        []string                                  // per record read: its index, or the error
+	}{
+		{name: "intact", want: []string{"0", "1", "2"}},
+		{name: "compressed", codec: CodecZstd, want: []string{"0", "1", "2"}},
+		{name: "segment per record", segmentSize: 64, want: []string{"0", "1", "2"}},
+		{
+			name:   "bad checksum, resynced",
+			damage: func(data []byte, offsets []int64) []byte { data[offsets[1]+recordHead] ^= 0xff; return data },
+			want:   []string{"0", "corrupt", "2"},
+		},
+		{
+			name:   "torn tail",
+			damage: func(data []byte, _ []int64) []byte { return data[:len(data)-2] },
+			want:   []string{"0", "1", "torn"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			offsets := writeLog(t, dir, tt.codec, tt.segmentSize, records)
+			if tt.damage != nil {
+				path := filepath.Join(dir, SegmentName(0))
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, tt.damage(data, offsets), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			index := make(map[int64]int)
+			for i, offset := range offsets {
+				index[offset] = i
+			}
+
+			paths, err := SegmentPaths(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range paths {
+				f, err := OpenSegmentFile(path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for offset := f.First(); offset < f.End(); {
+					cmd, info, next, err := f.Read(offset)
+					switch {
+					case err == io.EOF:
+						next = f.End()
+					case errors.Is(err, ErrCorrupt):
+						got = append(got, "corrupt")
+						var ok bool
+						if next, ok = f.Resync(offset); !ok {
+							next = f.End()
+						}
+					case errors.Is(err, io.ErrUnexpectedEOF):
+						got = append(got, "torn")
+						next = f.End()
+					case err != nil:
+						t.Fatalf("offset %d: %v", offset, err)
+					default:
+						i, ok := index[offset]
+						if !ok || !bytes.Equal(cmd, records[i]) {
+							t.Fatalf("offset %d holds %q, want record at %v", offset, cmd, offsets)
+						}
+						if info.Codec != tt.codec && len(records[i]) > 100 {
+							t.Errorf("record %d stored with codec %v, want %v", i, info.Codec, tt.codec)
+						}
+						got = append(got, strconv.Itoa(i))
+					}
+					offset = next
+				}
+				f.Close()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenSegmentFileNotASegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SegmentName(0))
+	if err := os.WriteFile(path, []byte("not a log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSegmentFile(path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("err = %v, want ErrCorrupt", err)
+	}
+}
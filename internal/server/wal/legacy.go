@@ -0,0 +1,108 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migrateLegacy rewrites a log in the old newline-separated format into
+// framed records. Old records are gob streams, which delimit themselves, so
+// values containing newlines are read correctly.
+func migrateLegacy(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, err := reader.Peek(len(magic))
+	if string(head) == magic {
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	tmpPath := filePath + ".migrate"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+
+	if _, err := out.WriteString(magic); err != nil {
+		return err
+	}
+	seg := &segment{file: out, path: tmpPath, size: headerSize}
+
+	records := 0
+	for {
+		payload, err := readLegacy(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Dropping unreadable legacy WAL tail after %d records", records)
+			break
+		}
+		if _, err := seg.append(payload, 0); err != nil {
+			return err
+		}
+		records++
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	log.Info().Msgf("Migrated legacy WAL %s: %d records", filePath, records)
+	return nil
+}
+
+// readLegacy reads one gob-encoded record and the newline after it
+func readLegacy(reader *bufio.Reader) ([]byte, error) {
+	if _, err := reader.Peek(1); err != nil {
+		return nil, err
+	}
+
+	// The decoder reads exactly one message at a time from a ByteReader
+	recorder := &recordingReader{r: reader}
+	if err := gob.NewDecoder(recorder).DecodeValue(reflect.Value{}); err != nil {
+		return nil, err
+	}
+
+	if b, err := reader.ReadByte(); err != nil || b != '\n' {
+		return nil, fmt.Errorf("missing record separator")
+	}
+	return recorder.buf, nil
+}
+
+// recordingReader keeps a copy of every byte read through it
+type recordingReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+func (r *recordingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
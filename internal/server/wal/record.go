@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go-kvs/internal/keyring"
+)
+
+// Record flags say how a record's payload is encoded:
+//
+//	bit 0     encrypted: key ID (4) | nonce (12) | AES-GCM ciphertext, see keyring
+//	bits 1-2  codec the command is compressed with (none, snappy, zstd)
+//
+// The command is compressed first and then encrypted, with the record's
+// global offset as associated data, so a ciphertext moved to another
+// offset or segment fails to decrypt. A record without flags holds the
+// command as is. Flags apply per record, so a log can mix records written
+// before and after encryption or compression was turned on, under
+// different keys and with different codecs. Once a log has an encrypted
+// record, plaintext records after it are rejected: they can only have been
+// written by someone without the key.
+const (
+	flagEncrypted = 1 << 0
+	codecShift    = 1
+	codecMask     = 3 << codecShift
+
+	knownFlags = flagEncrypted | codecMask
+)
+
+// ErrNoKeyring is returned for an encrypted record when the log has no keyring
+var ErrNoKeyring = errors.New("wal: record is encrypted, no keyfile given")
+
+// ErrPlaintext is returned for a plaintext record after an encrypted one
+var ErrPlaintext = fmt.Errorf("%w: plaintext record after an encrypted one", ErrCorrupt)
+
+// encode returns the stored form of a command compressed with codec, to be
+// written at the global offset, and its flags
+func encode(data []byte, codec Codec, offset int64, keys *keyring.Keyring) ([]byte, byte) {
+	flags := byte(codec) << codecShift
+	if keys == nil {
+		return data, flags
+	}
+	return keys.Seal(data, offsetAAD(offset)), flags | flagEncrypted
+}
+
+// decode returns the command stored in the payload of the record at the
+// global offset. sealedAt is the offset of the log's first encrypted
+// record, 0 if it has none yet.
+func decode(payload []byte, flags byte, offset, sealedAt int64, keys *keyring.Keyring) ([]byte, error) {
+	data := payload
+	if flags&flagEncrypted != 0 {
+		if keys == nil {
+			return nil, ErrNoKeyring
+		}
+		var err error
+		if data, err = keys.Open(payload, offsetAAD(offset)); err != nil {
+			return nil, err
+		}
+	} else if sealedAt > 0 && offset > sealedAt {
+		return nil, ErrPlaintext
+	}
+
+	codec := Codec(flags & codecMask >> codecShift)
+	if codec > CodecZstd {
+		return nil, fmt.Errorf("%w: unknown codec %d", ErrCorrupt, codec)
+	}
+	return Decompress(codec, data)
+}
+
+// offsetAAD binds a record's ciphertext to its global offset
+func offsetAAD(offset int64) []byte {
+	aad := make([]byte, 8)
+	binary.BigEndian.PutUint64(aad, uint64(offset))
+	return aad
+}
+
+// keyID returns the ID of the key a record was encrypted with, 0 if it isn't
+func keyID(payload []byte, flags byte) uint32 {
+	if flags&flagEncrypted == 0 {
+		return 0
+	}
+	return keyring.KeyID(payload)
+}
@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go-kvs/internal/keyring"
+)
+
+// Scan calls fn for every record in the segments in dir, in log order,
+// without modifying them. It is meant for archived logs: the segments must
+// follow each other without a gap, and a torn record at the end of the
+// last segment ends the scan. Encrypted records are decrypted with keys.
+func Scan(dir string, keys *keyring.Keyring, fn func(offset int64, payload []byte) error) error {
+	bases, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("wal: no segments in %s", dir)
+	}
+
+	end := bases[0]
+	var sealedAt int64 // first encrypted record, after which plaintext is rejected
+	for i, base := range bases {
+		path := filepath.Join(dir, SegmentName(base))
+		if base != end {
+			return fmt.Errorf("wal: segment %s doesn't follow the previous one (missing segments?)", path)
+		}
+
+		last := i == len(bases)-1
+		if end, err = scanSegment(path, base, last, keys, &sealedAt, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSegment calls fn for every record in one segment file and returns
+// the global offset where the segment ends. sealedAt tracks the log's first
+// encrypted record across segments.
+func scanSegment(path string, base int64, last bool, keys *keyring.Keyring, sealedAt *int64, fn func(offset int64, payload []byte) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, head); err != nil || string(head) != magic {
+		return 0, fmt.Errorf("%s: %w: not a segment file", path, ErrCorrupt)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	seg := &segment{file: file, path: path, base: base}
+	offset := headerSize
+	for {
+		payload, flags, next, err := seg.read(offset, info.Size())
+		if err == io.EOF {
+			return base + offset, nil
+		}
+		if err == io.ErrUnexpectedEOF && last {
+			return base + offset, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: offset %d: %w", path, base+offset, err)
+		}
+		cmd, err := decode(payload, flags, base+offset, *sealedAt, keys)
+		if err != nil {
+			return 0, fmt.Errorf("%s: offset %d: %w", path, base+offset, err)
+		}
+		if flags&flagEncrypted != 0 && *sealedAt == 0 {
+			*sealedAt = base + offset
+		}
+		if err := fn(base+offset, cmd); err != nil {
+			return 0, err
+		}
+		offset = next
+	}
+}
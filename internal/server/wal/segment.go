@@ -0,0 +1,202 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Segment file layout: an 8-byte magic, then records of
+//
+//	crc32 (4) | length (4) | flags (1) | payload (length)
+//
+// The CRC (Castagnoli) covers length, flags and payload. A record is only
+// valid once all of it is on disk, so a torn write at the tail is detected
+// and dropped on open. Damage with valid records after it isn't a torn
+// write and is never truncated. Flags say how the payload is encoded (see
+// record.go).
+const (
+	magic      = "GOKVSWAL"
+	headerSize = int64(len(magic))
+	recordHead = 9
+	maxRecord  = 1 << 30 // larger lengths can only come from a damaged header
+)
+
+// ErrCorrupt is returned for a record whose checksum or framing is invalid
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is one file of the log. Offsets inside it are local; the log
+// adds base to make them global.
+type segment struct {
+	file   *os.File
+	path   string
+	base   int64 // global offset of the start of the file
+	size   int64 // end of the last valid record, where the next one is written
+	sealed int64 // local offset of the first encrypted record found on open, 0 if none
+}
+
+// createSegment creates an empty segment file starting at base
+func createSegment(path string, base int64) (*segment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteString(magic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &segment{file: file, path: path, base: base, size: headerSize}, nil
+}
+
+// openSegment opens an existing segment file. If truncate is set, a torn
+// record at the tail is dropped; otherwise it is an error.
+func openSegment(path string, base int64, truncate bool) (*segment, error) {
+	if err := migrateLegacy(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := &segment{file: file, path: path, base: base}
+	if err := seg.recover(truncate); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return seg, nil
+}
+
+// recover finds the end of the last valid record and truncates a torn
+// record after it. A damaged record is only torn if no valid record
+// follows it: a damaged length can point past the end of the file from the
+// middle of the log, and truncating there would drop the records after it.
+// Any other damage is an error.
+func (s *segment) recover(truncate bool) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := headerSize
+	for {
+		_, flags, next, err := s.read(offset, info.Size())
+		if err == io.EOF {
+			break
+		}
+		if err == nil && flags&flagEncrypted != 0 && s.sealed == 0 {
+			s.sealed = offset
+		}
+		if err != nil && (err == io.ErrUnexpectedEOF || errors.Is(err, ErrCorrupt)) {
+			if valid, ok := s.resync(offset, info.Size()); ok {
+				return fmt.Errorf("offset %d: %w, with valid records from offset %d on: repair the log offline with kvs-wal repair", s.base+offset, err, s.base+valid)
+			}
+			if truncate {
+				log.Warn().Msgf("Truncating torn WAL record at offset %d (%d bytes)", s.base+offset, info.Size()-offset)
+				if err := s.file.Truncate(offset); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", s.base+offset, err)
+		}
+		offset = next
+	}
+
+	s.size = offset
+	return nil
+}
+
+// resync returns the local offset of the first valid record after the
+// damaged one at offset, trying every byte. ok is false if none follows.
+func (s *segment) resync(offset, end int64) (int64, bool) {
+	for local := offset + 1; local+recordHead <= end; local++ {
+		if _, _, _, err := s.read(local, end); err == nil {
+			return local, true
+		}
+	}
+	return 0, false
+}
+
+// append writes payload as one record with flags and returns its local offset
+func (s *segment) append(payload []byte, flags byte) (int64, error) {
+	offset := s.size
+
+	record := make([]byte, recordHead+len(payload))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(payload)))
+	record[8] = flags
+	copy(record[recordHead:], payload)
+	binary.BigEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crcTable))
+
+	if _, err := s.file.WriteAt(record, offset); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(record))
+	return offset, nil
+}
+
+// read returns the payload and flags of the record at local offset and the
+// local offset of the next record. end is the local offset the readable
+// data ends at. It returns io.EOF at the end of the segment and
+// io.ErrUnexpectedEOF for a record that runs past end, whether it was cut
+// short or its length is damaged. On ErrCorrupt the returned offset is
+// where the damaged record claims to end.
+func (s *segment) read(offset, end int64) ([]byte, byte, int64, error) {
+	if offset < headerSize {
+		offset = headerSize
+	}
+
+	// ReadAt leaves the file cursor alone, so concurrent reads are safe
+	head := make([]byte, recordHead)
+	n, err := s.file.ReadAt(head, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, 0, io.EOF
+	}
+	if n < recordHead {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.BigEndian.Uint32(head[4:8])
+	next := offset + recordHead + int64(length)
+	if length > maxRecord {
+		return nil, 0, next, ErrCorrupt
+	}
+	// The length isn't trusted yet: don't allocate more than the file holds
+	if next > end {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+
+	record := make([]byte, recordHead+int(length))
+	copy(record, head)
+	if n, _ := s.file.ReadAt(record[recordHead:], offset+recordHead); n < int(length) {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+
+	if crc32.Checksum(record[4:], crcTable) != binary.BigEndian.Uint32(head[0:4]) {
+		return nil, 0, next, ErrCorrupt
+	}
+	flags := head[8]
+	if flags&^knownFlags != 0 {
+		return nil, 0, next, fmt.Errorf("%w: unknown flags %#x", ErrCorrupt, flags)
+	}
+
+	return record[recordHead:], flags, next, nil
+}
+
+// close flushes the segment to disk and closes it
+func (s *segment) close() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
@@ -1,74 +1,374 @@
 package wal
 
 import (
-	"bufio"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-kvs/internal/keyring"
 
 	"github.com/rs/zerolog/log"
 )
 
 type WAL interface {
 	Append(cmd []byte) (int64, error)
+	Encode(cmd []byte) ([]byte, Codec)
+	AppendEncoded(data []byte, codec Codec) (int64, error)
+	SetCompression(codec Codec, minSize int)
+	SetMaxSize(max int64)
+	Size() int64
 	Read(offset int64) ([]byte, int64, error)
+	Base() int64
+	Close() error
 }
 
+// DefaultSegmentSize is the size at which the active segment is closed and
+// a new one started
+const DefaultSegmentSize = 64 << 20
+
+// ErrFull is returned by Append when the record would take the log past
+// its size limit
+var ErrFull = errors.New("wal: size limit reached")
+
+// segmentExt is the file extension of segments. Each segment is named after
+// its base offset, so names sort in log order.
+const segmentExt = ".wal"
+
+// WriteAheadLog is a log split into segment files in one directory.
+// Offsets are global: a segment's base offset is where the previous one
+// ended, so a record's offset stays valid as segments are added.
 type WriteAheadLog struct {
-	file  *os.File
-	index map[string]int64
+	dir         string
+	segments    []*segment // ordered by base offset, records are appended to the last one
+	segmentSize int64
+	index       map[string]int64
+	keys        *keyring.Keyring // encrypts new records and decrypts old ones, nil = plaintext
+	codec       Codec            // compresses new records of at least minSize bytes
+	minSize     int
+	maxSize     int64        // total size of the segments Append may not exceed, 0 = no limit
+	sealedAt    int64        // global offset of the first encrypted record, 0 if none
+	mu          sync.RWMutex // guards segments, Read runs concurrently with Append
+
+	archiveDir string         // sealed segments are copied here, "" = off
+	archiving  sync.WaitGroup // running archive copies
 }
 
-func New(filePath string, index map[string]int64) (*WriteAheadLog, error) {
-	// check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		// create
-		_, errCreate := os.Create(filePath)
-		if errCreate != nil {
-			return nil, errCreate
+// New opens the log in dir, creating the directory and a first segment if needed
+func New(dir string, index map[string]int64) (*WriteAheadLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WriteAheadLog{dir: dir, segmentSize: DefaultSegmentSize, index: index, minSize: DefaultCompressMinSize}
+	for i, base := range bases {
+		last := i == len(bases)-1
+		seg, err := openSegment(filepath.Join(dir, SegmentName(base)), base, last)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if i > 0 {
+			prev := w.segments[i-1]
+			if prev.base+prev.size != base {
+				seg.close()
+				w.Close()
+				return nil, fmt.Errorf("wal: segment %s doesn't follow %s", seg.path, prev.path)
+			}
+		}
+		w.segments = append(w.segments, seg)
+		if w.sealedAt == 0 && seg.sealed > 0 {
+			w.sealedAt = base + seg.sealed
+		}
+	}
+
+	if len(w.segments) == 0 {
+		seg, err := createSegment(filepath.Join(dir, SegmentName(0)), 0)
+		if err != nil {
+			return nil, err
 		}
+		w.segments = append(w.segments, seg)
 		log.Info().Msg("Write-ahead log file created")
 	}
 
-	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+	return w, nil
+}
+
+// Create creates a log in the empty directory dir whose first segment
+// starts at base instead of 0. A log rewritten from another one starts
+// where the old one ended, so its segment names don't collide with the old
+// log's archived segments.
+func Create(dir string, base int64, index map[string]int64) (*WriteAheadLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if bases, err := listSegments(dir); err != nil {
+		return nil, err
+	} else if len(bases) > 0 {
+		return nil, fmt.Errorf("wal: %s already has a write-ahead log", dir)
+	}
+
+	seg, err := createSegment(filepath.Join(dir, SegmentName(base)), base)
 	if err != nil {
 		return nil, err
 	}
+	return &WriteAheadLog{dir: dir, segments: []*segment{seg}, segmentSize: DefaultSegmentSize, index: index, minSize: DefaultCompressMinSize}, nil
+}
+
+// SetKeyring makes the log encrypt new records with the keyring's active
+// key. Records encrypted with any of its keys can be read.
+func (w *WriteAheadLog) SetKeyring(keys *keyring.Keyring) {
+	w.keys = keys
+}
+
+// SetCompression makes the log compress new records of at least minSize
+// bytes with codec. A record that doesn't get smaller is stored as is.
+// Records compressed with any codec can be read.
+func (w *WriteAheadLog) SetCompression(codec Codec, minSize int) {
+	w.codec = codec
+	if minSize > 0 {
+		w.minSize = minSize
+	}
+}
+
+// SetMaxSize limits the total size of the log's segments. Append fails
+// with ErrFull rather than exceed it; 0 removes the limit.
+func (w *WriteAheadLog) SetMaxSize(max int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxSize = max
+}
+
+// Size returns the total size of the log's segments
+func (w *WriteAheadLog) Size() int64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.size()
+}
+
+func (w *WriteAheadLog) size() int64 {
+	var size int64
+	for _, seg := range w.segments {
+		size += seg.size
+	}
+	return size
+}
 
-	return &WriteAheadLog{file: file, index: index}, nil
+// SetSegmentSize sets the size at which a new segment is started
+func (w *WriteAheadLog) SetSegmentSize(size int64) {
+	if size > 0 {
+		w.segmentSize = size
+	}
 }
 
+// Append writes cmd as one record and returns its offset
 func (w *WriteAheadLog) Append(cmd []byte) (int64, error) {
-	offset, err := w.file.Seek(0, 1)
+	data, codec := w.Encode(cmd)
+	return w.AppendEncoded(data, codec)
+}
+
+// Encode compresses cmd the way Append would store it and returns the
+// compressed bytes and the codec used, which is CodecNone if cmd is too
+// small or doesn't get smaller
+func (w *WriteAheadLog) Encode(cmd []byte) ([]byte, Codec) {
+	if w.codec == CodecNone || len(cmd) < w.minSize {
+		return cmd, CodecNone
+	}
+	data, err := Compress(w.codec, cmd)
+	if err != nil || len(data) >= len(cmd) {
+		return cmd, CodecNone
+	}
+	return data, w.codec
+}
+
+// AppendEncoded writes a command already compressed with codec as one
+// record and returns its offset. Replicas use it to store the bytes the
+// leader stored without compressing them again.
+func (w *WriteAheadLog) AppendEncoded(data []byte, codec Codec) (int64, error) {
+	if codec > CodecZstd {
+		return 0, fmt.Errorf("wal: unknown codec %d", codec)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stored := int64(len(data))
+	if w.keys != nil {
+		stored += keyring.Overhead
+	}
+
+	// A record that starts a new segment also needs the segment's header
+	need := recordHead + stored
+	active := w.segments[len(w.segments)-1]
+	roll := active.size > headerSize && active.size+need > w.segmentSize
+	if roll {
+		need += headerSize
+	}
+	if w.maxSize > 0 && w.size()+need > w.maxSize {
+		return 0, ErrFull
+	}
+
+	if roll {
+		var err error
+		if active, err = w.roll(active); err != nil {
+			return 0, err
+		}
+	}
+
+	payload, flags := encode(data, codec, active.base+active.size, w.keys)
+	offset, err := active.append(payload, flags)
 	if err != nil {
 		return 0, err
 	}
+	if flags&flagEncrypted != 0 && w.sealedAt == 0 {
+		w.sealedAt = active.base + offset
+	}
+	return active.base + offset, nil
+}
 
-	cmd = append(cmd, '\n')
+// roll syncs the active segment and starts a new one after it
+func (w *WriteAheadLog) roll(active *segment) (*segment, error) {
+	if err := active.file.Sync(); err != nil {
+		return nil, err
+	}
 
-	_, err = w.file.Write(cmd)
+	base := active.base + active.size
+	seg, err := createSegment(filepath.Join(w.dir, SegmentName(base)), base)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	w.segments = append(w.segments, seg)
+	log.Info().Msgf("Started WAL segment %s", SegmentName(base))
+
+	if w.archiveDir != "" {
+		w.archive(active.path)
 	}
-	log.Info().Msgf("Appended: %s", cmd)
-	return offset, nil
+	return seg, nil
 }
 
+// Read returns the payload of the record at offset and the offset of the
+// next record. Offset 0 is the first record. It returns io.EOF at the end
+// of the log.
 func (w *WriteAheadLog) Read(offset int64) ([]byte, int64, error) {
-	// Set the file cursor to the specified offset
-	_, err := w.file.Seek(offset, 0)
+	w.mu.RLock()
+	seg := w.segmentAt(offset)
+	end, sealedAt := seg.size, w.sealedAt
+	w.mu.RUnlock()
+
+	if offset < seg.base+headerSize {
+		offset = seg.base + headerSize
+	}
+	payload, flags, next, err := seg.read(offset-seg.base, end)
 	if err != nil {
-		return []byte{}, 0, err
+		return nil, 0, err
+	}
+	cmd, err := decode(payload, flags, offset, sealedAt, w.keys)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cmd, seg.base + next, nil
+}
+
+// Base returns the offset the log starts at: 0, or where the log it was
+// compacted from ended
+func (w *WriteAheadLog) Base() int64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.segments[0].base
+}
+
+// segmentAt returns the segment holding offset: the last one starting at or before it
+func (w *WriteAheadLog) segmentAt(offset int64) *segment {
+	i := sort.Search(len(w.segments), func(i int) bool {
+		return w.segments[i].base > offset
+	})
+	if i == 0 {
+		return w.segments[0]
+	}
+	return w.segments[i-1]
+}
+
+// Close flushes the log to disk and closes it. With an archive directory
+// set, it waits for running archive copies and archives the active segment
+// as it is; the copy is replaced once the segment is sealed.
+func (w *WriteAheadLog) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	for _, seg := range w.segments {
+		if err := seg.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 
-	// Create a buffered reader for efficient reading
-	reader := bufio.NewReader(w.file)
+	w.archiving.Wait()
+	if w.archiveDir != "" && firstErr == nil {
+		firstErr = archiveSegment(w.segments[len(w.segments)-1].path, w.archiveDir)
+	}
+	return firstErr
+}
+
+// SegmentName returns the file name of the segment starting at base
+func SegmentName(base int64) string {
+	return fmt.Sprintf("%020d%s", base, segmentExt)
+}
 
-	// Read until the end of the line
-	lineBytes, err := reader.ReadBytes('\n')
+// listSegments returns the base offsets of the segments in dir, in order
+func listSegments(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return []byte{}, 0, err
+		return nil, err
+	}
+
+	var bases []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+// Adopt moves a single-file log from an older version into dir as its
+// first segment. It does nothing if the file doesn't exist or dir already
+// has segments.
+func Adopt(filePath, dir string) error {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
 
-	newOffset := offset + int64(len(lineBytes))
+	bases, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) > 0 {
+		log.Warn().Msgf("Ignoring %s: %s already has a write-ahead log", filePath, dir)
+		return nil
+	}
 
-	return lineBytes, newOffset, nil
+	if err := os.Rename(filePath, filepath.Join(dir, SegmentName(0))); err != nil {
+		return err
+	}
+	log.Info().Msgf("Moved %s into %s", filePath, dir)
+	return nil
 }
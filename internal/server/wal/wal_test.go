@@ -0,0 +1,403 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-kvs/internal/keyring"
+)
+
+// writeLog appends records to a new log in dir and returns their offsets
+func writeLog(t *testing.T, dir string, codec Codec, segmentSize int64, records [][]byte) []int64 {
+	t.Helper()
+	w, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCompression(codec, 1)
+	if segmentSize > 0 {
+		w.SetSegmentSize(segmentSize)
+	}
+
+	offsets := make([]int64, len(records))
+	for i, rec := range records {
+		if offsets[i], err = w.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return offsets
+}
+
+// readAll reads every record of the log in order
+func readAll(w *WriteAheadLog) ([][]byte, error) {
+	var records [][]byte
+	for offset := int64(0); ; {
+		rec, next, err := w.Read(offset)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+		offset = next
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	records := [][]byte{
+		[]byte("a"),
+		{},
+		bytes.Repeat([]byte("compressible "), 100),
+		[]byte(strings.Repeat("x", 4096)),
+	}
+
+	tests := []struct {
+		name        string
+		codec       Codec
+		segmentSize int64
+	}{
+		{"plain", CodecNone, 0},
+		{"snappy", CodecSnappy, 0},
+		{"zstd", CodecZstd, 0},
+		{"segment per record", CodecNone, 64},
+		{"zstd segment per record", CodecZstd, 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			offsets := writeLog(t, dir, tt.codec, tt.segmentSize, records)
+
+			w, err := New(dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			for i, offset := range offsets {
+				got, _, err := w.Read(offset)
+				if err != nil {
+					t.Fatalf("record %d at offset %d: %v", i, offset, err)
+				}
+				if !bytes.Equal(got, records[i]) {
+					t.Errorf("record %d = %q, want %q", i, got, records[i])
+				}
+			}
+			got, err := readAll(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(records) {
+				t.Errorf("read %d records in order, want %d", len(got), len(records))
+			}
+		})
+	}
+}
+
+func TestRecoverDamage(t *testing.T) {
+	records := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+
+	tests := []struct {
+		name    string
+		damage  func(data []byte, offsets []int64) []byte
+		keep    int  // records readable after a successful open
+		refused bool // open fails: damage with valid records after it
+	}{
+		{
+			name:   "intact",
+			damage: func(data []byte, _ []int64) []byte { return data },
+			keep:   3,
+		},
+		{
+			name:   "torn tail",
+			damage: func(data []byte, _ []int64) []byte { return data[:len(data)-2] },
+			keep:   2,
+		},
+		{
+			name:   "torn tail header",
+			damage: func(data []byte, offsets []int64) []byte { return data[:offsets[2]+4] },
+			keep:   2,
+		},
+		{
+			name:   "bad checksum at tail",
+			damage: func(data []byte, _ []int64) []byte { data[len(data)-1] ^= 0xff; return data },
+			keep:   2,
+		},
+		{
+			name: "length past the end at tail",
+			damage: func(data []byte, offsets []int64) []byte {
+				binary.BigEndian.PutUint32(data[offsets[2]+4:], 1000)
+				return data
+			},
+			keep: 2,
+		},
+		{
+			name: "bad checksum mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				data[offsets[1]+recordHead] ^= 0xff
+				return data
+			},
+			refused: true,
+		},
+		{
+			name: "length past the end mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				binary.BigEndian.PutUint32(data[offsets[0]+4:], 1000)
+				return data
+			},
+			refused: true,
+		},
+		{
+			name: "huge length mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				binary.BigEndian.PutUint32(data[offsets[0]+4:], 0xffffffff)
+				return data
+			},
+			refused: true,
+		},
+		{
+			name: "unknown flags mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				data[offsets[1]+8] = 0x80
+				return data
+			},
+			refused: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			offsets := writeLog(t, dir, CodecNone, 0, records)
+
+			path := filepath.Join(dir, SegmentName(0))
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data, offsets), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err := New(dir, nil)
+			if tt.refused {
+				if err == nil {
+					w.Close()
+					t.Fatal("opened a log damaged in the middle")
+				}
+				if !errors.Is(err, ErrCorrupt) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("err = %v, want ErrCorrupt or io.ErrUnexpectedEOF", err)
+				}
+				if !strings.Contains(err.Error(), "kvs-wal repair") {
+					t.Errorf("err = %v, want a pointer to kvs-wal repair", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			got, err := readAll(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.keep {
+				t.Fatalf("read %d records, want %d", len(got), tt.keep)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], records[i]) {
+					t.Errorf("record %d = %q, want %q", i, got[i], records[i])
+				}
+			}
+
+			// The next record goes where the torn one was
+			if tt.keep < len(records) {
+				offset, err := w.Append([]byte("again"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if offset != offsets[tt.keep] {
+					t.Errorf("appended at offset %d, want %d", offset, offsets[tt.keep])
+				}
+			}
+		})
+	}
+}
+
+// testKeys returns a keyring with one AES-128 key
+func testKeys(t *testing.T) *keyring.Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("1 000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestEncryptedRecords(t *testing.T) {
+	keys := testKeys(t)
+
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, dir string, offsets []int64)
+		keys   *keyring.Keyring
+		want   error // from reading the log back
+	}{
+		{
+			name:   "intact",
+			tamper: func(*testing.T, string, []int64) {},
+			keys:   keys,
+		},
+		{
+			name:   "no keyfile",
+			tamper: func(*testing.T, string, []int64) {},
+			want:   ErrNoKeyring,
+		},
+		{
+			// Valid records moved to another offset no longer decrypt
+			name: "records swapped",
+			tamper: func(t *testing.T, dir string, offsets []int64) {
+				path := filepath.Join(dir, SegmentName(0))
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				first := append([]byte(nil), data[offsets[0]:offsets[1]]...)
+				second := append([]byte(nil), data[offsets[1]:]...)
+				swapped := append(append(data[:offsets[0]:offsets[0]], second...), first...)
+				if err := os.WriteFile(path, swapped, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			keys: keys,
+			want: keyring.ErrDecrypt,
+		},
+		{
+			// A plaintext record after an encrypted one was written
+			// without the keyfile, not by this node
+			name: "plaintext appended",
+			tamper: func(t *testing.T, dir string, offsets []int64) {
+				w, err := New(dir, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Append([]byte("injected")); err != nil {
+					t.Fatal(err)
+				}
+				w.Close()
+			},
+			keys: keys,
+			want: ErrPlaintext,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := New(dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.SetKeyring(keys)
+			var offsets []int64
+			for _, rec := range []string{"same size 1", "same size 2"} {
+				offset, err := w.Append([]byte(rec))
+				if err != nil {
+					t.Fatal(err)
+				}
+				offsets = append(offsets, offset)
+			}
+			w.Close()
+
+			tt.tamper(t, dir, offsets)
+
+			if w, err = New(dir, nil); err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			w.SetKeyring(tt.keys)
+
+			got, err := readAll(w)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != 2 || string(got[1]) != "same size 2" {
+					t.Errorf("read %q", got)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	rec := []byte(strings.Repeat("x", 100))
+
+	tests := []struct {
+		name        string
+		segmentSize int64
+		extra       int64 // bytes left after the first record beyond what a second needs
+		wantErr     error
+	}{
+		{name: "exactly enough room"},
+		{name: "one byte short", extra: -1, wantErr: ErrFull},
+		{name: "no room for a new segment's header", segmentSize: 64, wantErr: ErrFull},
+		{name: "room for a new segment", segmentSize: 64, extra: headerSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(t.TempDir(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if tt.segmentSize > 0 {
+				w.SetSegmentSize(tt.segmentSize)
+			}
+			if _, err := w.Append(rec); err != nil {
+				t.Fatal(err)
+			}
+			size := w.Size()
+			w.SetMaxSize(size + recordHead + int64(len(rec)) + tt.extra)
+
+			if _, err := w.Append(rec); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("append: %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				return
+			}
+
+			// A refused record isn't written, and fits once the limit is lifted
+			if w.Size() != size {
+				t.Errorf("size = %d after a refused append, want %d", w.Size(), size)
+			}
+			w.SetMaxSize(0)
+			if _, err := w.Append(rec); err != nil {
+				t.Fatal(err)
+			}
+			records, err := readAll(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 2 {
+				t.Errorf("read %d records, want 2", len(records))
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/pkg/kvs"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultWaitForChangeTimeout is used when WaitForChange sets no timeout
+	DefaultWaitForChangeTimeout = 30 * time.Second
+	// MaxWaitForChangeTimeout caps how long one WaitForChange call blocks
+	MaxWaitForChangeTimeout = 5 * time.Minute
+)
+
+// SetChangeHub enables Watch, WatchKey and WaitForChange, serving the
+// changes published to hub
+func (k *KvsServer) SetChangeHub(hub *cdc.Hub) {
+	k.changes = hub
+	hub.SetHistory(k.changeHistory)
+}
+
+// changeHistory reads the changes the hub no longer buffers back from the
+// WAL, so watchers can resume from before a restart
+func (k *KvsServer) changeHistory(afterSeq, upTo int64, match func(ns, key string) bool, fn func(cdc.Event) error) error {
+	return k.kvs.History(afterSeq, upTo, match, func(change kvs.Change) error {
+		c := change.Cmd
+		ev := cdc.Event{Namespace: c.Namespace, OldValue: change.OldVal, HasOldValue: change.HadOld, Sequence: change.Sequence, Time: time.Unix(0, c.Time)}
+		switch c.Cmd {
+		case "set":
+			ev.Op, ev.Key, ev.NewValue = cdc.OpSet, c.Key, c.Val
+		case "del":
+			ev.Op, ev.Key = cdc.OpDelete, c.Key
+		case "ns-drop":
+			ev.Op = cdc.OpDropNamespace
+		}
+		return fn(ev)
+	})
+}
+
+// Watch streams the changes applied on this node, optionally resuming
+// after a sequence
+func (k *KvsServer) Watch(request *go_kvs.WatchRequest, stream go_kvs.GoKvs_WatchServer) error {
+	if k.changes == nil {
+		return status.Error(codes.Unimplemented, "change stream not enabled on this node")
+	}
+
+	sub, err := k.changes.Subscribe(request.AfterSequence, request.Namespace, request.Prefix)
+	if err != nil {
+		return subscribeError(k.changes, err)
+	}
+	defer k.changes.Unsubscribe(sub)
+	if err := k.checkNamespace(request.Namespace, sub); err != nil {
+		return err
+	}
+
+	return streamChanges(k.changes, sub, request.AfterSequence, stream)
+}
+
+// WatchKey streams each change to one key, or to every key with a prefix
+func (k *KvsServer) WatchKey(request *go_kvs.WatchKeyRequest, stream go_kvs.GoKvs_WatchKeyServer) error {
+	if k.changes == nil {
+		return status.Error(codes.Unimplemented, "change stream not enabled on this node")
+	}
+
+	var sub *cdc.Subscription
+	var err error
+	if request.Prefix {
+		sub, err = k.changes.Subscribe(request.AfterSequence, request.Namespace, request.Key)
+	} else {
+		sub, err = k.changes.SubscribeKey(request.AfterSequence, request.Namespace, request.Key)
+	}
+	if err != nil {
+		return subscribeError(k.changes, err)
+	}
+	defer k.changes.Unsubscribe(sub)
+	if err := k.checkNamespace(request.Namespace, sub); err != nil {
+		return err
+	}
+
+	return streamChanges(k.changes, sub, request.AfterSequence, stream)
+}
+
+// WaitForChange blocks until key changes after request.AfterSequence and
+// returns the change. If the timeout passes first, it returns changed=false
+// with the sequence to wait after in the next call.
+func (k *KvsServer) WaitForChange(ctx context.Context, request *go_kvs.WaitForChangeRequest) (*go_kvs.WaitForChangeResponse, error) {
+	if k.changes == nil {
+		return nil, status.Error(codes.Unimplemented, "change stream not enabled on this node")
+	}
+
+	timeout := DefaultWaitForChangeTimeout
+	if request.Timeout != nil && request.Timeout.AsDuration() > 0 {
+		timeout = request.Timeout.AsDuration()
+	}
+	if timeout > MaxWaitForChangeTimeout {
+		timeout = MaxWaitForChangeTimeout
+	}
+
+	sub, err := k.changes.SubscribeKey(request.AfterSequence, request.Namespace, request.Key)
+	if err != nil {
+		return nil, subscribeError(k.changes, err)
+	}
+	defer k.changes.Unsubscribe(sub)
+	if err := k.checkNamespace(request.Namespace, sub); err != nil {
+		return nil, err
+	}
+
+	// A buffered change already satisfies the request
+	if len(sub.Backlog) > 0 {
+		ev := sub.Backlog[0]
+		return &go_kvs.WaitForChangeResponse{Changed: true, Event: changeEvent(ev), Sequence: ev.Sequence}, nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return nil, subscriptionEnded(k.changes, request.AfterSequence)
+			}
+			if ev.Sequence < request.AfterSequence {
+				continue
+			}
+			return &go_kvs.WaitForChangeResponse{Changed: true, Event: changeEvent(ev), Sequence: ev.Sequence}, nil
+		case <-timer.C:
+			// Every change up to seq is already queued on sub.C, so one that
+			// arrived with the timeout is returned rather than skipped
+			seq := k.changes.LastSequence()
+			for drained := false; !drained; {
+				select {
+				case ev, ok := <-sub.C:
+					if !ok {
+						return nil, subscriptionEnded(k.changes, request.AfterSequence)
+					}
+					if ev.Sequence >= request.AfterSequence {
+						return &go_kvs.WaitForChangeResponse{Changed: true, Event: changeEvent(ev), Sequence: ev.Sequence}, nil
+					}
+				default:
+					drained = true
+				}
+			}
+
+			// Nothing changed up to seq: the next call can wait after it
+			if seq < request.AfterSequence {
+				seq = request.AfterSequence
+			}
+			return &go_kvs.WaitForChangeResponse{Changed: false, Sequence: seq}, nil
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// changeSender is a server stream of change events (Watch, WatchKey)
+type changeSender interface {
+	Send(*go_kvs.ChangeEvent) error
+	Context() context.Context
+}
+
+// checkNamespace fails with NotFound unless the namespace ns exists, or
+// sub replays its drop. Watches check it after subscribing, so a drop
+// racing with the subscription is still delivered.
+func (k *KvsServer) checkNamespace(ns string, sub *cdc.Subscription) error {
+	if _, err := k.kvs.Namespace(ns); err != nil {
+		if n := len(sub.Backlog); n > 0 && sub.Backlog[n-1].Op == cdc.OpDropNamespace {
+			return nil
+		}
+		return statusErr(err)
+	}
+	return nil
+}
+
+// streamChanges sends the subscription's backlog, then live changes, until
+// the client goes away. A watcher that falls behind gets ResourceExhausted
+// and can resume with the sequence of the last change it received. A drop
+// of the namespace is sent, then ends the stream with NotFound.
+func streamChanges(hub *cdc.Hub, sub *cdc.Subscription, afterSeq int64, stream changeSender) error {
+	// Step 1: replay buffered changes
+	lastSent := afterSeq
+	for _, ev := range sub.Backlog {
+		if err := stream.Send(changeEvent(ev)); err != nil {
+			return err
+		}
+		lastSent = ev.Sequence
+		if ev.Op == cdc.OpDropNamespace {
+			return namespaceDropped(ev)
+		}
+	}
+
+	// Step 2: stream live changes
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return subscriptionEnded(hub, lastSent)
+			}
+			if ev.Sequence < lastSent {
+				continue // already sent during replay; an anti-entropy repair repeats the last sequence
+			}
+			if err := stream.Send(changeEvent(ev)); err != nil {
+				return err
+			}
+			lastSent = ev.Sequence
+			if ev.Op == cdc.OpDropNamespace {
+				return namespaceDropped(ev)
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// subscribeError maps a failed subscription to a status
+func subscribeError(hub *cdc.Hub, err error) error {
+	if hub.Closed() {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	return status.Error(codes.OutOfRange, err.Error())
+}
+
+// namespaceDropped ends a watch whose namespace was dropped
+func namespaceDropped(ev cdc.Event) error {
+	return status.Errorf(codes.NotFound, "namespace %q was dropped at seq=%d", ev.Namespace, ev.Sequence)
+}
+
+// subscriptionEnded explains why the hub closed a subscription: the
+// watcher fell behind, or the server is shutting down
+func subscriptionEnded(hub *cdc.Hub, lastSeq int64) error {
+	if hub.Closed() {
+		return status.Errorf(codes.Unavailable, "server is shutting down, resume with after_sequence=%d", lastSeq)
+	}
+	return status.Errorf(codes.ResourceExhausted, "watcher fell behind, resume with after_sequence=%d", lastSeq)
+}
+
+// publishChange sends a change applied by this node to watchers
+func (k *KvsServer) publishChange(ev cdc.Event) {
+	if k.changes != nil {
+		k.changes.Publish(ev)
+	}
+}
+
+func changeEvent(ev cdc.Event) *go_kvs.ChangeEvent {
+	res := &go_kvs.ChangeEvent{
+		Namespace:   ev.Namespace,
+		Key:         ev.Key,
+		OldValue:    ev.OldValue,
+		NewValue:    ev.NewValue,
+		HasOldValue: ev.HasOldValue,
+		Sequence:    ev.Sequence,
+		Timestamp:   timestamppb.New(ev.Time),
+	}
+	switch ev.Op {
+	case cdc.OpSet:
+		res.Operation = go_kvs.Operation_SET
+	case cdc.OpDelete:
+		res.Operation = go_kvs.Operation_DELETE
+	case cdc.OpDropNamespace:
+		res.Operation = go_kvs.Operation_DROP_NAMESPACE
+	}
+	return res
+}
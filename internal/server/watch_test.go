@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-kvs/api/proto/pb"
+	"go-kvs/internal/cdc"
+	"go-kvs/internal/config"
+	"go-kvs/pkg/kvs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestWatchResume(t *testing.T) {
+	changes := []string{"2 SET a v1>v2", "3 DELETE a v2>", "4 SET b >v1"}
+
+	tests := []struct {
+		name     string
+		buffer   int
+		loadedAt int64 // sequence of a snapshot load before the writes, 0 = none
+		restart  bool
+		after    int64 // after_sequence relative to the first write
+		want     []string
+		code     codes.Code
+	}{
+		{name: "buffered", buffer: 100, after: 1, want: changes},
+		{name: "evicted from the buffer", buffer: 2, after: 1, want: changes},
+		{name: "after a restart", buffer: 100, restart: true, after: 1, want: changes},
+		{name: "caught up after a restart", buffer: 100, restart: true, after: 4},
+		{name: "after a snapshot load", buffer: 100, loadedAt: 10, restart: true, after: 1, want: changes},
+		{name: "before a snapshot load", buffer: 100, loadedAt: 10, restart: true, after: -5, code: codes.OutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{ChangeBufferSize: tt.buffer})
+			if tt.loadedAt > 0 {
+				if err := leader.kvs.Set("loaded", "v"); err != nil {
+					t.Fatal(err)
+				}
+				if err := leader.kvs.MarkSequence(tt.loadedAt); err != nil {
+					t.Fatal(err)
+				}
+				leader.streamMgr.Resume(tt.loadedAt)
+				leader.changes.Reset(tt.loadedAt)
+			}
+
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, write := range []func() error{
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v1"}); return err },
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v2"}); return err },
+				func() error { _, err := kvsClient.Del(ctx, &go_kvs.KeyRequest{Key: "a"}); return err },
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "b", Val: "v1"}); return err },
+			} {
+				if err := write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.restart {
+				leader.changes.Reset(leader.kvs.LastSequence())
+			}
+
+			stream, err := kvsClient.Watch(ctx, &go_kvs.WatchRequest{AfterSequence: tt.loadedAt + tt.after})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for len(got) < len(tt.want) {
+				ev, err := stream.Recv()
+				if err != nil {
+					if code := status.Code(err); code != tt.code {
+						t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+					}
+					break
+				}
+				got = append(got, fmt.Sprintf("%d %s %s %s>%s", ev.Sequence-tt.loadedAt, ev.Operation, ev.Key, ev.OldValue, ev.NewValue))
+			}
+			if tt.code != codes.OK && len(tt.want) == 0 {
+				if _, err := stream.Recv(); status.Code(err) != tt.code {
+					t.Fatalf("code = %v, want %v (%v)", status.Code(err), tt.code, err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// sentChanges collects what streamChanges sends
+type sentChanges struct {
+	ctx    context.Context
+	events []string
+}
+
+func (s *sentChanges) Send(ev *go_kvs.ChangeEvent) error {
+	s.events = append(s.events, fmt.Sprintf("%d %s>%s", ev.Sequence, ev.OldValue, ev.NewValue))
+	return nil
+}
+
+func (s *sentChanges) Context() context.Context {
+	return s.ctx
+}
+
+func TestWatchRepairs(t *testing.T) {
+	tests := []struct {
+		name  string
+		after int64 // changes before subscribing, and the sequence the watch starts after
+		want  []string
+	}{
+		{"from the start", 0, []string{"1 >v1", "1 v1>repaired", "2 repaired>v2"}},
+		{"resumed at the repaired sequence", 1, []string{"1 v1>repaired", "2 repaired>v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := cdc.NewHub(0)
+			changes := []cdc.Event{
+				{Key: "a", Op: cdc.OpSet, NewValue: "v1", Sequence: 1},
+				// A follower's anti-entropy repair repeats the last sequence
+				{Key: "a", Op: cdc.OpSet, OldValue: "v1", HasOldValue: true, NewValue: "repaired", Sequence: 1},
+				{Key: "a", Op: cdc.OpSet, OldValue: "repaired", HasOldValue: true, NewValue: "v2", Sequence: 2},
+			}
+			for _, ev := range changes[:tt.after] {
+				hub.Publish(ev)
+			}
+			sub, err := hub.Subscribe(tt.after, kvs.DefaultNamespace, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range changes[tt.after:] {
+				hub.Publish(ev)
+			}
+			hub.Close()
+
+			sent := &sentChanges{ctx: context.Background()}
+			if err := streamChanges(hub, sub, tt.after, sent); status.Code(err) != codes.Unavailable {
+				t.Fatalf("stream ended with %v, want Unavailable", err)
+			}
+			if !reflect.DeepEqual(sent.events, tt.want) {
+				t.Errorf("sent %q, want %q", sent.events, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchNamespaceDrop(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		resume    bool // watch after the drop, resuming from before the writes
+		want      []string
+		code      codes.Code
+	}{
+		{name: "watching the dropped namespace", namespace: "t1", want: []string{"SET t1/a", "DROP_NAMESPACE t1/"}, code: codes.NotFound},
+		{name: "resumed across the drop", namespace: "t1", resume: true, want: []string{"SET t1/a", "DROP_NAMESPACE t1/"}, code: codes.NotFound},
+		{name: "watching another namespace", namespace: "t2", want: []string{"SET t2/a", "SET t2/b"}},
+		{name: "resumed in another namespace", namespace: "t2", resume: true, want: []string{"SET t2/a", "SET t2/b"}},
+		{name: "namespace that doesn't exist", namespace: "t3", code: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			for _, ns := range []string{"t1", "t2"} {
+				if _, err := leader.server.CreateNamespace(ns, kvs.Quota{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			set := func(ns, key string) {
+				if _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: key, Val: "v", Namespace: ns}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			after := leader.kvs.LastSequence()
+
+			var stream go_kvs.GoKvs_WatchClient
+			watch := func() {
+				var err error
+				stream, err = kvsClient.Watch(ctx, &go_kvs.WatchRequest{Namespace: tt.namespace, AfterSequence: after})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.resume {
+				watch()
+			}
+			set("t1", "a")
+			set("t2", "a")
+			if _, _, err := leader.server.DropNamespace("t1"); err != nil {
+				t.Fatal(err)
+			}
+			set("t2", "b")
+			if tt.resume {
+				watch()
+			}
+
+			var got []string
+			for len(got) < len(tt.want) {
+				ev, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("after %q: %v", got, err)
+				}
+				got = append(got, fmt.Sprintf("%s %s/%s", ev.Operation, ev.Namespace, ev.Key))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.code != codes.OK {
+				if _, err := stream.Recv(); status.Code(err) != tt.code {
+					t.Errorf("stream ended with %v, want %v", err, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		after   int64    // relative to the writes before the call
+		during  []string // "key" set, or "drop", while the call waits
+		want    string   // change returned, "" = none
+		wantSeq int64    // sequence returned, relative
+	}{
+		{name: "change already made", after: -2, want: "SET a", wantSeq: -1},
+		{name: "change while waiting", during: []string{"a"}, want: "SET a", wantSeq: 1},
+		{name: "other keys only", during: []string{"b", "b"}, wantSeq: 2},
+		{name: "nothing changed", wantSeq: 0},
+		{name: "namespace dropped", during: []string{"b", "drop"}, want: "DROP_NAMESPACE ", wantSeq: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			if _, err := leader.server.CreateNamespace("t1", kvs.Quota{}); err != nil {
+				t.Fatal(err)
+			}
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			set := func(key string) {
+				if _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: key, Val: "v", Namespace: "t1"}); err != nil {
+					t.Error(err)
+				}
+			}
+			set("a")
+			set("b")
+			base := leader.kvs.LastSequence()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				time.Sleep(50 * time.Millisecond)
+				for _, change := range tt.during {
+					if change == "drop" {
+						if _, _, err := leader.server.DropNamespace("t1"); err != nil {
+							t.Error(err)
+						}
+						continue
+					}
+					set(change)
+				}
+			}()
+			res, err := kvsClient.WaitForChange(ctx, &go_kvs.WaitForChangeRequest{
+				Key:           "a",
+				Namespace:     "t1",
+				AfterSequence: base + tt.after,
+				Timeout:       durationpb.New(300 * time.Millisecond),
+			})
+			<-done
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if res.Changed {
+				got = fmt.Sprintf("%s %s", res.Event.Operation, res.Event.Key)
+			}
+			if got != tt.want || res.Sequence != base+tt.wantSeq {
+				t.Errorf("got %q at seq=%d, want %q at seq=%d", got, res.Sequence-base, tt.want, tt.wantSeq)
+			}
+		})
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix bool
+		want   []string
+	}{
+		{name: "single key", want: []string{"SET a", "DELETE a"}},
+		{name: "prefix", prefix: true, want: []string{"SET a", "SET ab", "DELETE a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := startLeader(t, config.ServerConfig{})
+			kvsClient := go_kvs.NewGoKvsClient(dial(t, leader.addr))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "x", Val: "v"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Resumed after the first write, so the watch sees the writes below
+			// however late it subscribes
+			stream, err := kvsClient.WatchKey(ctx, &go_kvs.WatchKeyRequest{Key: "a", Prefix: tt.prefix, AfterSequence: res.Sequence})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, write := range []func() error{
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "a", Val: "v"}); return err },
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "b", Val: "v"}); return err },
+				func() error { _, err := kvsClient.Set(ctx, &go_kvs.KeyValRequest{Key: "ab", Val: "v"}); return err },
+				func() error { _, err := kvsClient.Del(ctx, &go_kvs.KeyRequest{Key: "a"}); return err },
+			} {
+				if err := write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for len(got) < len(tt.want) {
+				ev, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("after %q: %v", got, err)
+				}
+				got = append(got, fmt.Sprintf("%s %s", ev.Operation, ev.Key))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
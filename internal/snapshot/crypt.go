@@ -0,0 +1,144 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"go-kvs/internal/keyring"
+)
+
+// An encrypted snapshot is the plain snapshot format, split into chunks
+// that are encrypted separately:
+//
+//	magic (8)
+//	chunks: flags (1) | length (4) | sealed chunk (length)
+//
+// Each chunk's index and flags are authenticated with it, so chunks can't
+// be reordered, and a file cut at a chunk boundary is caught by the
+// missing last chunk.
+const (
+	encryptedMagic = "GOKVSSNE"
+	chunkSize      = 64 << 10
+
+	chunkLast = 1 << 0
+)
+
+// ErrNoKeyring is returned for an encrypted snapshot when no keyfile was given
+var ErrNoKeyring = errors.New("snapshot: file is encrypted, no keyfile given")
+
+// encryptWriter encrypts what is written to it in chunks
+type encryptWriter struct {
+	w     io.Writer
+	keys  *keyring.Keyring
+	buf   []byte
+	index uint64
+}
+
+func newEncryptWriter(w io.Writer, keys *keyring.Keyring) *encryptWriter {
+	return &encryptWriter{w: w, keys: keys, buf: make([]byte, 0, chunkSize)}
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(0); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.flush(chunkLast)
+}
+
+func (e *encryptWriter) flush(flags byte) error {
+	if e.index == 0 {
+		if _, err := io.WriteString(e.w, encryptedMagic); err != nil {
+			return err
+		}
+	}
+	sealed := e.keys.Seal(e.buf, chunkAAD(e.index, flags))
+
+	var head [5]byte
+	head[0] = flags
+	binary.BigEndian.PutUint32(head[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(head[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.buf = e.buf[:0]
+	e.index++
+	return nil
+}
+
+// decryptReader reads the plaintext of an encrypted snapshot, after its magic
+type decryptReader struct {
+	r     *bufio.Reader
+	keys  *keyring.Keyring
+	buf   []byte
+	index uint64
+	last  bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk
+func (d *decryptReader) next() error {
+	var head [5]byte
+	if _, err := io.ReadFull(d.r, head[:]); err != nil {
+		return fmt.Errorf("%w: encrypted snapshot is truncated", ErrCorrupt)
+	}
+	flags, length := head[0], binary.BigEndian.Uint32(head[1:])
+	if flags&^chunkLast != 0 || length > chunkSize+keyring.Overhead {
+		return fmt.Errorf("%w: bad chunk header", ErrCorrupt)
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("%w: encrypted snapshot is truncated", ErrCorrupt)
+	}
+	plain, err := d.keys.Open(sealed, chunkAAD(d.index, flags))
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", d.index, err)
+	}
+
+	d.buf = plain
+	d.index++
+	d.last = flags&chunkLast != 0
+	return nil
+}
+
+func chunkAAD(index uint64, flags byte) []byte {
+	var aad [9]byte
+	binary.BigEndian.PutUint64(aad[:8], index)
+	aad[8] = flags
+	return aad[:]
+}
@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go-kvs/internal/datadir"
+	"go-kvs/internal/keyring"
+	"go-kvs/pkg/kvs"
+)
+
+// Load creates the namespaces of the snapshot file at path in store, writes
+// every key in it to them and records the snapshot's sequence, so
+// replication resumes after it. The file is verified first, so a damaged
+// snapshot leaves store untouched. keys decrypts an encrypted snapshot.
+func Load(path string, store *kvs.Kvs, keys *keyring.Keyring) (Meta, error) {
+	meta, err := Verify(path, keys)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Meta{}, err
+	}
+	defer f.Close()
+
+	sr, err := NewReader(f, keys)
+	if err != nil {
+		return Meta{}, err
+	}
+	for _, ns := range meta.Namespaces {
+		if err := store.CreateNamespace(ns.Name, ns.Quota); err != nil {
+			return Meta{}, err
+		}
+	}
+	for {
+		key, val, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Meta{}, err
+		}
+		if err := store.SetIn(sr.Namespace(), key, val); err != nil {
+			return Meta{}, err
+		}
+	}
+
+	if err := store.MarkSequence(meta.Sequence); err != nil {
+		return Meta{}, err
+	}
+	return meta, nil
+}
+
+// Restore seeds the empty data directory dir from the snapshot file at
+// path. The node joins the snapshot's cluster and keeps a copy of the
+// snapshot in its snapshot directory. With keys, the node's WAL and its
+// copy of the snapshot are encrypted with the active key.
+func Restore(path string, dir *datadir.Dir, segmentSize int64, keys *keyring.Keyring) (Meta, error) {
+	meta, err := Verify(path, keys)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	identity, err := dir.Identity()
+	if err != nil {
+		return Meta{}, err
+	}
+	if own := identity.ClusterID(); own != "" && meta.ClusterID != "" && own != meta.ClusterID {
+		return Meta{}, fmt.Errorf("%s belongs to cluster %s, the snapshot to cluster %s", dir.Path(), own, meta.ClusterID)
+	}
+
+	store, err := kvs.New(dir.WALDir(), segmentSize, keys)
+	if err != nil {
+		return Meta{}, err
+	}
+	if store.LastSequence() > 0 || store.Len() > 0 {
+		store.Close()
+		return Meta{}, fmt.Errorf("%s already has data", dir.Path())
+	}
+
+	_, err = Load(path, store, keys)
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if meta.ClusterID != "" {
+		if err := identity.SetClusterID(meta.ClusterID); err != nil {
+			return Meta{}, err
+		}
+	}
+
+	if err := Rewrite(path, filepath.Join(dir.SnapshotDir(), FileName(meta)), keys); err != nil {
+		return Meta{}, err
+	}
+	return meta, nil
+}
+
+// FileName returns the name under which a snapshot is kept in a data directory
+func FileName(meta Meta) string {
+	return fmt.Sprintf("%020d.snap", meta.Sequence)
+}
+
+// Rewrite copies the snapshot at src to dst, decrypting it with keys if
+// it is encrypted and encrypting the copy with the active key, so a
+// snapshot can be moved to a new key or in or out of encryption.
+func Rewrite(src, dst string, keys *keyring.Keyring) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	sr, err := NewReader(in, keys)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	sw := NewWriter(out, sr.Meta(), keys)
+	namespace := kvs.DefaultNamespace
+	for {
+		key, val, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && sr.Namespace() != namespace {
+			namespace = sr.Namespace()
+			err = sw.SetNamespace(namespace)
+		}
+		if err == nil {
+			err = sw.Add(key, val)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(dst)
+			return err
+		}
+	}
+	if err := sw.Close(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
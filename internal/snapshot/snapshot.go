@@ -0,0 +1,403 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"go-kvs/internal/keyring"
+	"go-kvs/pkg/kvs"
+)
+
+// File layout:
+//
+//	magic (8) | version (1) | sequence (8) | created unix nanos (8) | cluster ID (uvarint length + bytes)
+//	namespaces: count (uvarint) | per namespace: name (uvarint length + bytes) | max keys (8) | max bytes (8)
+//	entries:    1 | key (uvarint length + bytes) | value (uvarint length + bytes)
+//	            2 | namespace name (uvarint length + bytes), holding the entries after it
+//	trailer:    0 | entry count (8) | crc32 (4)
+//
+// Entries before the first namespace tag are in the default namespace.
+// Version 1 files have no namespaces. Integers are big-endian. The CRC
+// (Castagnoli) covers everything before it. An encrypted snapshot wraps
+// this format, see crypt.go.
+const (
+	magic   = "GOKVSSNP"
+	version = 2
+
+	tagEntry     = 1
+	tagNamespace = 2
+	tagEnd       = 0
+
+	maxField = 1 << 30 // larger lengths can only come from a damaged file
+)
+
+// ErrCorrupt is returned for a snapshot whose checksum or framing is invalid
+var ErrCorrupt = errors.New("snapshot: corrupt file")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Meta describes a snapshot
+type Meta struct {
+	Sequence  int64     // every write up to this sequence, and none after it, is included
+	Created   time.Time // when the snapshot was taken
+	ClusterID string    // cluster the snapshot was taken from
+	Count     int64     // number of keys, known once the whole snapshot has been read
+	Encrypted bool      // the file is encrypted, set when reading
+
+	// Namespaces other than the default one, which every snapshot has
+	Namespaces []Namespace
+}
+
+// Namespace is a namespace captured in a snapshot
+type Namespace struct {
+	Name  string
+	Quota kvs.Quota
+}
+
+// Writer writes a snapshot file
+type Writer struct {
+	w     *bufio.Writer // writes through to out and crc
+	out   io.Writer
+	enc   *encryptWriter // encrypts out's output, nil for a plain snapshot
+	crc   hash.Hash32
+	count int64
+	buf   [binary.MaxVarintLen64]byte
+}
+
+// NewWriter writes the snapshot header for meta to w, encrypted with the
+// keyring's active key if keys isn't nil. Write errors are returned by Add
+// and Close.
+func NewWriter(w io.Writer, meta Meta, keys *keyring.Keyring) *Writer {
+	sw := &Writer{out: w, crc: crc32.New(crcTable)}
+	if keys != nil {
+		sw.enc = newEncryptWriter(w, keys)
+		sw.out = sw.enc
+	}
+	sw.w = bufio.NewWriter(io.MultiWriter(sw.out, sw.crc))
+
+	sw.w.WriteString(magic)
+	sw.w.WriteByte(version)
+	sw.writeInt(meta.Sequence)
+	sw.writeInt(meta.Created.UnixNano())
+	sw.writeBytes([]byte(meta.ClusterID))
+	n := binary.PutUvarint(sw.buf[:], uint64(len(meta.Namespaces)))
+	sw.w.Write(sw.buf[:n])
+	for _, ns := range meta.Namespaces {
+		sw.writeBytes([]byte(ns.Name))
+		sw.writeInt(ns.Quota.MaxKeys)
+		sw.writeInt(ns.Quota.MaxBytes)
+	}
+	return sw
+}
+
+// SetNamespace makes the entries added after it belong to the namespace
+// name, which must be the default one or listed in the snapshot's Meta
+func (sw *Writer) SetNamespace(name string) error {
+	sw.w.WriteByte(tagNamespace)
+	return sw.writeBytes([]byte(name))
+}
+
+// Add writes one key and its value
+func (sw *Writer) Add(key, val string) error {
+	sw.w.WriteByte(tagEntry)
+	sw.writeBytes([]byte(key))
+	if err := sw.writeBytes([]byte(val)); err != nil {
+		return err
+	}
+	sw.count++
+	return nil
+}
+
+// Close writes the trailer and flushes. It doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	sw.w.WriteByte(tagEnd)
+	if err := sw.writeInt(sw.count); err != nil {
+		return err
+	}
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+
+	// The checksum itself isn't part of what it covers
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], sw.crc.Sum32())
+	if _, err := sw.out.Write(sum[:]); err != nil {
+		return err
+	}
+	if sw.enc != nil {
+		return sw.enc.Close()
+	}
+	return nil
+}
+
+// Count returns the number of keys written so far
+func (sw *Writer) Count() int64 {
+	return sw.count
+}
+
+func (sw *Writer) writeInt(v int64) error {
+	binary.BigEndian.PutUint64(sw.buf[:8], uint64(v))
+	_, err := sw.w.Write(sw.buf[:8])
+	return err
+}
+
+func (sw *Writer) writeBytes(b []byte) error {
+	n := binary.PutUvarint(sw.buf[:], uint64(len(b)))
+	sw.w.Write(sw.buf[:n])
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// Reader reads a snapshot file. The checksum is verified when Next reaches
+// the end, so entries must not be trusted until Next has returned io.EOF.
+type Reader struct {
+	r         *hashReader
+	meta      Meta
+	namespace string // namespace of the entries being read
+	read      int64
+	done      bool
+}
+
+// NewReader reads the snapshot header from r. An encrypted snapshot is
+// decrypted with keys.
+func NewReader(r io.Reader, keys *keyring.Keyring) (*Reader, error) {
+	br := bufio.NewReader(r)
+	encrypted := false
+	if head, _ := br.Peek(len(encryptedMagic)); string(head) == encryptedMagic {
+		if keys == nil {
+			return nil, ErrNoKeyring
+		}
+		br.Discard(len(encryptedMagic))
+		br = bufio.NewReader(&decryptReader{r: br, keys: keys})
+		encrypted = true
+	}
+	sr := &Reader{r: &hashReader{r: br, crc: crc32.New(crcTable)}}
+
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(sr.r, head); err != nil {
+		return nil, readErr(err)
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not a snapshot file", ErrCorrupt)
+	}
+	fileVersion := head[len(magic)]
+	if fileVersion < 1 || fileVersion > version {
+		return nil, fmt.Errorf("snapshot: unsupported version %d", fileVersion)
+	}
+
+	seq, err := sr.readInt()
+	if err != nil {
+		return nil, err
+	}
+	created, err := sr.readInt()
+	if err != nil {
+		return nil, err
+	}
+	clusterID, err := sr.readBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	sr.meta = Meta{Sequence: seq, Created: time.Unix(0, created), ClusterID: string(clusterID), Encrypted: encrypted}
+	if fileVersion >= 2 {
+		if sr.meta.Namespaces, err = sr.readNamespaces(); err != nil {
+			return nil, err
+		}
+	}
+	return sr, nil
+}
+
+func (sr *Reader) readNamespaces() ([]Namespace, error) {
+	count, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, readErr(err)
+	}
+	if count > maxField {
+		return nil, ErrCorrupt
+	}
+
+	var namespaces []Namespace
+	for i := uint64(0); i < count; i++ {
+		name, err := sr.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		maxKeys, err := sr.readInt()
+		if err != nil {
+			return nil, err
+		}
+		maxBytes, err := sr.readInt()
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, Namespace{Name: string(name), Quota: kvs.Quota{MaxKeys: maxKeys, MaxBytes: maxBytes}})
+	}
+	return namespaces, nil
+}
+
+// Meta returns the snapshot's metadata. Count is set once Next has returned io.EOF.
+func (sr *Reader) Meta() Meta {
+	return sr.meta
+}
+
+// Namespace returns the namespace of the entry Next returned last
+func (sr *Reader) Namespace() string {
+	return sr.namespace
+}
+
+// Next returns the next key and value, or io.EOF after the last one once
+// the checksum has been verified
+func (sr *Reader) Next() (string, string, error) {
+	if sr.done {
+		return "", "", io.EOF
+	}
+
+	tag, err := sr.r.ReadByte()
+	if err != nil {
+		return "", "", readErr(err)
+	}
+
+	switch tag {
+	case tagNamespace:
+		name, err := sr.readBytes()
+		if err != nil {
+			return "", "", err
+		}
+		if !sr.declared(string(name)) {
+			return "", "", fmt.Errorf("%w: undeclared namespace %q", ErrCorrupt, name)
+		}
+		sr.namespace = string(name)
+		return sr.Next()
+
+	case tagEntry:
+		key, err := sr.readBytes()
+		if err != nil {
+			return "", "", err
+		}
+		val, err := sr.readBytes()
+		if err != nil {
+			return "", "", err
+		}
+		sr.read++
+		return string(key), string(val), nil
+
+	case tagEnd:
+		count, err := sr.readInt()
+		if err != nil {
+			return "", "", err
+		}
+
+		// The checksum covers everything read so far but not itself
+		want := sr.r.crc.Sum32()
+		var sum [4]byte
+		if _, err := io.ReadFull(sr.r.r, sum[:]); err != nil {
+			return "", "", readErr(err)
+		}
+		if binary.BigEndian.Uint32(sum[:]) != want {
+			return "", "", fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+		}
+		if count != sr.read {
+			return "", "", fmt.Errorf("%w: %d entries, trailer says %d", ErrCorrupt, sr.read, count)
+		}
+		sr.meta.Count = count
+		sr.done = true
+		return "", "", io.EOF
+
+	default:
+		return "", "", fmt.Errorf("%w: unknown tag %d", ErrCorrupt, tag)
+	}
+}
+
+// declared reports whether the header lists the namespace name
+func (sr *Reader) declared(name string) bool {
+	if name == kvs.DefaultNamespace {
+		return true
+	}
+	for _, ns := range sr.meta.Namespaces {
+		if ns.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (sr *Reader) readInt() (int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+		return 0, readErr(err)
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+func (sr *Reader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, readErr(err)
+	}
+	if n > maxField {
+		return nil, ErrCorrupt
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, readErr(err)
+	}
+	return b, nil
+}
+
+// readErr keeps decryption and I/O errors, and reports running out of
+// data as a corrupt file
+func readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	return err
+}
+
+// hashReader checksums exactly the bytes consumed through it
+type hashReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.crc.Write(p[:n])
+	return n, err
+}
+
+func (h *hashReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// Verify reads the whole snapshot file at path and checks its checksum.
+// An encrypted snapshot is decrypted with keys.
+func Verify(path string, keys *keyring.Keyring) (Meta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Meta{}, err
+	}
+	defer f.Close()
+
+	sr, err := NewReader(f, keys)
+	if err != nil {
+		return Meta{}, err
+	}
+	for {
+		if _, _, err := sr.Next(); err == io.EOF {
+			return sr.Meta(), nil
+		} else if err != nil {
+			return Meta{}, err
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-kvs/internal/keyring"
+	"go-kvs/pkg/kvs"
+)
+
+// entry is a key read from a snapshot with its namespace
+type entry struct {
+	ns, key, val string
+}
+
+// testKeys returns a keyring with one AES-128 key
+func testKeys(t *testing.T) *keyring.Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("1 000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// writeV1 builds a version 1 snapshot, which has no namespaces
+func writeV1(seq int64, clusterID string, pairs [][2]string) []byte {
+	var buf bytes.Buffer
+	putInt := func(v int64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(v))
+		buf.Write(b[:])
+	}
+	putBytes := func(s string) {
+		var b [binary.MaxVarintLen64]byte
+		buf.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+		buf.WriteString(s)
+	}
+
+	buf.WriteString(magic)
+	buf.WriteByte(1)
+	putInt(seq)
+	putInt(time.Unix(1700000000, 0).UnixNano())
+	putBytes(clusterID)
+	for _, kv := range pairs {
+		buf.WriteByte(tagEntry)
+		putBytes(kv[0])
+		putBytes(kv[1])
+	}
+	buf.WriteByte(tagEnd)
+	putInt(int64(len(pairs)))
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf.Bytes(), crcTable))
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// writeV2 writes a current snapshot of entries, grouped by namespace
+func writeV2(t *testing.T, meta Meta, entries []entry, keys *keyring.Keyring) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	sw := NewWriter(&buf, meta, keys)
+	ns := kvs.DefaultNamespace
+	for _, e := range entries {
+		if e.ns != ns {
+			if err := sw.SetNamespace(e.ns); err != nil {
+				t.Fatal(err)
+			}
+			ns = e.ns
+		}
+		if err := sw.Add(e.key, e.val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readSnapshot reads every entry of a snapshot
+func readSnapshot(data []byte, keys *keyring.Keyring) (Meta, []entry, error) {
+	sr, err := NewReader(bytes.NewReader(data), keys)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	var entries []entry
+	for {
+		key, val, err := sr.Next()
+		if err == io.EOF {
+			return sr.Meta(), entries, nil
+		}
+		if err != nil {
+			return Meta{}, nil, err
+		}
+		entries = append(entries, entry{sr.Namespace(), key, val})
+	}
+}
+
+func TestReadVersions(t *testing.T) {
+	keys := testKeys(t)
+	meta := Meta{
+		Sequence:   42,
+		Created:    time.Unix(1700000000, 0),
+		ClusterID:  "cluster",
+		Namespaces: []Namespace{{Name: "app", Quota: kvs.Quota{MaxKeys: 10, MaxBytes: 1 << 20}}, {Name: "empty"}},
+	}
+	v2 := []entry{{"", "a", "1"}, {"", "b", ""}, {"app", "a", "other 1"}, {"app", "c", "3"}}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		keys       *keyring.Keyring
+		want       []entry
+		namespaces []Namespace
+		encrypted  bool
+	}{
+		{
+			name: "version 1",
+			data: writeV1(42, "cluster", [][2]string{{"a", "1"}, {"b", ""}}),
+			want: []entry{{"", "a", "1"}, {"", "b", ""}},
+		},
+		{
+			name: "version 1 empty",
+			data: writeV1(42, "cluster", nil),
+		},
+		{
+			name:       "version 2",
+			data:       writeV2(t, meta, v2, nil),
+			want:       v2,
+			namespaces: meta.Namespaces,
+		},
+		{
+			name:       "version 2 encrypted",
+			data:       writeV2(t, meta, v2, keys),
+			keys:       keys,
+			want:       v2,
+			namespaces: meta.Namespaces,
+			encrypted:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, entries, err := readSnapshot(tt.data, tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %q, want %q", entries, tt.want)
+			}
+			if got.Sequence != 42 || got.ClusterID != "cluster" || !got.Created.Equal(meta.Created) {
+				t.Errorf("meta = %+v", got)
+			}
+			if got.Count != int64(len(tt.want)) {
+				t.Errorf("count = %d, want %d", got.Count, len(tt.want))
+			}
+			if !reflect.DeepEqual(got.Namespaces, tt.namespaces) {
+				t.Errorf("namespaces = %+v, want %+v", got.Namespaces, tt.namespaces)
+			}
+			if got.Encrypted != tt.encrypted {
+				t.Errorf("encrypted = %v, want %v", got.Encrypted, tt.encrypted)
+			}
+		})
+	}
+}
+
+func TestReadDamaged(t *testing.T) {
+	keys := testKeys(t)
+	meta := Meta{Sequence: 7, Namespaces: []Namespace{{Name: "app"}}}
+	entries := []entry{{"", "a", "1"}, {"app", "b", "2"}}
+	plain := writeV2(t, meta, entries, nil)
+	encrypted := writeV2(t, meta, entries, keys)
+
+	tests := []struct {
+		name string
+		data []byte
+		keys *keyring.Keyring
+		want error
+	}{
+		{"not a snapshot", []byte("GOKVSWAL........"), nil, ErrCorrupt},
+		{"flipped byte", flip(plain, len(plain)-6), nil, ErrCorrupt},
+		{"bad checksum", flip(plain, len(plain)-1), nil, ErrCorrupt},
+		{"cut short", plain[:len(plain)-3], nil, ErrCorrupt},
+		{"v1 flipped byte", flip(writeV1(1, "", [][2]string{{"a", "1"}}), 30), nil, ErrCorrupt},
+		{"undeclared namespace", writeV2(t, Meta{}, entries, nil), nil, ErrCorrupt},
+		{"encrypted without keyfile", encrypted, nil, ErrNoKeyring},
+		{"encrypted flipped byte", flip(encrypted, len(encrypted)/2), keys, keyring.ErrDecrypt},
+		{"encrypted cut at a chunk", encrypted[:len(encryptedMagic)], keys, ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := readSnapshot(tt.data, tt.keys); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	future := append([]byte(nil), plain...)
+	future[len(magic)] = version + 1
+	if _, _, err := readSnapshot(future, nil); err == nil {
+		t.Error("read a snapshot from a newer version")
+	}
+}
+
+// flip returns a copy of data with the byte at i changed
+func flip(data []byte, i int) []byte {
+	out := append([]byte(nil), data...)
+	out[i] ^= 0xff
+	return out
+}
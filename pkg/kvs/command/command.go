@@ -6,13 +6,16 @@ import (
 )
 
 type Cmd struct {
-	Cmd string
-	Key string
-	Val string
+	Cmd       string
+	Key       string
+	Val       string
+	Seq       int64  // replication sequence of the write, 0 if not replicated
+	Time      int64  // when the write was committed (unix nanos), 0 in logs from older versions
+	Namespace string // namespace of the key, "" for the default one and in logs from older versions
 }
 
 func New(cmd, key, val string) Cmd {
-	return Cmd{cmd, key, val}
+	return Cmd{Cmd: cmd, Key: key, Val: val}
 }
 
 func (cmd *Cmd) GetVal() (string, error) {
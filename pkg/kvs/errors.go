@@ -0,0 +1,69 @@
+package kvs
+
+import (
+	"errors"
+
+	"go-kvs/internal/server/wal"
+)
+
+// Errors returned by the store, tested for with errors.Is. The server sends
+// them as gRPC status codes with the error's reason attached, and the
+// client library turns them back into the same errors.
+var (
+	// ErrNotFound is returned for a key that doesn't exist
+	ErrNotFound = errors.New("key doesn't exist")
+	// ErrCorrupt is returned when the WAL record holding a value is damaged:
+	// its checksum, framing, decryption or decoding fails
+	ErrCorrupt = errors.New("corrupt data")
+	// ErrKeyUnavailable is returned for an encrypted value whose key isn't loaded
+	ErrKeyUnavailable = errors.New("encryption key unavailable")
+	// ErrReadOnly is returned for writes after a WAL write has failed
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrFull is returned for writes that would take the WAL past its size limit
+	ErrFull = wal.ErrFull
+	// ErrClosed is returned once the store has been closed
+	ErrClosed = errors.New("store is closed")
+	// ErrNamespaceNotFound is returned for a namespace that doesn't exist
+	ErrNamespaceNotFound = errors.New("namespace doesn't exist")
+	// ErrNamespaceExists is returned when creating a namespace that already exists
+	ErrNamespaceExists = errors.New("namespace already exists")
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail the server attaches to
+// these errors; its reason is the error's Reason
+const ErrorDomain = "go-kvs"
+
+// reasons names each error for the details of a gRPC status
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrNotFound, "NOT_FOUND"},
+	{ErrCorrupt, "CORRUPT"},
+	{ErrKeyUnavailable, "KEY_UNAVAILABLE"},
+	{ErrReadOnly, "READ_ONLY"},
+	{ErrFull, "FULL"},
+	{ErrClosed, "CLOSED"},
+	{ErrNamespaceNotFound, "NAMESPACE_NOT_FOUND"},
+	{ErrNamespaceExists, "NAMESPACE_EXISTS"},
+}
+
+// Reason returns the name of the store error err wraps, "" if it wraps none
+func Reason(err error) string {
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// FromReason returns the store error named reason, nil if there is none
+func FromReason(reason string) error {
+	for _, r := range reasons {
+		if r.reason == reason {
+			return r.err
+		}
+	}
+	return nil
+}
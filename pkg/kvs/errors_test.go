@@ -0,0 +1,125 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-kvs/internal/server/wal"
+)
+
+func TestStoreErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(t *testing.T, k *Kvs, dir string) error
+		want error
+	}{
+		{
+			name: "get missing key",
+			op: func(t *testing.T, k *Kvs, _ string) error {
+				_, err := k.Get("missing")
+				return err
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "delete missing key",
+			op:   func(t *testing.T, k *Kvs, _ string) error { return k.Del("missing") },
+			want: ErrNotFound,
+		},
+		{
+			name: "get from missing namespace",
+			op: func(t *testing.T, k *Kvs, _ string) error {
+				_, err := k.GetIn("missing", "a")
+				return err
+			},
+			want: ErrNamespaceNotFound,
+		},
+		{
+			name: "get damaged value",
+			op: func(t *testing.T, k *Kvs, dir string) error {
+				// Flip the last byte of a's record, inside its payload
+				path := filepath.Join(dir, wal.SegmentName(0))
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				_, err = k.Get("a")
+				return err
+			},
+			want: ErrCorrupt,
+		},
+		{
+			name: "write past the size limit",
+			op: func(t *testing.T, k *Kvs, _ string) error {
+				k.SetMaxSize(k.Size() + 1)
+				return k.Set("b", "v")
+			},
+			want: ErrFull,
+		},
+		{
+			name: "get after close",
+			op: func(t *testing.T, k *Kvs, _ string) error {
+				k.Close()
+				_, err := k.Get("a")
+				return err
+			},
+			want: ErrClosed,
+		},
+		{
+			name: "write after close",
+			op: func(t *testing.T, k *Kvs, _ string) error {
+				k.Close()
+				return k.Set("b", "v")
+			},
+			want: ErrClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			k, err := New(dir, 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+			must(t, k.Set("a", "value"))
+
+			err = tt.op(t, k, dir)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if reason := Reason(err); FromReason(reason) != tt.want {
+				t.Errorf("reason %q names %v, want %v", reason, FromReason(reason), tt.want)
+			}
+		})
+	}
+}
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w, key: a", ErrNotFound), "NOT_FOUND"},
+		{fmt.Errorf("%w: wal offset 0: bad checksum", ErrCorrupt), "CORRUPT"},
+		{ErrNamespaceExists, "NAMESPACE_EXISTS"},
+		{wal.ErrFull, "FULL"},
+		{errors.New("disk on fire"), ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := Reason(tt.err); got != tt.want {
+			t.Errorf("Reason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+	if err := FromReason("UNKNOWN"); err != nil {
+		t.Errorf("FromReason(UNKNOWN) = %v, want nil", err)
+	}
+}
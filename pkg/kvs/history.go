@@ -0,0 +1,102 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"go-kvs/pkg/kvs/command"
+)
+
+// ErrHistoryUnavailable is returned by History for changes the WAL no
+// longer holds one by one, i.e. from before a compaction or snapshot load
+var ErrHistoryUnavailable = errors.New("changes are no longer in the WAL")
+
+// Change is a set, del or ns-drop read back from the WAL, with the value
+// the key had before it
+type Change struct {
+	Cmd      command.Cmd
+	Sequence int64
+	OldVal   string
+	HadOld   bool
+}
+
+// History calls fn for every change with a sequence after afterSeq, up to
+// and including upTo, in log order. Sets and dels are passed only for keys
+// that match; namespace drops always. The WAL is read from the start to
+// recover the previous values of the matching keys.
+func (k *Kvs) History(afterSeq, upTo int64, match func(ns, key string) bool, fn func(Change) error) error {
+	var (
+		offset  int64
+		current int64 // sequence the scan is at
+		// Until the next sequence marker, the log holds a compacted or
+		// loaded copy of the data instead of the changes that made it
+		copied = k.wal.Base() > 0
+		values = make(map[string]map[string]string) // matching keys by namespace
+	)
+	for {
+		if k.closed.Load() {
+			return ErrClosed
+		}
+		cmdBytes, next, err := k.wal.Read(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return readError(offset, err)
+		}
+		cmd, err := command.Deserialize(cmdBytes)
+		if err != nil {
+			return fmt.Errorf("%w: wal offset %d: %v", ErrCorrupt, offset, err)
+		}
+		offset = next
+
+		// Records without a sequence belong with the last sequence before them
+		if cmd.Seq > 0 {
+			current = cmd.Seq
+		}
+		if current > upTo {
+			return nil
+		}
+		switch {
+		case cmd.Cmd == "seq" && copied:
+			copied = false
+			if afterSeq < current {
+				return fmt.Errorf("%w: the log holds changes after seq=%d", ErrHistoryUnavailable, current)
+			}
+		case cmd.Seq == 0 && (cmd.Cmd == "set" || cmd.Cmd == "del"):
+			copied = true // written by a snapshot load
+		}
+		if copied && current > afterSeq {
+			return fmt.Errorf("%w: the log holds a copy of the data at seq=%d", ErrHistoryUnavailable, current)
+		}
+
+		change := Change{Cmd: cmd, Sequence: current}
+		switch cmd.Cmd {
+		case "set", "del":
+			if !match(cmd.Namespace, cmd.Key) {
+				continue
+			}
+			nsValues := values[cmd.Namespace]
+			if nsValues == nil {
+				nsValues = make(map[string]string)
+				values[cmd.Namespace] = nsValues
+			}
+			change.OldVal, change.HadOld = nsValues[cmd.Key]
+			if cmd.Cmd == "set" {
+				nsValues[cmd.Key] = cmd.Val
+			} else {
+				delete(nsValues, cmd.Key)
+			}
+		case "ns-drop":
+			delete(values, cmd.Namespace)
+		default:
+			continue
+		}
+		if current > afterSeq {
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -4,27 +4,42 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"go-kvs/internal/keyring"
 	"go-kvs/internal/server/wal"
 	"go-kvs/pkg/kvs/command"
 	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Kvs struct {
-	index map[string]int64
-	wal   wal.WAL
+	namespaces map[string]*namespace
+	wal        wal.WAL
+	sequence   int64 // highest replication sequence written to the WAL
+	maxSize    int64 // WAL size limit, 0 = none
+	failed     error // the WAL write that made the store read-only
+	closed     atomic.Bool
+	mu         sync.RWMutex
 }
 
-func New(fileName string) (*Kvs, error) {
-	index := make(map[string]int64)
-	wall, err := wal.New(fileName, index)
+// New opens the store whose write-ahead log segments are in walDir. With
+// keys, new WAL records are encrypted with its active key; nil keeps them
+// in plaintext.
+func New(walDir string, segmentSize int64, keys *keyring.Keyring) (*Kvs, error) {
+	wall, err := wal.New(walDir, nil)
 	if err != nil {
 		return nil, err
 	}
+	wall.SetSegmentSize(segmentSize)
+	wall.SetKeyring(keys)
 
 	k := Kvs{
-		index: index,
-		wal:   wall,
+		namespaces: map[string]*namespace{DefaultNamespace: newNamespace(Quota{}, 0)},
+		wal:        wall,
 	}
 
 	err = k.Init()
@@ -43,7 +58,7 @@ func (k *Kvs) Init() error {
 			if readErr == bufio.ErrFinalToken || readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
 				break
 			}
-			return readErr
+			return fmt.Errorf("wal offset %d: %w", offset, readErr)
 		}
 
 		// Create a buffer to read the encoded data
@@ -59,15 +74,10 @@ func (k *Kvs) Init() error {
 			if dErr == io.EOF {
 				break
 			}
-			return dErr
+			return fmt.Errorf("wal offset %d: %w (inspect with kvs-wal)", offset, dErr)
 		}
 
-		switch cmd.Cmd {
-		case "set":
-			k.index[cmd.Key] = offset
-		case "del":
-			delete(k.index, cmd.Key)
-		}
+		k.apply(cmd, offset)
 
 		offset = newOffset
 	}
@@ -76,73 +86,360 @@ func (k *Kvs) Init() error {
 }
 
 func (k *Kvs) Set(key, val string) error {
-	// create Cmd object and serialize to bytes
+	return k.SetInAt(DefaultNamespace, key, val, 0)
+}
+
+// SetAt sets key and records seq as its replication sequence in the same
+// WAL record, so the data and the sequence are durable together
+func (k *Kvs) SetAt(key, val string, seq int64) error {
+	return k.SetInAt(DefaultNamespace, key, val, seq)
+}
+
+// SetIn sets key in the namespace ns
+func (k *Kvs) SetIn(ns, key, val string) error {
+	return k.SetInAt(ns, key, val, 0)
+}
+
+// SetInAt sets key in the namespace ns and records seq as its replication
+// sequence, like SetAt
+func (k *Kvs) SetInAt(ns, key, val string, seq int64) error {
 	cmd := command.New("set", key, val)
+	cmd.Namespace = ns
+	cmd.Seq = seq
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+func (k *Kvs) Del(key string) error {
+	return k.DelInAt(DefaultNamespace, key, 0)
+}
+
+// DelAt deletes key and records seq as its replication sequence
+func (k *Kvs) DelAt(key string, seq int64) error {
+	return k.DelInAt(DefaultNamespace, key, seq)
+}
+
+// DelIn deletes key from the namespace ns
+func (k *Kvs) DelIn(ns, key string) error {
+	return k.DelInAt(ns, key, 0)
+}
+
+// DelInAt deletes key from the namespace ns and records seq as its
+// replication sequence
+func (k *Kvs) DelInAt(ns, key string, seq int64) error {
+	cmd := command.New("del", key, "")
+	cmd.Namespace = ns
+	cmd.Seq = seq
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+// MarkSequence records seq without changing any key, for replicated
+// commands that don't apply to this node (e.g. filtered out)
+func (k *Kvs) MarkSequence(seq int64) error {
+	cmd := command.New("seq", "", "")
+	cmd.Seq = seq
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+// Write applies a set, del, seq or namespace command. It returns the command's bytes
+// as stored in the WAL, compressed but not encrypted, and their codec, so
+// the leader can replicate exactly what it stored.
+func (k *Kvs) Write(cmd command.Cmd) ([]byte, wal.Codec, error) {
 	cmdBytes, err := cmd.Serialize()
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+
+	data, codec := k.wal.Encode(cmdBytes)
+	if err := k.WriteEncoded(cmd, data, codec); err != nil {
+		return nil, 0, err
+	}
+	return data, codec, nil
+}
+
+// WriteEncoded applies cmd, storing data, its serialized form compressed
+// with codec, in the WAL as is. Replicas use it to store the bytes the
+// leader stored.
+func (k *Kvs) WriteEncoded(cmd command.Cmd, data []byte, codec wal.Codec) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed.Load() {
+		return ErrClosed
+	}
+	if k.failed != nil {
+		return fmt.Errorf("%w after a failed WAL write: %v", ErrReadOnly, k.failed)
+	}
+	switch cmd.Cmd {
+	case "set", "del":
+		ns, err := k.namespace(cmd.Namespace)
+		if err != nil {
+			return err
+		}
+		if _, exists := ns.index[cmd.Key]; !exists && cmd.Cmd == "del" {
+			return fmt.Errorf("%w, key: %s", ErrNotFound, cmd.Key)
+		}
+	default:
+		if err := k.checkNamespaceCmd(cmd); err != nil {
+			return err
+		}
 	}
 
-	// append Cmd bytes to file and store offset in memory index
-	offset, err := k.wal.Append(cmdBytes)
+	// append Cmd bytes to file and update the in-memory index
+	offset, err := k.wal.AppendEncoded(data, codec)
 	if err != nil {
+		// A write the disk refused may have left the log in any state, so
+		// stop writing rather than risk losing acknowledged writes. A
+		// record refused for the size limit was never written.
+		if !errors.Is(err, ErrFull) {
+			k.failed = err
+		}
 		return err
 	}
-	k.index[key] = offset
+	k.apply(cmd, offset)
 
 	return nil
 }
 
-func (k *Kvs) Del(key string) error {
-	_, exists := k.index[key]
-	if !exists {
-		return fmt.Errorf("key doesn't exist")
+// apply updates the namespaces for cmd, stored at offset. Records of a
+// namespace that no longer exists are ones written before it was dropped.
+// Caller must hold mu.
+func (k *Kvs) apply(cmd command.Cmd, offset int64) {
+	switch cmd.Cmd {
+	case "set":
+		if ns := k.namespaces[cmd.Namespace]; ns != nil {
+			size := int64(len(cmd.Key) + len(cmd.Val))
+			ns.bytes += size - ns.index[cmd.Key].size
+			ns.index[cmd.Key] = entry{offset: offset, size: size}
+			ns.writes++
+		}
+	case "del":
+		if ns := k.namespaces[cmd.Namespace]; ns != nil {
+			ns.bytes -= ns.index[cmd.Key].size
+			delete(ns.index, cmd.Key)
+			ns.writes++
+		}
+	case "ns-create":
+		quota, _ := ParseQuota(cmd.Val)
+		k.namespaces[cmd.Namespace] = newNamespace(quota, cmd.Time)
+	case "ns-quota":
+		if ns := k.namespaces[cmd.Namespace]; ns != nil {
+			ns.quota, _ = ParseQuota(cmd.Val)
+		}
+	case "ns-drop":
+		if cmd.Namespace != DefaultNamespace {
+			delete(k.namespaces, cmd.Namespace)
+		}
 	}
+	k.advance(cmd.Seq)
+}
 
-	cmd := command.New("del", key, "")
-	cmdBytes, err := cmd.Serialize()
-	if err != nil {
-		return err
+// SetCompression makes the WAL compress new records of at least minSize
+// bytes with codec
+func (k *Kvs) SetCompression(codec wal.Codec, minSize int) {
+	k.wal.SetCompression(codec, minSize)
+}
+
+// SetMaxSize limits the total size of the WAL; writes that would exceed
+// it fail with wal.ErrFull. 0 removes the limit.
+func (k *Kvs) SetMaxSize(max int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.maxSize = max
+	k.wal.SetMaxSize(max)
+}
+
+// SpaceLeft returns how many more bytes the WAL may grow by, and false if
+// its size isn't limited
+func (k *Kvs) SpaceLeft() (int64, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.maxSize == 0 {
+		return 0, false
 	}
+	return k.maxSize - k.wal.Size(), true
+}
 
-	// append Cmd bytes to file and delete from index
-	_, err = k.wal.Append(cmdBytes)
-	if err != nil {
-		return err
+// Size returns the total size of the WAL
+func (k *Kvs) Size() int64 {
+	return k.wal.Size()
+}
+
+// Len returns the number of keys in every namespace
+func (k *Kvs) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	n := 0
+	for _, ns := range k.namespaces {
+		n += len(ns.index)
 	}
-	delete(k.index, key)
+	return n
+}
 
-	return nil
+// SetArchiveDir makes the WAL copy its sealed segments into dir
+func (k *Kvs) SetArchiveDir(dir string) error {
+	archiver, ok := k.wal.(interface{ SetArchiveDir(string) error })
+	if !ok {
+		return fmt.Errorf("the write-ahead log doesn't support archiving")
+	}
+	return archiver.SetArchiveDir(dir)
 }
 
-func (k *Kvs) Get(key string) (string, error) {
-	offset, exists := k.index[key]
-	if !exists {
-		return "", fmt.Errorf("key doesn't exist, key: %s", key)
+// LastSequence returns the highest replication sequence in the WAL
+func (k *Kvs) LastSequence() int64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.sequence
+}
+
+func (k *Kvs) advance(seq int64) {
+	if seq > k.sequence {
+		k.sequence = seq
 	}
+}
 
-	cmdBytes, _, err := k.wal.Read(offset)
+func (k *Kvs) Get(key string) (string, error) {
+	return k.GetIn(DefaultNamespace, key)
+}
+
+// GetIn returns the value of key in the namespace ns
+func (k *Kvs) GetIn(ns, key string) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	n, err := k.namespace(ns)
 	if err != nil {
 		return "", err
 	}
+	e, exists := n.index[key]
+	if !exists {
+		return "", fmt.Errorf("%w, key: %s", ErrNotFound, key)
+	}
 
-	cmd, err := command.Deserialize(cmdBytes)
+	cmd, err := k.read(e.offset)
 	if err != nil {
 		return "", err
 	}
 
 	val, err := cmd.GetVal()
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return val, nil
 }
 
+// Lookup returns the value of key and whether it exists
+func (k *Kvs) Lookup(key string) (string, bool, error) {
+	return k.LookupIn(DefaultNamespace, key)
+}
+
+// LookupIn returns the value of key in the namespace ns and whether it exists
+func (k *Kvs) LookupIn(ns, key string) (string, bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	n, err := k.namespace(ns)
+	if err != nil {
+		return "", false, err
+	}
+	e, exists := n.index[key]
+	if !exists {
+		return "", false, nil
+	}
+
+	cmd, err := k.read(e.offset)
+	if err != nil {
+		return "", false, err
+	}
+
+	return cmd.Val, true, nil
+}
+
+// read returns the command in the WAL record at offset. The index only
+// points at records that were read back on startup or written since, so
+// any failure means the record was damaged.
+func (k *Kvs) read(offset int64) (command.Cmd, error) {
+	if k.closed.Load() {
+		return command.Cmd{}, ErrClosed
+	}
+
+	cmdBytes, _, err := k.wal.Read(offset)
+	if err != nil {
+		return command.Cmd{}, readError(offset, err)
+	}
+
+	cmd, err := command.Deserialize(cmdBytes)
+	if err != nil {
+		return command.Cmd{}, fmt.Errorf("%w: wal offset %d: %v", ErrCorrupt, offset, err)
+	}
+	return cmd, nil
+}
+
+// readError classifies an error reading the WAL record at offset. Damaged
+// records are ErrCorrupt and records encrypted with a key that isn't loaded
+// ErrKeyUnavailable; I/O errors are wrapped as they are.
+func readError(offset int64, err error) error {
+	switch {
+	case errors.Is(err, wal.ErrCorrupt), errors.Is(err, keyring.ErrDecrypt),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: wal offset %d: %v", ErrCorrupt, offset, err)
+	case errors.Is(err, wal.ErrNoKeyring), errors.Is(err, keyring.ErrUnknownKey):
+		return fmt.Errorf("%w: wal offset %d: %v", ErrKeyUnavailable, offset, err)
+	case errors.Is(err, os.ErrClosed):
+		return ErrClosed
+	}
+	return fmt.Errorf("wal offset %d: %w", offset, err)
+}
+
 func (k *Kvs) Keys() []string {
-	keys := make([]string, 0, len(k.index))
-	for key := range k.index {
+	keys, _ := k.KeysIn(DefaultNamespace) // the default namespace always exists
+	return keys
+}
+
+// KeysIn returns the keys in the namespace ns
+func (k *Kvs) KeysIn(ns string) ([]string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	n, err := k.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(n.index))
+	for key := range n.index {
 		keys = append(keys, key)
 	}
-	return keys
+	return keys, nil
+}
+
+// Close flushes the WAL to disk and closes it. The store can't be used afterwards.
+func (k *Kvs) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closed.Store(true)
+	return k.wal.Close()
+}
+
+// Scan calls fn for every key with the given prefix, in key order.
+// Offsets are captured under the lock; values are read afterwards, so a
+// slow consumer doesn't block writers.
+func (k *Kvs) Scan(prefix string, fn func(key, val string) error) error {
+	return k.Snapshot(prefix).Each(fn)
+}
+
+// ScanIn calls fn for every key in the namespace ns with the given prefix,
+// like Scan
+func (k *Kvs) ScanIn(ns, prefix string, fn func(key, val string) error) error {
+	snap, err := k.SnapshotIn(ns, prefix)
+	if err != nil {
+		return err
+	}
+	return snap.Each(fn)
 }
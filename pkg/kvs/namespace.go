@@ -0,0 +1,181 @@
+package kvs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go-kvs/pkg/kvs/command"
+)
+
+// DefaultNamespace holds the keys of requests that don't name a namespace.
+// It always exists and can't be dropped.
+const DefaultNamespace = ""
+
+// maxNamespaceLen is the longest namespace name allowed
+const maxNamespaceLen = 64
+
+// Quota limits a namespace. Zero means no limit.
+type Quota struct {
+	MaxKeys  int64
+	MaxBytes int64 // keys and values, uncompressed
+}
+
+// String encodes the quota as the value of ns-create and ns-quota commands
+func (q Quota) String() string {
+	return fmt.Sprintf("%d %d", q.MaxKeys, q.MaxBytes)
+}
+
+// ParseQuota decodes a quota encoded by String
+func ParseQuota(s string) (Quota, error) {
+	var q Quota
+	if s == "" {
+		return q, nil
+	}
+	if _, err := fmt.Sscanf(s, "%d %d", &q.MaxKeys, &q.MaxBytes); err != nil {
+		return Quota{}, fmt.Errorf("bad quota %q: %w", s, err)
+	}
+	return q, nil
+}
+
+// NamespaceInfo describes a namespace and what it holds
+type NamespaceInfo struct {
+	Name    string
+	Quota   Quota
+	Keys    int
+	Bytes   int64     // keys and values, uncompressed
+	Writes  int64     // sets and deletes applied since the store was opened, including WAL replay
+	Created time.Time // zero for the default namespace
+}
+
+// namespace is the index and statistics of one namespace
+type namespace struct {
+	index   map[string]entry
+	quota   Quota
+	bytes   int64
+	writes  int64
+	created int64 // unix nanos
+}
+
+// entry locates the WAL record holding a key's value
+type entry struct {
+	offset int64
+	size   int64 // key and value bytes, uncompressed
+}
+
+func newNamespace(quota Quota, created int64) *namespace {
+	return &namespace{index: make(map[string]entry), quota: quota, created: created}
+}
+
+func (n *namespace) info(name string) NamespaceInfo {
+	info := NamespaceInfo{Name: name, Quota: n.quota, Keys: len(n.index), Bytes: n.bytes, Writes: n.writes}
+	if n.created > 0 {
+		info.Created = time.Unix(0, n.created)
+	}
+	return info
+}
+
+// ValidNamespace reports whether name can be given to a new namespace:
+// 1 to 64 letters, digits, '-', '_' or '.'
+func ValidNamespace(name string) bool {
+	if name == "" || len(name) > maxNamespaceLen {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CreateNamespace creates the namespace name with quota
+func (k *Kvs) CreateNamespace(name string, quota Quota) error {
+	cmd := command.New("ns-create", "", quota.String())
+	cmd.Namespace = name
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+// SetQuota replaces the quota of the namespace name
+func (k *Kvs) SetQuota(name string, quota Quota) error {
+	cmd := command.New("ns-quota", "", quota.String())
+	cmd.Namespace = name
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+// DropNamespace drops the namespace name and every key in it. Only one
+// WAL record is written; the dropped records are reclaimed by compaction.
+func (k *Kvs) DropNamespace(name string) error {
+	cmd := command.New("ns-drop", "", "")
+	cmd.Namespace = name
+	cmd.Time = time.Now().UnixNano()
+	_, _, err := k.Write(cmd)
+	return err
+}
+
+// Namespace returns the quota and statistics of the namespace name
+func (k *Kvs) Namespace(name string) (NamespaceInfo, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ns, err := k.namespace(name)
+	if err != nil {
+		return NamespaceInfo{}, err
+	}
+	return ns.info(name), nil
+}
+
+// Namespaces returns every namespace in name order, the default one first
+func (k *Kvs) Namespaces() []NamespaceInfo {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	infos := make([]NamespaceInfo, 0, len(k.namespaces))
+	for name, ns := range k.namespaces {
+		infos = append(infos, ns.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// namespace returns the namespace name. Caller must hold mu.
+func (k *Kvs) namespace(name string) (*namespace, error) {
+	ns, exists := k.namespaces[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	return ns, nil
+}
+
+// checkNamespaceCmd rejects a namespace command that can't be applied.
+// Caller must hold mu.
+func (k *Kvs) checkNamespaceCmd(cmd command.Cmd) error {
+	switch cmd.Cmd {
+	case "ns-create":
+		if _, exists := k.namespaces[cmd.Namespace]; exists {
+			return fmt.Errorf("%w: %s", ErrNamespaceExists, cmd.Namespace)
+		}
+	case "ns-quota":
+		if _, err := k.namespace(cmd.Namespace); err != nil {
+			return err
+		}
+	case "ns-drop":
+		if cmd.Namespace == DefaultNamespace {
+			return fmt.Errorf("the default namespace can't be dropped")
+		}
+		_, err := k.namespace(cmd.Namespace)
+		return err
+	default:
+		return nil
+	}
+	_, err := ParseQuota(cmd.Val)
+	return err
+}
@@ -0,0 +1,174 @@
+package kvs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// reopen closes k and replays its WAL through Init into a new store
+func reopen(t *testing.T, k *Kvs, dir string) *Kvs {
+	t.Helper()
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	k, err := New(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func TestNamespaceReplay(t *testing.T) {
+	type check struct {
+		ns      string
+		keys    []string          // nil: the namespace doesn't exist
+		values  map[string]string // a subset of keys
+		quota   Quota
+		missing []string // keys that must not be found
+	}
+
+	tests := []struct {
+		name   string
+		writes func(t *testing.T, k *Kvs)
+		checks []check
+	}{
+		{
+			name: "create and write",
+			writes: func(t *testing.T, k *Kvs) {
+				must(t, k.CreateNamespace("app", Quota{MaxKeys: 10}))
+				must(t, k.SetIn("app", "a", "1"))
+				must(t, k.Set("a", "default"))
+			},
+			checks: []check{
+				{ns: "app", keys: []string{"a"}, values: map[string]string{"a": "1"}, quota: Quota{MaxKeys: 10}},
+				{ns: DefaultNamespace, keys: []string{"a"}, values: map[string]string{"a": "default"}},
+			},
+		},
+		{
+			name: "drop",
+			writes: func(t *testing.T, k *Kvs) {
+				must(t, k.CreateNamespace("app", Quota{}))
+				must(t, k.SetIn("app", "a", "1"))
+				must(t, k.DropNamespace("app"))
+			},
+			checks: []check{
+				{ns: "app"},
+				{ns: DefaultNamespace, keys: []string{}},
+			},
+		},
+		{
+			name: "drop and recreate",
+			writes: func(t *testing.T, k *Kvs) {
+				must(t, k.CreateNamespace("app", Quota{MaxKeys: 5}))
+				must(t, k.SetIn("app", "old", "1"))
+				must(t, k.SetIn("app", "kept", "old value"))
+				must(t, k.Set("old", "default"))
+				must(t, k.DropNamespace("app"))
+				must(t, k.CreateNamespace("app", Quota{MaxBytes: 1000}))
+				must(t, k.SetIn("app", "kept", "new value"))
+			},
+			checks: []check{
+				{ns: "app", keys: []string{"kept"}, values: map[string]string{"kept": "new value"}, quota: Quota{MaxBytes: 1000}, missing: []string{"old"}},
+				{ns: DefaultNamespace, keys: []string{"old"}, values: map[string]string{"old": "default"}},
+			},
+		},
+		{
+			name: "delete in a dropped namespace's successor",
+			writes: func(t *testing.T, k *Kvs) {
+				must(t, k.CreateNamespace("app", Quota{}))
+				must(t, k.SetIn("app", "a", "1"))
+				must(t, k.DropNamespace("app"))
+				must(t, k.CreateNamespace("app", Quota{}))
+				must(t, k.SetIn("app", "a", "2"))
+				must(t, k.DelIn("app", "a"))
+			},
+			checks: []check{
+				{ns: "app", keys: []string{}, missing: []string{"a"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			k, err := New(dir, 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.writes(t, k)
+
+			// The store must look the same before and after replaying its WAL
+			for _, phase := range []string{"live", "replayed"} {
+				if phase == "replayed" {
+					k = reopen(t, k, dir)
+				}
+				for _, c := range tt.checks {
+					keys, err := k.KeysIn(c.ns)
+					if c.keys == nil {
+						if !errors.Is(err, ErrNamespaceNotFound) {
+							t.Errorf("%s: namespace %q: err = %v, want ErrNamespaceNotFound", phase, c.ns, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatalf("%s: namespace %q: %v", phase, c.ns, err)
+					}
+					if !reflect.DeepEqual(keys, c.keys) {
+						t.Errorf("%s: namespace %q keys = %q, want %q", phase, c.ns, keys, c.keys)
+					}
+					for key, want := range c.values {
+						if got, err := k.GetIn(c.ns, key); err != nil || got != want {
+							t.Errorf("%s: %s/%s = %q, %v, want %q", phase, c.ns, key, got, err, want)
+						}
+					}
+					for _, key := range c.missing {
+						if _, err := k.GetIn(c.ns, key); !errors.Is(err, ErrNotFound) {
+							t.Errorf("%s: %s/%s: err = %v, want ErrNotFound", phase, c.ns, key, err)
+						}
+					}
+					info, err := k.Namespace(c.ns)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if info.Quota != c.quota {
+						t.Errorf("%s: namespace %q quota = %v, want %v", phase, c.ns, info.Quota, c.quota)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceErrors(t *testing.T) {
+	k, err := New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	must(t, k.CreateNamespace("app", Quota{}))
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"create existing", k.CreateNamespace("app", Quota{}), ErrNamespaceExists},
+		{"drop missing", k.DropNamespace("missing"), ErrNamespaceNotFound},
+		{"quota of missing", k.SetQuota("missing", Quota{MaxKeys: 1}), ErrNamespaceNotFound},
+		{"set in missing", k.SetIn("missing", "a", "1"), ErrNamespaceNotFound},
+		{"del in missing", k.DelIn("missing", "a"), ErrNamespaceNotFound},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package kvs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Snapshot is a point-in-time view of the keys in one namespace of a Kvs.
+// Only key offsets are captured; values are read from the WAL on demand.
+// WAL records are never rewritten, so the view stays consistent while
+// writes continue, even if the namespace is dropped. Keys are sorted on
+// first use rather than while the store is locked.
+type Snapshot struct {
+	kvs       *Kvs
+	namespace string
+	quota     Quota
+	keys      []string
+	offsets   map[string]int64
+	sorted    sync.Once
+}
+
+// Snapshot captures all keys with the given prefix ("" for all keys)
+func (k *Kvs) Snapshot(prefix string) *Snapshot {
+	snap, _ := k.SnapshotIn(DefaultNamespace, prefix) // the default namespace always exists
+	return snap
+}
+
+// SnapshotIn captures all keys in the namespace ns with the given prefix
+func (k *Kvs) SnapshotIn(ns, prefix string) (*Snapshot, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	n, err := k.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+	return k.snapshot(ns, n, prefix), nil
+}
+
+// SnapshotAll captures every namespace at the same point, in name order
+// with the default namespace first
+func (k *Kvs) SnapshotAll() []*Snapshot {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	snaps := make([]*Snapshot, 0, len(k.namespaces))
+	for name, n := range k.namespaces {
+		snaps = append(snaps, k.snapshot(name, n, ""))
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].namespace < snaps[j].namespace
+	})
+	return snaps
+}
+
+// snapshot captures the keys of n with the given prefix. Caller must hold mu.
+func (k *Kvs) snapshot(name string, n *namespace, prefix string) *Snapshot {
+	snap := &Snapshot{
+		kvs:       k,
+		namespace: name,
+		quota:     n.quota,
+		keys:      make([]string, 0),
+		offsets:   make(map[string]int64),
+	}
+	for key, e := range n.index {
+		if strings.HasPrefix(key, prefix) {
+			snap.keys = append(snap.keys, key)
+			snap.offsets[key] = e.offset
+		}
+	}
+
+	return snap
+}
+
+// Filter returns a snapshot of the keys for which match returns true
+func (s *Snapshot) Filter(match func(key string) bool) *Snapshot {
+	filtered := &Snapshot{
+		kvs:       s.kvs,
+		namespace: s.namespace,
+		quota:     s.quota,
+		keys:      make([]string, 0, len(s.keys)),
+		offsets:   make(map[string]int64),
+	}
+	for _, key := range s.keys {
+		if match(key) {
+			filtered.keys = append(filtered.keys, key)
+			filtered.offsets[key] = s.offsets[key]
+		}
+	}
+	return filtered
+}
+
+// Namespace returns the name of the namespace the snapshot was taken of
+func (s *Snapshot) Namespace() string {
+	return s.namespace
+}
+
+// Quota returns the namespace's quota as of the snapshot
+func (s *Snapshot) Quota() Quota {
+	return s.quota
+}
+
+// Len returns the number of keys in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.keys)
+}
+
+// Keys returns the snapshot's keys in order
+func (s *Snapshot) Keys() []string {
+	s.sorted.Do(func() { sort.Strings(s.keys) })
+	return s.keys
+}
+
+// Get returns the value of key as of the snapshot
+func (s *Snapshot) Get(key string) (string, bool, error) {
+	offset, exists := s.offsets[key]
+	if !exists {
+		return "", false, nil
+	}
+
+	cmd, err := s.kvs.read(offset)
+	if err != nil {
+		return "", false, err
+	}
+
+	return cmd.Val, true, nil
+}
+
+// Each calls fn for every key in order with its value as of the snapshot
+func (s *Snapshot) Each(fn func(key, val string) error) error {
+	for _, key := range s.Keys() {
+		val, _, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(key, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}